@@ -0,0 +1,228 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A local output can archive more than the PDF of each job, in files next to
+// it with the same name:
+//
+//   - .txt: the job's text, in the same form a filter command reads it: lines
+//     end with a newline, lines that the next line prints over top of
+//     (overstrikes) end with a carriage return instead, and each new page
+//     starts with a form feed.
+//   - .json: a manifest describing the job.
+//   - .job.zst: the job's zstd-compressed print job stream, the same one an
+//     online output sends to the print API, which can be printed again later
+//     with a different profile.
+
+// File name extensions of the archive files.
+const (
+	archiveTextExt       = "txt"
+	archiveManifestExt   = "json"
+	archiveDirectivesExt = "job.zst"
+)
+
+func validateArchive(name string, config OutputConfig) []error {
+	var errs []error
+
+	if config.Mode != "local" && (config.ArchiveText ||
+		config.ArchiveMetadata || config.ArchiveDirectives) {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'archive_text', 'archive_metadata', and "+
+				"'archive_directives' are only used in local mode", name))
+	}
+
+	return errs
+}
+
+// jobManifest is the .json file describing an archived job.
+type jobManifest struct {
+	Job          manifestJob `json:"job"`
+	Input        string      `json:"input"`
+	Profile      string      `json:"profile"`
+	PrinterModel string      `json:"printer_model"`
+	Lines        int         `json:"lines"`
+	Pages        int         `json:"pages"`
+
+	// Received is when the output received the first of the job, and
+	// Finished is when it received the end of the job. Written is when the
+	// PDF was finished.
+	Received time.Time `json:"received"`
+	Finished time.Time `json:"finished"`
+	Written  time.Time `json:"written"`
+}
+
+// manifestJob is the job metadata in a manifest.
+type manifestJob struct {
+	Info       string     `json:"info"`
+	Name       string     `json:"name,omitempty"`
+	Number     string     `json:"number,omitempty"`
+	Type       string     `json:"type,omitempty"`
+	Programmer string     `json:"programmer,omitempty"`
+	Room       string     `json:"room,omitempty"`
+	Class      string     `json:"class,omitempty"`
+	Start      *time.Time `json:"start,omitempty"`
+	End        *time.Time `json:"end,omitempty"`
+}
+
+// jobArchive collects what a local output archives about the job it is
+// printing.
+type jobArchive struct {
+	text, metadata bool
+
+	// stream is the job's print job stream, if we're archiving it.
+	stream *jobStream
+
+	lines    int
+	received time.Time
+	finished time.Time
+}
+
+// newJobArchive returns the archive for an output's jobs, or nil if it
+// doesn't archive anything beyond the PDF.
+func newJobArchive(output OutputConfig) *jobArchive {
+	if !output.ArchiveText && !output.ArchiveMetadata &&
+		!output.ArchiveDirectives {
+		return nil
+	}
+	a := &jobArchive{text: output.ArchiveText,
+		metadata: output.ArchiveMetadata}
+	if output.ArchiveDirectives {
+		a.stream = newJobStream()
+	}
+	return a
+}
+
+// start notes that the job received something.
+func (a *jobArchive) start() {
+	if a.received.IsZero() {
+		a.received = time.Now()
+	}
+}
+
+func (a *jobArchive) addLine(line string, linefeed bool) {
+	a.start()
+	a.lines++
+	if a.stream != nil {
+		a.stream.addLine(line, linefeed)
+	}
+}
+
+func (a *jobArchive) pageBreak() {
+	a.start()
+	if a.stream != nil {
+		a.stream.pageBreak()
+	}
+}
+
+func (a *jobArchive) skipToChannel(channel int) {
+	a.start()
+	if a.stream != nil {
+		a.stream.skipToChannel(channel)
+	}
+}
+
+// endJob notes that the job has ended.
+func (a *jobArchive) endJob() {
+	a.start()
+	a.finished = time.Now()
+}
+
+// reset gets the archive ready for the next job.
+func (a *jobArchive) reset() {
+	a.lines = 0
+	a.received = time.Time{}
+	a.finished = time.Time{}
+	if a.stream != nil {
+		a.stream = newJobStream()
+	}
+}
+
+// writeFiles writes the archive files for a job to temporary files in dir,
+// to be committed along with the job's PDF. text is the job's text, and
+// manifest is the manifest with the fields that the archive doesn't know
+// filled in.
+func (a *jobArchive) writeFiles(dir string, job scanner.JobMetadata,
+	text []byte, manifest jobManifest) ([]*outputFile, error) {
+
+	var files []*outputFile
+	var err error
+	write := func(ext string, data []byte) {
+		if err != nil {
+			return
+		}
+		var f *outputFile
+		if f, err = createOutputFile(dir, ext); err != nil {
+			return
+		}
+		files = append(files, f)
+		_, err = f.Write(data)
+	}
+
+	if a.text {
+		write(archiveTextExt, text)
+	}
+	if a.metadata {
+		manifest.Job = newManifestJob(job)
+		manifest.Lines = a.lines
+		manifest.Received = a.received
+		manifest.Finished = a.finished
+		// The manifest is only strings, numbers, and times, so this can't
+		// fail.
+		data, _ := json.MarshalIndent(manifest, "", "  ")
+		write(archiveManifestExt, append(data, '\n'))
+	}
+	if a.stream != nil {
+		write(archiveDirectivesExt, a.stream.end(job))
+	}
+
+	if err != nil {
+		for _, f := range files {
+			f.discard()
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+func newManifestJob(job scanner.JobMetadata) manifestJob {
+	m := manifestJob{
+		Info:       job.JobInfo(),
+		Name:       job.Name,
+		Number:     job.Number,
+		Type:       job.Type,
+		Programmer: job.Programmer,
+		Room:       job.Room,
+		Class:      job.Class,
+	}
+	if !job.Start.IsZero() {
+		m.Start = &job.Start
+	}
+	if !job.End.IsZero() {
+		m.End = &job.End
+	}
+	return m
+}
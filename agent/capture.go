@@ -0,0 +1,106 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// captureConn is a recording connection that also closes the capture file
+// when the connection is closed.
+type captureConn struct {
+	net.Conn
+	f *os.File
+}
+
+func (c *captureConn) Close() error {
+	err := c.Conn.Close()
+	if ferr := c.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// startCapture begins recording everything received on conn to a new
+// capture file in dir. If we can't create the capture file, we log the
+// problem and return the original connection; we'd rather keep printing
+// than fail because of a debugging feature.
+func startCapture(conn net.Conn, dir, inputName string) net.Conn {
+	filename := filepath.Join(dir, "capture-"+inputName+"-"+
+		time.Now().UTC().Format("20060102T150405")+".v1403cap")
+
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't create capture file: %v", inputName,
+			err)
+		return conn
+	}
+
+	rc, err := scanner.NewRecordingConn(conn, f)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't write capture file: %v", inputName,
+			err)
+		f.Close()
+		return conn
+	}
+
+	log.Printf("INFO:  [%s] recording print data to %s", inputName, filename)
+	return &captureConn{Conn: rc, f: f}
+}
+
+// runReplay plays back a capture file through the Hercules scanner, using
+// the job separation and codepage settings of input, to output.
+func runReplay(ctx context.Context, input InputConfig, output OutputConfig,
+	filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("FATAL: Couldn't open file [%s]: %v", filename, err)
+	}
+	defer f.Close()
+
+	conn, err := scanner.NewReplayConn(f)
+	if err != nil {
+		log.Fatalf("FATAL: [%s] %v", filename, err)
+	}
+
+	handler, err := newOutputHandler(output, "replay")
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	err = scanner.ScanContext(ctx, conn, handler,
+		scanOptions(input, output, "replay"))
+	if errors.Is(err, context.Canceled) {
+		log.Printf("INFO:  [replay] stopped before the end of capture file")
+		return
+	}
+	if err != io.EOF {
+		log.Fatalf("FATAL: error replaying capture: %v", err)
+	}
+	log.Printf("INFO:  [replay] end of capture file")
+}
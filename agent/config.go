@@ -21,25 +21,142 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/racingmars/virtual1403/scanner"
+	"github.com/racingmars/virtual1403/vprinter"
 )
 
 type OutputConfig struct {
-	Mode           string `yaml:"mode"`
-	ServiceAddress string `yaml:"service_address"`
-	APIKey         string `yaml:"access_key"`
-	OutputDir      string `yaml:"output_directory"`
-	FontFile       string `yaml:"font_file"`
-	Profile        string `yaml:"profile"`
-	font           []byte
+	Mode               string         `yaml:"mode"`
+	ServiceAddress     string         `yaml:"service_address"`
+	APIKey             string         `yaml:"access_key"`
+	OutputDir          string         `yaml:"output_directory"`
+	FontFile           string         `yaml:"font_file"`
+	Profile            string         `yaml:"profile"`
+	FCB                string         `yaml:"fcb"`
+	FCBDefinition      *FCBDefinition `yaml:"fcb_definition"`
+	PrinterModel       string         `yaml:"printer_model"`
+	LineWidth          int            `yaml:"line_width"`
+	LineOverflow       string         `yaml:"line_overflow"`
+	OnlineSpoolDir     string         `yaml:"online_spool_directory"`
+	OnlineSpoolMaxJobs int            `yaml:"online_spool_max_jobs"`
+	OnlineSpoolMaxAge  int            `yaml:"online_spool_max_age_hours"`
+	FilterCommand      []string       `yaml:"filter_command"`
+	PostJobCommand     []string       `yaml:"post_job_command"`
+	HookTimeout        int            `yaml:"hook_timeout_seconds"`
+	FilenameTemplate   string         `yaml:"filename_template"`
+	ArchiveText        bool           `yaml:"archive_text"`
+	ArchiveMetadata    bool           `yaml:"archive_metadata"`
+	ArchiveDirectives  bool           `yaml:"archive_directives"`
+	RetentionDays      int            `yaml:"retention_days"`
+	RetentionMaxJobs   int            `yaml:"retention_max_jobs"`
+	RetentionMaxMB     int            `yaml:"retention_max_mb"`
+	RetentionDryRun    bool           `yaml:"retention_dry_run"`
+	RetentionArchive   string         `yaml:"retention_archive_directory"`
+	font               []byte
+	fcb                *vprinter.FCB
+	model              vprinter.PrinterModel
+	overflow           scanner.OverflowPolicy
+	spool              *onlineSpool
+	stats              *outputStats
+	filenames          *filenameTemplate
+}
+
+// FCBDefinition describes a forms control buffer in the configuration file:
+// the length of the form in lines, and the lines punched in each channel.
+type FCBDefinition struct {
+	Lines    int           `yaml:"lines"`
+	Channels map[int][]int `yaml:"channels"`
 }
 
 type InputConfig struct {
-	HerculesAddress string `yaml:"hercules_address"`
-	Output          string `yaml:"output"`
+	Type            string        `yaml:"type"`
+	HerculesAddress string        `yaml:"hercules_address"`
+	Output          string        `yaml:"output"`
+	Format          string        `yaml:"format"`
+	EOJDetector     string        `yaml:"eoj_detector"`
+	JobStartRegex   string        `yaml:"job_start_regex"`
+	JobEndRegex     string        `yaml:"job_end_regex"`
+	Codepage        string        `yaml:"codepage"`
+	Unmappable      string        `yaml:"unmappable"`
+	CaptureDir      string        `yaml:"capture_directory"`
+	SpoolDir        string        `yaml:"spool_directory"`
+	SpoolPattern    string        `yaml:"spool_pattern"`
+	SpoolQuietTime  int           `yaml:"spool_quiet_seconds"`
+	SpoolArchiveDir string        `yaml:"spool_archive_directory"`
+	SpoolFollow     bool          `yaml:"spool_follow"`
+	ListenAddress   string        `yaml:"listen_address"`
+	AllowedClients  []string      `yaml:"allowed_clients"`
+	Queues          []QueueConfig `yaml:"queues"`
+	Encoding        string        `yaml:"encoding"`
+	DeckSeparator   string        `yaml:"deck_separator"`
+	DeckTimeout     int           `yaml:"deck_timeout_seconds"`
+	Routes          []RouteConfig `yaml:"routes"`
+	eoj             scanner.EOJDetector
+	codepage        *scanner.Codepage
+	unmappable      scanner.UnmappablePolicy
+	allowed         []*net.IPNet
+	queueOutputs    map[string]OutputConfig
+	separator       *regexp.Regexp
+	routes          []route
+	routeOutputs    map[string]OutputConfig
+}
+
+// QueueConfig maps a print server queue name to the output, format, and
+// profile used for the jobs sent to it.
+type QueueConfig struct {
+	Name    string `yaml:"name"`
+	Output  string `yaml:"output"`
+	Format  string `yaml:"format"`
+	Profile string `yaml:"profile"`
+}
+
+// Input types. A "hercules" input connects to a Hercules sockdev printer; a
+// "spool" input prints the files that appear in a directory; a "listen"
+// input waits for emulators to connect to it; "lpd", "ipp", and "raw" inputs
+// are network print servers; and a "punch" input connects to a Hercules
+// sockdev card punch.
+const (
+	inputHercules = "hercules"
+	inputSpool    = "spool"
+	inputListen   = "listen"
+	inputLPD      = "lpd"
+	inputIPP      = "ipp"
+	inputRaw      = "raw"
+	inputPunch    = "punch"
+)
+
+// inputType returns the type of an input configuration, which is "hercules"
+// if it isn't set.
+func inputType(config InputConfig) string {
+	if config.Type == "" {
+		return inputHercules
+	}
+	return strings.ToLower(config.Type)
+}
+
+// ReaderConfig is a Hercules sockdev card reader that we submit decks to.
+type ReaderConfig struct {
+	HerculesAddress  string `yaml:"hercules_address"`
+	Encoding         string `yaml:"encoding"`
+	Codepage         string `yaml:"codepage"`
+	SubmitDir        string `yaml:"submit_directory"`
+	SubmitArchiveDir string `yaml:"submit_archive_directory"`
+	codepage         *scanner.Codepage
+}
+
+// connectsToHercules returns true if an input connects to a Hercules socket
+// device at its hercules_address.
+func connectsToHercules(config InputConfig) bool {
+	t := inputType(config)
+	return t == inputHercules || t == inputPunch
 }
 
 type Configuration struct {
@@ -53,21 +170,26 @@ type Configuration struct {
 		Name         string `yaml:"name"`
 		OutputConfig `yaml:",inline"`
 	} `yaml:"outputs"`
+	Readers []struct {
+		Name         string `yaml:"name"`
+		ReaderConfig `yaml:",inline"`
+	} `yaml:"readers"`
+	Status StatusConfig `yaml:"status"`
 }
 
 func loadConfig(path string) (map[string]InputConfig, map[string]OutputConfig,
-	error) {
+	map[string]ReaderConfig, StatusConfig, error) {
 
 	var c Configuration
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, StatusConfig{}, err
 	}
 	defer f.Close()
 
 	decoder := yaml.NewDecoder(f)
 	if err := decoder.Decode(&c); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, StatusConfig{}, err
 	}
 
 	inputs := make(map[string]InputConfig)
@@ -75,7 +197,7 @@ func loadConfig(path string) (map[string]InputConfig, map[string]OutputConfig,
 	inputs["default"] = c.InputConfig
 	for _, i := range c.Inputs {
 		if strings.TrimSpace(i.Name) == "" {
-			return nil, nil, errors.New(
+			return nil, nil, nil, StatusConfig{}, errors.New(
 				"all inputs require a value in the \"name\" field")
 		}
 		inputs[i.Name] = i.InputConfig
@@ -85,13 +207,22 @@ func loadConfig(path string) (map[string]InputConfig, map[string]OutputConfig,
 	outputs["default"] = c.OutputConfig
 	for _, o := range c.Outputs {
 		if strings.TrimSpace(o.Name) == "" {
-			return nil, nil, errors.New(
+			return nil, nil, nil, StatusConfig{}, errors.New(
 				"all outputs require a value in the \"name\" field")
 		}
 		outputs[o.Name] = o.OutputConfig
 	}
 
-	return inputs, outputs, nil
+	readers := make(map[string]ReaderConfig)
+	for _, r := range c.Readers {
+		if strings.TrimSpace(r.Name) == "" {
+			return nil, nil, nil, StatusConfig{}, errors.New(
+				"all readers require a value in the \"name\" field")
+		}
+		readers[r.Name] = r.ReaderConfig
+	}
+
+	return inputs, outputs, readers, c.Status, nil
 }
 
 func validateConfig(inputs map[string]InputConfig,
@@ -100,11 +231,48 @@ func validateConfig(inputs map[string]InputConfig,
 	var errs []error
 
 	for name, config := range inputs {
-		if config.HerculesAddress == "" {
+		switch inputType(config) {
+		case inputHercules:
+			if config.HerculesAddress == "" {
+				errs = append(errs,
+					fmt.Errorf(
+						"input [%s] must set 'hercules_address'",
+						name))
+			}
+		case inputSpool:
+			errs = append(errs, validateSpoolConfig(name, config)...)
+		case inputListen:
+			errs = append(errs, validateListenConfig(name, config)...)
+		case inputLPD:
+			errs = append(errs, validateListenConfig(name, config)...)
+			errs = append(errs, validateQueues(name, config, outputs)...)
+		case inputIPP:
+			errs = append(errs, validateListenConfig(name, config)...)
+			errs = append(errs, validateQueues(name, config, outputs)...)
+		case inputRaw:
+			errs = append(errs, validateListenConfig(name, config)...)
+		case inputPunch:
+			errs = append(errs, validatePunchConfig(name, config)...)
+		default:
 			errs = append(errs,
 				fmt.Errorf(
-					"input [%s] must set 'hercules_address'",
-					name))
+					"input [%s] 'type' must be one of 'hercules', 'spool', "+
+						"'listen', 'lpd', 'ipp', 'raw', or 'punch'", name))
+		}
+
+		if err := validateFormat(config.Format); err != nil {
+			errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+		}
+		// Connections from Hercules and other emulators always send the
+		// Hercules printer data stream.
+		if t := inputType(config); t == inputHercules || t == inputListen {
+			if f := strings.ToLower(config.Format); f != "" &&
+				f != formatHercules {
+				errs = append(errs,
+					fmt.Errorf(
+						"input [%s] 'format' must be 'hercules' for %s "+
+							"inputs", name, t))
+			}
 		}
 
 		if config.Output == "" {
@@ -114,6 +282,21 @@ func validateConfig(inputs map[string]InputConfig,
 					name))
 		}
 
+		if _, err := newEOJDetector(config); err != nil {
+			errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+		}
+
+		if _, err := scanner.LookupCodepage(config.Codepage); err != nil {
+			errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+		}
+
+		if _, err := scanner.ParseUnmappablePolicy(
+			config.Unmappable); err != nil {
+			errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+		}
+
+		errs = append(errs, validateRoutes(name, config, outputs)...)
+
 		if _, ok := outputs[config.Output]; !ok {
 			errs = append(errs,
 				fmt.Errorf(
@@ -125,12 +308,21 @@ func validateConfig(inputs map[string]InputConfig,
 		// device.
 		for othername, otherconfig := range inputs {
 			if othername != name &&
+				connectsToHercules(config) &&
+				connectsToHercules(otherconfig) &&
 				otherconfig.HerculesAddress == config.HerculesAddress {
 				errs = append(errs,
 					fmt.Errorf("input [%s] and input [%s] have the same "+
 						"'hercules_address'; this is not allowed",
 						name, othername))
 			}
+			if othername != name && config.ListenAddress != "" &&
+				otherconfig.ListenAddress == config.ListenAddress {
+				errs = append(errs,
+					fmt.Errorf("input [%s] and input [%s] have the same "+
+						"'listen_address'; this is not allowed",
+						name, othername))
+			}
 		}
 	}
 
@@ -148,6 +340,42 @@ func validateConfig(inputs map[string]InputConfig,
 					fmt.Errorf("output [%s] must set 'output_directory'",
 						name))
 			}
+			if _, err := parseFilenameTemplate(
+				config.FilenameTemplate); err != nil {
+				errs = append(errs,
+					fmt.Errorf("output [%s] 'filename_template' %v", name,
+						err))
+			}
+		} else if config.FilenameTemplate != "" {
+			errs = append(errs,
+				fmt.Errorf("output [%s] 'filename_template' is only used "+
+					"in local mode", name))
+		}
+
+		if _, err := newFCB(config); err != nil {
+			errs = append(errs, fmt.Errorf("output [%s]: %v", name, err))
+		}
+		if _, err := newPrinterModel(config); err != nil {
+			errs = append(errs, fmt.Errorf("output [%s]: %v", name, err))
+		}
+		if _, err := scanner.ParseOverflowPolicy(
+			config.LineOverflow); err != nil {
+			errs = append(errs, fmt.Errorf("output [%s]: %v", name, err))
+		}
+		errs = append(errs, validateOnlineSpool(name, config)...)
+		errs = append(errs, validateHooks(name, config)...)
+		errs = append(errs, validateArchive(name, config)...)
+		errs = append(errs, validateRetention(name, config, inputs,
+			outputs)...)
+		for othername, otherconfig := range outputs {
+			if othername < name && config.OnlineSpoolDir != "" &&
+				filepath.Clean(otherconfig.OnlineSpoolDir) ==
+					filepath.Clean(config.OnlineSpoolDir) {
+				errs = append(errs,
+					fmt.Errorf("output [%s] and output [%s] have the same "+
+						"'online_spool_directory'; this is not allowed",
+						othername, name))
+			}
 		}
 
 		if config.Mode == "online" {
@@ -164,3 +392,56 @@ func validateConfig(inputs map[string]InputConfig,
 
 	return errs
 }
+
+// newEOJDetector creates the end-of-job detector requested by an input
+// configuration. The "regex" detector uses the input's job_start_regex and
+// job_end_regex patterns; all others are built in to the scanner.
+func newEOJDetector(config InputConfig) (scanner.EOJDetector, error) {
+	if strings.ToLower(config.EOJDetector) == "regex" {
+		return scanner.NewRegexpEOJDetector(config.JobStartRegex,
+			config.JobEndRegex)
+	}
+
+	if config.JobStartRegex != "" || config.JobEndRegex != "" {
+		return nil, errors.New("'job_start_regex' and 'job_end_regex' " +
+			"require 'eoj_detector' to be \"regex\"")
+	}
+
+	d, err := scanner.NewEOJDetector(config.EOJDetector)
+	if err != nil {
+		return nil, fmt.Errorf("%v; must be one of: regex, %s", err,
+			strings.Join(scanner.EOJDetectorNames(), ", "))
+	}
+	return d, nil
+}
+
+// newFCB creates the forms control buffer requested by an output
+// configuration, either from a Hercules-style 'fcb' specification or from an
+// 'fcb_definition'. Returns nil if the output uses the printer's default FCB.
+func newFCB(config OutputConfig) (*vprinter.FCB, error) {
+	if config.FCB != "" && config.FCBDefinition != nil {
+		return nil, errors.New("only one of 'fcb' and 'fcb_definition' " +
+			"may be set")
+	}
+	if config.FCB != "" {
+		return vprinter.ParseFCB(config.FCB)
+	}
+	if config.FCBDefinition != nil {
+		return vprinter.NewFCB(config.FCBDefinition.Lines,
+			config.FCBDefinition.Channels)
+	}
+	return nil, nil
+}
+
+// newPrinterModel returns the printer model requested by an output
+// configuration, with its line width overridden by 'line_width' if set.
+func newPrinterModel(config OutputConfig) (vprinter.PrinterModel, error) {
+	model, err := vprinter.LookupPrinterModel(config.PrinterModel)
+	if err != nil {
+		return model, err
+	}
+	if config.LineWidth != 0 {
+		return model.WithColumns(config.LineWidth)
+	}
+	return model, nil
+}
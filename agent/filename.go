@@ -0,0 +1,325 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A local output names its files with a filename template, a path relative
+// to the output directory with {field}s that are filled in for each job, to
+// which the file name extension is added. Files are written under a
+// temporary name and then renamed, so programs watching the directory never
+// see a partial file, and a number is added to the name if a file by that
+// name already exists.
+
+// defaultFilenameTemplate is the file name used when an output doesn't set
+// filename_template.
+const defaultFilenameTemplate = "v1403-{jobinfo}-{timestamp}"
+
+// filenameFields are the values for the template fields, which are
+// functions of the job being named.
+var filenameFields = map[string]func(f nameFields) string{
+	"jobinfo":   func(f nameFields) string { return f.job.JobInfo() },
+	"jobname":   func(f nameFields) string { return f.job.Name },
+	"jobnumber": func(f nameFields) string { return f.job.Number },
+	"jobtype":   func(f nameFields) string { return f.job.Type },
+	"class":     func(f nameFields) string { return f.job.Class },
+	"input":     func(f nameFields) string { return f.input },
+	"pages":     func(f nameFields) string { return strconv.Itoa(f.pages) },
+	"yyyy":      func(f nameFields) string { return f.time.Format("2006") },
+	"yy":        func(f nameFields) string { return f.time.Format("06") },
+	"mm":        func(f nameFields) string { return f.time.Format("01") },
+	"dd":        func(f nameFields) string { return f.time.Format("02") },
+	"hh":        func(f nameFields) string { return f.time.Format("15") },
+	"mi":        func(f nameFields) string { return f.time.Format("04") },
+	"ss":        func(f nameFields) string { return f.time.Format("05") },
+	"timestamp": func(f nameFields) string {
+		return f.time.Format("20060102T150405")
+	},
+}
+
+// nameFields is what we know about a job when naming its files.
+type nameFields struct {
+	job   scanner.JobMetadata
+	input string
+	pages int
+	time  time.Time
+}
+
+// filenameTemplate is a compiled filename template: literal text, and the
+// fields to fill in between it.
+type filenameTemplate struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string
+}
+
+var templateField = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// parseFilenameTemplate compiles a filename template, using the default
+// template if t is empty.
+func parseFilenameTemplate(t string) (*filenameTemplate, error) {
+	if t == "" {
+		t = defaultFilenameTemplate
+	}
+	if path.IsAbs(t) || filepath.IsAbs(t) || strings.Contains(t, `\`) {
+		return nil, errors.New("must be a path relative to " +
+			"'output_directory', using '/' between directories")
+	}
+	for _, dir := range strings.Split(t, "/") {
+		if dir == "" || dir == "." || dir == ".." {
+			return nil, fmt.Errorf("can't contain the directory `%s`", dir)
+		}
+	}
+
+	var tmpl filenameTemplate
+	last := 0
+	for _, m := range templateField.FindAllStringSubmatchIndex(t, -1) {
+		field := t[m[2]:m[3]]
+		if _, ok := filenameFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field `{%s}`", field)
+		}
+		tmpl.parts = append(tmpl.parts,
+			templatePart{literal: t[last:m[0]]}, templatePart{field: field})
+		last = m[1]
+	}
+	tmpl.parts = append(tmpl.parts, templatePart{literal: t[last:]})
+	for _, p := range tmpl.parts {
+		if strings.ContainsAny(p.literal, "{}") {
+			return nil, errors.New("has a `{` or `}` that isn't part of " +
+				"a field")
+		}
+	}
+	return &tmpl, nil
+}
+
+// unsafeFilename are the characters we don't put in file names from the
+// fields, which could be anything the mainframe printed.
+var unsafeFilename = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// expand returns the path, relative to the output directory and without an
+// extension, for a job. A field that is empty is left out along with the
+// '-' or '_' that follows it, so "v1403-{jobinfo}-{timestamp}" is just
+// "v1403-{timestamp}" when the job has no name.
+func (t *filenameTemplate) expand(f nameFields) string {
+	var b strings.Builder
+	skipSeparator := false
+	for _, p := range t.parts {
+		if p.field == "" {
+			literal := p.literal
+			if skipSeparator && literal != "" &&
+				(literal[0] == '-' || literal[0] == '_') {
+				literal = literal[1:]
+			}
+			b.WriteString(literal)
+			skipSeparator = false
+			continue
+		}
+		value := unsafeFilename.ReplaceAllString(filenameFields[p.field](f),
+			"_")
+		if value == "." || value == ".." {
+			value = "_"
+		}
+		b.WriteString(value)
+		skipSeparator = value == ""
+	}
+
+	// Empty fields could also leave a directory with no name.
+	var dirs []string
+	for _, dir := range strings.Split(b.String(), "/") {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return "v1403"
+	}
+	return path.Join(dirs...)
+}
+
+// filenameFieldPatterns match the values of the fields that are never
+// empty. The other fields can be anything expand leaves in a file name,
+// including nothing.
+var filenameFieldPatterns = map[string]string{
+	"pages":     `[0-9]+`,
+	"yyyy":      `[0-9]{4}`,
+	"yy":        `[0-9]{2}`,
+	"mm":        `[0-9]{2}`,
+	"dd":        `[0-9]{2}`,
+	"hh":        `[0-9]{2}`,
+	"mi":        `[0-9]{2}`,
+	"ss":        `[0-9]{2}`,
+	"timestamp": `[0-9]{8}T[0-9]{6}`,
+}
+
+// pattern returns a regular expression that matches every path expand could
+// return, with or without the number commitOutputFiles adds when a name is
+// taken, so that we can tell which files in an output directory are ours.
+func (t *filenameTemplate) pattern() *regexp.Regexp {
+	// canBeEmpty returns true if the field in part i can be empty.
+	canBeEmpty := func(i int) bool {
+		_, ok := filenameFieldPatterns[t.parts[i].field]
+		return !ok
+	}
+
+	var b strings.Builder
+	b.WriteString("^(?:")
+	for i, p := range t.parts {
+		if p.field != "" {
+			if re, ok := filenameFieldPatterns[p.field]; ok {
+				b.WriteString(re)
+			} else {
+				b.WriteString(`[a-zA-Z0-9_.-]*`)
+			}
+			continue
+		}
+
+		// An empty field takes the separator after it with it, and may
+		// leave a directory with no name, which is dropped.
+		literal := p.literal
+		afterEmpty := i > 0 && canBeEmpty(i-1)
+		beforeEmpty := i < len(t.parts)-1 && canBeEmpty(i+1)
+		if afterEmpty && literal != "" &&
+			(literal[0] == '-' || literal[0] == '_') {
+			b.WriteString(regexp.QuoteMeta(literal[:1]) + "?")
+			literal = literal[1:]
+		}
+		if afterEmpty && strings.HasPrefix(literal, "/") {
+			b.WriteString("/?")
+			literal = literal[1:]
+		}
+		trailingSlash := beforeEmpty && strings.HasSuffix(literal, "/")
+		if trailingSlash {
+			literal = literal[:len(literal)-1]
+		}
+		b.WriteString(regexp.QuoteMeta(literal))
+		if trailingSlash {
+			b.WriteString("/?")
+		}
+	}
+	b.WriteString(`|v1403)(?:-[0-9]+)?$`)
+	return regexp.MustCompile(b.String())
+}
+
+// outputFile is a file being written for a job, under a temporary name until
+// it is committed.
+type outputFile struct {
+	*os.File
+	ext string
+}
+
+// createOutputFile creates a temporary file in dir, to be named with the
+// extension ext when it is committed.
+func createOutputFile(dir, ext string) (*outputFile, error) {
+	f, err := os.CreateTemp(dir, ".v1403-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files only we can read, but the files we write are
+	// for everyone.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &outputFile{File: f, ext: ext}, nil
+}
+
+// discard closes and removes a file that won't be committed.
+func (f *outputFile) discard() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// commitMu keeps two handlers from choosing the same name for their files.
+var commitMu sync.Mutex
+
+// commitOutputFiles closes files, which must already be written, and gives
+// them their final names in dir: the template expanded for the job, with
+// each file's extension. If any of those names are taken, a number is added
+// to all of them. It returns the final names.
+func commitOutputFiles(dir string, tmpl *filenameTemplate, fields nameFields,
+	files ...*outputFile) ([]string, error) {
+
+	var err error
+	for _, f := range files {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		for _, f := range files {
+			os.Remove(f.Name())
+		}
+		return nil, err
+	}
+
+	base := filepath.Join(dir, filepath.FromSlash(tmpl.expand(fields)))
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		for _, f := range files {
+			os.Remove(f.Name())
+		}
+		return nil, err
+	}
+
+	commitMu.Lock()
+	defer commitMu.Unlock()
+
+	names := make([]string, len(files))
+	for n := 1; ; n++ {
+		taken := false
+		for i, f := range files {
+			names[i] = base + "." + f.ext
+			if n > 1 {
+				names[i] = fmt.Sprintf("%s-%d.%s", base, n, f.ext)
+			}
+			if _, err := os.Lstat(names[i]); err == nil {
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+	}
+
+	for i, f := range files {
+		if err := os.Rename(f.Name(), names[i]); err != nil {
+			for _, f := range files[i:] {
+				os.Remove(f.Name())
+			}
+			return nil, err
+		}
+	}
+	return names, nil
+}
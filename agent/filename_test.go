@@ -0,0 +1,188 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+func TestParseFilenameTemplate(t *testing.T) {
+	type testcase struct {
+		template string
+		valid    bool
+	}
+	var testcases []testcase = []testcase{
+		{"", true},
+		{"v1403-{jobinfo}-{timestamp}", true},
+		{"{yyyy}/{mm}/{dd}/{jobname}-{hh}{mi}{ss}", true},
+		{"{input}/{class}/{jobtype}{jobnumber}-{pages}p", true},
+		{"/tmp/{jobname}", false},
+		{`jobs\{jobname}`, false},
+		{"../{jobname}", false},
+		{"jobs/./{jobname}", false},
+		{"jobs//{jobname}", false},
+		{"{jobname}/", false},
+		{"{nosuchfield}", false},
+		{"{jobname", false},
+		{"jobname}", false},
+		{"{JOBNAME}", false},
+	}
+
+	for _, c := range testcases {
+		_, err := parseFilenameTemplate(c.template)
+		if (err == nil) != c.valid {
+			t.Errorf("Got error %v for template `%s`", err, c.template)
+		}
+	}
+}
+
+func TestFilenameExpand(t *testing.T) {
+	type testcase struct {
+		template string
+		fields   nameFields
+		output   string
+	}
+	when := time.Date(2026, 10, 17, 9, 5, 7, 0, time.UTC)
+	job := scanner.JobMetadata{Name: "MYJOB", Number: "1234", Type: "JOB",
+		Class: "A"}
+	var testcases []testcase = []testcase{
+		{"", nameFields{job: job, time: when},
+			"v1403-J1234_MYJOB-20261017T090507"},
+		{"", nameFields{time: when}, "v1403-20261017T090507"},
+		{"{yyyy}/{mm}/{dd}/{jobname}-{hh}{mi}{ss}",
+			nameFields{job: job, time: when}, "2026/10/17/MYJOB-090507"},
+		{"{yy}{mm}{dd}_{jobname}", nameFields{time: when}, "261017_"},
+		{"{input}/{class}/{jobname}", nameFields{job: job, input: "raw"},
+			"raw/A/MYJOB"},
+		{"{input}/{class}/{jobname}", nameFields{input: "raw"}, "raw"},
+		{"{jobname}-{pages}p", nameFields{job: job, pages: 3},
+			"MYJOB-3p"},
+		{"{jobname}", nameFields{job: scanner.JobMetadata{
+			Name: "MY JOB/../X"}}, "MY_JOB_.._X"},
+		{"{jobname}", nameFields{job: scanner.JobMetadata{Name: ".."}}, "_"},
+		{"{jobname}", nameFields{}, "v1403"},
+	}
+
+	for _, c := range testcases {
+		tmpl, err := parseFilenameTemplate(c.template)
+		if err != nil {
+			t.Errorf("couldn't parse template `%s`: %v", c.template, err)
+			continue
+		}
+		output := tmpl.expand(c.fields)
+		if output != c.output {
+			t.Errorf("Got `%s` instead of `%s` for template `%s`", output,
+				c.output, c.template)
+		}
+		if !tmpl.pattern().MatchString(output) {
+			t.Errorf("`%s` doesn't match the pattern for template `%s`",
+				output, c.template)
+		}
+	}
+}
+
+func TestFilenamePattern(t *testing.T) {
+	type testcase struct {
+		template string
+		path     string
+		matches  bool
+	}
+	var testcases []testcase = []testcase{
+		{"", "v1403-J1234_MYJOB-20261017T090507", true},
+		{"", "v1403-J1234_MYJOB-20261017T090507-2", true},
+		{"", "v1403-20261017T090507", true},
+		{"", "notes", false},
+		{"", "sub/v1403-J1_X-20261017T090507", false},
+		{"", "v1403-a b", false},
+		{"{yyyy}/{mm}/{jobname}", "2026/10/MYJOB", true},
+		{"{yyyy}/{mm}/{jobname}", "2026/10", true},
+		{"{yyyy}/{mm}/{jobname}", "2026/10/MYJOB/extra", false},
+		{"{yyyy}/{mm}/{jobname}", "notes", false},
+		{"{yyyy}/{mm}/{jobname}", "2026/MYJOB", false},
+		{"{jobname}/{jobnumber}/out", "out", true},
+		{"{jobname}/{jobnumber}/out", "MYJOB/out", true},
+		{"{timestamp}-{pages}", "20261017T090507-3", true},
+		{"{timestamp}-{pages}", "20261017T090507", false},
+		{"archive/{jobname}", "archive/MYJOB", true},
+		{"archive/{jobname}", "other/MYJOB", false},
+		{"archive/{jobname}", "MYJOB", false},
+	}
+
+	for _, c := range testcases {
+		tmpl, err := parseFilenameTemplate(c.template)
+		if err != nil {
+			t.Errorf("couldn't parse template `%s`: %v", c.template, err)
+			continue
+		}
+		if got := tmpl.pattern().MatchString(c.path); got != c.matches {
+			t.Errorf("Got %v instead of %v for `%s` with template `%s`",
+				got, c.matches, c.path, c.template)
+		}
+	}
+}
+
+func TestCommitOutputFilesCollisions(t *testing.T) {
+	dir := t.TempDir()
+	tmpl, err := parseFilenameTemplate("{jobname}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := nameFields{job: scanner.JobMetadata{Name: "MYJOB"}}
+
+	// Only MYJOB-2.txt is taken, but the PDF skips that number along with
+	// it so that the job's files keep the same name.
+	os.WriteFile(filepath.Join(dir, "MYJOB-2.txt"), nil, 0644)
+	var testcases [][]string = [][]string{
+		{"MYJOB.pdf", "MYJOB.txt"},
+		{"MYJOB-3.pdf", "MYJOB-3.txt"},
+		{"MYJOB-4.pdf", "MYJOB-4.txt"},
+	}
+
+	for _, want := range testcases {
+		pdf, err := createOutputFile(dir, "pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		txt, err := createOutputFile(dir, "txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := commitOutputFiles(dir, tmpl, fields, pdf, txt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := range names {
+			names[i] = filepath.Base(names[i])
+		}
+		if strings.Join(names, " ") != strings.Join(want, " ") {
+			t.Errorf("Got names %v instead of %v", names, want)
+		}
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(dir, ".v1403-*.tmp"))
+	if len(temps) != 0 {
+		t.Errorf("Got temporary files %v left behind", temps)
+	}
+}
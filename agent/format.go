@@ -0,0 +1,106 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// Formats of the print data received by inputs that are given whole files
+// rather than a Hercules sockdev connection. "hercules" is the printer
+// output stream Hercules writes, split into jobs by the input's end-of-job
+// detector. The others are the formats of the -printfile option, and print
+// each file as a single job.
+const (
+	formatHercules = "hercules"
+	formatText     = "text"
+	formatASA      = "asa"
+	formatCDC      = "cdc"
+	formatMCC      = "mcc"
+)
+
+// validateFormat returns an error if format isn't one of the formats we
+// support. An empty format is "hercules".
+func validateFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", formatHercules, formatText, formatASA, formatCDC, formatMCC:
+		return nil
+	}
+	return fmt.Errorf("unknown format `%s`; must be one of: hercules, "+
+		"text, asa, cdc, mcc", format)
+}
+
+// scanFormat prints all of r to handler using the scanner for format.
+// jobname names the job for the formats that print r as a single job, and
+// tag identifies the scanner in log messages.
+func scanFormat(ctx context.Context, r io.Reader, format, jobname string,
+	input InputConfig, output OutputConfig, handler scanner.PrinterHandler,
+	tag string) error {
+
+	format = strings.ToLower(format)
+	if format == "" || format == formatHercules {
+		return scanner.ScanReader(ctx, r, handler,
+			scanOptions(input, output, tag))
+	}
+
+	// The text formats are read as UTF-8, unless the input has a codepage.
+	if input.Codepage != "" {
+		r = scanner.NewDecodingReader(r, input.codepage, input.unmappable)
+	}
+
+	handler = scanner.NewLineWidthHandler(handler, output.model.Columns,
+		output.overflow)
+	switch format {
+	case formatASA:
+		return scanner.ScanASAUTF8SingleContext(ctx, r, jobname, handler,
+			*trace)
+	case formatCDC:
+		return scanner.ScanCDCUTF8SingleContext(ctx, r, jobname, handler,
+			*trace)
+	case formatMCC:
+		return scanner.ScanMCCSingleContext(ctx, r, jobname, handler, *trace)
+	default:
+		return scanner.ScanUTF8SingleContext(ctx, r, jobname, handler,
+			*trace)
+	}
+}
+
+// fileJobName returns the job name to use when printing the file filename
+// as a single job.
+func fileJobName(filename string) string {
+	jobname := filepath.Base(filename)
+
+	// job name character set is pretty restricted, we'll change any
+	// non-allowed character to _
+	jobname = jobNameRegex.ReplaceAllString(jobname, "_")
+	// and limit to 25 characters if needed
+	if len(jobname) > 25 {
+		jobname = jobname[:25]
+	}
+	return jobname
+}
+
+var jobNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
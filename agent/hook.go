@@ -0,0 +1,205 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// Local outputs can run commands around each job they write. The filter
+// command runs before the PDF is written, with the job's text on its
+// standard input, and may veto the job by exiting with a non-zero status.
+// The post-job command runs after the PDF is written, e.g. to send it to
+// CUPS or a document system. Both get the details of the job in environment
+// variables, and are run directly rather than through a shell; use
+// ["sh", "-c", "..."] for shell features.
+
+// defaultHookTimeout is how long a hook command may run when the output
+// doesn't set hook_timeout_seconds.
+const defaultHookTimeout = 30 * time.Second
+
+func validateHooks(name string, config OutputConfig) []error {
+	var errs []error
+
+	for _, hook := range []struct {
+		key     string
+		command []string
+	}{
+		{"filter_command", config.FilterCommand},
+		{"post_job_command", config.PostJobCommand},
+	} {
+		if hook.command == nil {
+			continue
+		}
+		if config.Mode != "local" {
+			errs = append(errs,
+				fmt.Errorf("output [%s] '%s' is only used in local mode",
+					name, hook.key))
+		}
+		if len(hook.command) == 0 || hook.command[0] == "" {
+			errs = append(errs,
+				fmt.Errorf("output [%s] '%s' must name a program to run",
+					name, hook.key))
+		}
+	}
+	if config.HookTimeout < 0 {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'hook_timeout_seconds' must not be "+
+				"negative", name))
+	}
+
+	return errs
+}
+
+// hookTimeout returns how long an output's hook commands may run.
+func hookTimeout(config OutputConfig) time.Duration {
+	if config.HookTimeout == 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(config.HookTimeout) * time.Second
+}
+
+// hookJob describes a job to a hook command.
+type hookJob struct {
+	job       scanner.JobMetadata
+	inputName string
+	profile   string
+	file      string
+	pages     int
+}
+
+// env returns the environment variables describing the job, added to our
+// own environment.
+func (j hookJob) env() []string {
+	env := os.Environ()
+	for _, v := range []struct{ name, value string }{
+		{"V1403_INPUT", j.inputName},
+		{"V1403_PROFILE", j.profileName()},
+		{"V1403_JOB_INFO", j.job.JobInfo()},
+		{"V1403_JOB_NAME", j.job.Name},
+		{"V1403_JOB_NUMBER", j.job.Number},
+		{"V1403_JOB_TYPE", j.job.Type},
+		{"V1403_JOB_CLASS", j.job.Class},
+		{"V1403_JOB_PROGRAMMER", j.job.Programmer},
+		{"V1403_JOB_ROOM", j.job.Room},
+		{"V1403_FILE", j.file},
+	} {
+		env = append(env, v.name+"="+v.value)
+	}
+	if j.pages > 0 {
+		env = append(env, "V1403_PAGES="+strconv.Itoa(j.pages))
+	}
+	return env
+}
+
+// profileName returns the name of the profile the job is printed with.
+func (j hookJob) profileName() string {
+	if j.profile == "" {
+		return "default-green"
+	}
+	return j.profile
+}
+
+// runHook runs a hook command for a job, with stdin as its standard input,
+// waiting at most timeout for it to finish. A command that exits with a
+// non-zero status returns an *exec.ExitError; the error includes whatever
+// the command wrote to its standard output and error.
+func runHook(command []string, timeout time.Duration, job hookJob,
+	stdin io.Reader) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = job.env()
+	cmd.Stdin = stdin
+	// Don't wait forever for children of the command that are still
+	// holding its output open.
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("`%s` didn't finish within %v", command[0],
+			timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("`%s`: %w: %s", command[0], err, msg)
+		}
+		return fmt.Errorf("`%s`: %w", command[0], err)
+	}
+	return nil
+}
+
+// filterJob runs an output's filter command for a job whose text is text.
+// It returns false if the filter vetoed the job. Jobs are printed if the
+// filter can't be run at all, so a broken filter doesn't lose them.
+func filterJob(command []string, timeout time.Duration, job hookJob,
+	text []byte) (bool, error) {
+
+	err := runHook(command, timeout, job, bytes.NewReader(text))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return true, err
+}
+
+// jobText collects the text of a job for a filter command. Lines that don't
+// advance the paper (overstrikes) end with a carriage return rather than a
+// newline, and each new page starts with a form feed.
+type jobText struct {
+	bytes.Buffer
+	pages int
+}
+
+func (t *jobText) addLine(line string, linefeed bool) {
+	t.WriteString(line)
+	if linefeed {
+		t.WriteByte('\n')
+	} else {
+		t.WriteByte('\r')
+	}
+}
+
+// setPages notes the number of pages the printer has started, starting a
+// new page of text if it has moved on to another page.
+func (t *jobText) setPages(pages int) {
+	if t.pages == 0 {
+		t.pages = pages
+	}
+	for ; t.pages < pages; t.pages++ {
+		t.WriteByte('\f')
+	}
+}
+
+func (t *jobText) reset() {
+	t.Reset()
+	t.pages = 0
+}
@@ -0,0 +1,267 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// This is the IPP message encoding from RFC 8010, as much of it as our IPP
+// server needs. A message is a version number, an operation or status code,
+// a request ID, and groups of attributes; a Print-Job request is followed by
+// the document to print. Attribute values are kept as raw bytes, and only
+// decoded when we look at them.
+
+// IPP operations we support.
+const (
+	ippPrintJob             = 0x0002
+	ippValidateJob          = 0x0004
+	ippGetJobAttributes     = 0x0009
+	ippGetJobs              = 0x000A
+	ippGetPrinterAttributes = 0x000B
+)
+
+// IPP status codes.
+const (
+	ippOK                         = 0x0000
+	ippBadRequest                 = 0x0400
+	ippNotFound                   = 0x0406
+	ippRequestTooLarge            = 0x0409
+	ippDocumentFormatNotSupported = 0x040A
+	ippCompressionNotSupported    = 0x040F
+	ippOperationNotSupported      = 0x0501
+	ippVersionNotSupported        = 0x0503
+)
+
+// Attribute group delimiter tags.
+const (
+	ippOperationGroup  = 0x01
+	ippJobGroup        = 0x02
+	ippEndOfAttributes = 0x03
+	ippPrinterGroup    = 0x04
+)
+
+// Attribute value tags.
+const (
+	ippTagInteger  = 0x21
+	ippTagBoolean  = 0x22
+	ippTagEnum     = 0x23
+	ippTagText     = 0x41
+	ippTagName     = 0x42
+	ippTagKeyword  = 0x44
+	ippTagURI      = 0x45
+	ippTagCharset  = 0x47
+	ippTagLanguage = 0x48
+	ippTagMimeType = 0x49
+)
+
+type ippAttribute struct {
+	tag    byte
+	name   string
+	values [][]byte
+}
+
+type ippGroup struct {
+	tag   byte
+	attrs []ippAttribute
+}
+
+type ippMessage struct {
+	major, minor byte
+	code         uint16
+	requestID    uint32
+	groups       []*ippGroup
+}
+
+// readIPPMessage reads an IPP message's header and attributes from r. Any
+// document that follows is left in r.
+func readIPPMessage(r *bufio.Reader) (*ippMessage, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	m := &ippMessage{
+		major:     header[0],
+		minor:     header[1],
+		code:      binary.BigEndian.Uint16(header[2:4]),
+		requestID: binary.BigEndian.Uint32(header[4:8]),
+	}
+
+	var group *ippGroup
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if tag == ippEndOfAttributes {
+			return m, nil
+		}
+		if tag < 0x10 {
+			// Delimiter tags begin a new group.
+			group = &ippGroup{tag: tag}
+			m.groups = append(m.groups, group)
+			continue
+		}
+		if group == nil {
+			return nil, errors.New("IPP attribute outside of a group")
+		}
+
+		name, err := readIPPValue(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readIPPValue(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) == 0 {
+			// An attribute with no name is another value for the previous
+			// attribute.
+			if len(group.attrs) == 0 {
+				return nil, errors.New("IPP additional value without an " +
+					"attribute")
+			}
+			last := &group.attrs[len(group.attrs)-1]
+			last.values = append(last.values, value)
+			continue
+		}
+		group.attrs = append(group.attrs, ippAttribute{tag: tag,
+			name: string(name), values: [][]byte{value}})
+	}
+}
+
+// readIPPValue reads a name or value, which is preceded by its length.
+func readIPPValue(r *bufio.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// encode writes the message to w.
+func (m *ippMessage) encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write([]byte{m.major, m.minor})
+	binary.Write(bw, binary.BigEndian, m.code)
+	binary.Write(bw, binary.BigEndian, m.requestID)
+	for _, g := range m.groups {
+		bw.WriteByte(g.tag)
+		for _, a := range g.attrs {
+			for i, v := range a.values {
+				bw.WriteByte(a.tag)
+				name := a.name
+				if i > 0 {
+					name = ""
+				}
+				binary.Write(bw, binary.BigEndian, uint16(len(name)))
+				bw.WriteString(name)
+				binary.Write(bw, binary.BigEndian, uint16(len(v)))
+				bw.Write(v)
+			}
+		}
+	}
+	bw.WriteByte(ippEndOfAttributes)
+	return bw.Flush()
+}
+
+// addGroup adds a new, empty attribute group to the message.
+func (m *ippMessage) addGroup(tag byte) *ippGroup {
+	g := &ippGroup{tag: tag}
+	m.groups = append(m.groups, g)
+	return g
+}
+
+// attr returns the first attribute named name in a group with the given
+// tag, or nil if there isn't one.
+func (m *ippMessage) attr(groupTag byte, name string) *ippAttribute {
+	for _, g := range m.groups {
+		if g.tag != groupTag {
+			continue
+		}
+		for i := range g.attrs {
+			if g.attrs[i].name == name {
+				return &g.attrs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// operationString returns the first value of an operation attribute as a
+// string, or def if the request doesn't have the attribute.
+func (m *ippMessage) operationString(name, def string) string {
+	a := m.attr(ippOperationGroup, name)
+	if a == nil || len(a.values) == 0 {
+		return def
+	}
+	return string(a.values[0])
+}
+
+// operationInteger returns the first value of an integer or enum operation
+// attribute. ok is false if the request doesn't have the attribute.
+func (m *ippMessage) operationInteger(name string) (n int, ok bool) {
+	a := m.attr(ippOperationGroup, name)
+	if a == nil || len(a.values) == 0 || len(a.values[0]) != 4 {
+		return 0, false
+	}
+	return int(int32(binary.BigEndian.Uint32(a.values[0]))), true
+}
+
+// operationBoolean returns the first value of a boolean operation attribute,
+// or false if the request doesn't have the attribute.
+func (m *ippMessage) operationBoolean(name string) bool {
+	a := m.attr(ippOperationGroup, name)
+	return a != nil && len(a.values) > 0 && len(a.values[0]) == 1 &&
+		a.values[0][0] != 0
+}
+
+func (g *ippGroup) addString(tag byte, name string, values ...string) {
+	a := ippAttribute{tag: tag, name: name}
+	for _, v := range values {
+		a.values = append(a.values, []byte(v))
+	}
+	g.attrs = append(g.attrs, a)
+}
+
+func (g *ippGroup) addInteger(tag byte, name string, values ...int) {
+	a := ippAttribute{tag: tag, name: name}
+	for _, v := range values {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(v)))
+		a.values = append(a.values, b)
+	}
+	g.attrs = append(g.attrs, a)
+}
+
+func (g *ippGroup) addBoolean(name string, value bool) {
+	b := []byte{0}
+	if value {
+		b[0] = 1
+	}
+	g.attrs = append(g.attrs,
+		ippAttribute{tag: ippTagBoolean, name: name, values: [][]byte{b}})
+}
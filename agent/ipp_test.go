@@ -0,0 +1,117 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIPPRoundTrip(t *testing.T) {
+	request := &ippMessage{major: 2, minor: 0, code: ippPrintJob,
+		requestID: 42}
+	op := request.addGroup(ippOperationGroup)
+	op.addString(ippTagCharset, "attributes-charset", "utf-8")
+	op.addString(ippTagLanguage, "attributes-natural-language", "en")
+	op.addString(ippTagURI, "printer-uri", "ipp://localhost/ipp/print/lp")
+	op.addString(ippTagName, "job-name", "MYJOB")
+	op.addBoolean("ipp-attribute-fidelity", true)
+	op.addInteger(ippTagInteger, "job-id", 7)
+	job := request.addGroup(ippJobGroup)
+	job.addInteger(ippTagInteger, "copies", 1)
+	job.addString(ippTagKeyword, "sides", "one-sided",
+		"two-sided-long-edge")
+	job.addString(ippTagText, "job-message", "")
+
+	response := &ippMessage{major: 1, minor: 1, code: ippBadRequest,
+		requestID: 0xFFFFFFFF}
+	response.addGroup(ippOperationGroup)
+	printer := response.addGroup(ippPrinterGroup)
+	printer.addInteger(ippTagEnum, "operations-supported", ippPrintJob,
+		ippValidateJob, ippGetPrinterAttributes)
+	printer.addInteger(ippTagInteger, "queued-job-count", -1)
+
+	var testcases []*ippMessage = []*ippMessage{
+		{major: 2, minor: 0, code: ippGetJobs, requestID: 1},
+		request,
+		response,
+	}
+
+	for _, m := range testcases {
+		var b bytes.Buffer
+		if err := m.encode(&b); err != nil {
+			t.Fatalf("couldn't encode %+v: %v", m, err)
+		}
+		b.WriteString("DOCUMENT")
+
+		r := bufio.NewReader(&b)
+		got, err := readIPPMessage(r)
+		if err != nil {
+			t.Errorf("couldn't read encoded message %+v: %v", m, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("Got %+v back instead of %+v", got, m)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "DOCUMENT" {
+			t.Errorf("Got document %q after message %+v", rest, m)
+		}
+	}
+
+	if name := request.operationString("job-name", ""); name != "MYJOB" {
+		t.Errorf("Got job-name `%s`", name)
+	}
+	if user := request.operationString("requesting-user-name",
+		"anonymous"); user != "anonymous" {
+		t.Errorf("Got requesting-user-name `%s`", user)
+	}
+	if !request.operationBoolean("ipp-attribute-fidelity") {
+		t.Errorf("Got false for ipp-attribute-fidelity")
+	}
+	if n, ok := request.operationInteger("job-id"); n != 7 || !ok {
+		t.Errorf("Got job-id %d, %v", n, ok)
+	}
+	if _, ok := request.operationInteger("copies"); ok {
+		t.Errorf("Found copies, which isn't an operation attribute")
+	}
+}
+
+func TestReadIPPMessageErrors(t *testing.T) {
+	var testcases []string = []string{
+		"",
+		"\x02\x00\x00\x02",
+		"\x02\x00\x00\x02\x00\x00\x00\x01",
+		"\x02\x00\x00\x02\x00\x00\x00\x01\x01",
+		"\x02\x00\x00\x02\x00\x00\x00\x01\x41\x00\x01x\x00\x00\x03",
+		"\x02\x00\x00\x02\x00\x00\x00\x01\x01\x41\x00\x00\x00\x01x\x03",
+		"\x02\x00\x00\x02\x00\x00\x00\x01\x01\x41\x00\x04name\x00\x05abc",
+		"\x02\x00\x00\x02\x00\x00\x00\x01\x01\x41\x00\x04na",
+	}
+
+	for _, c := range testcases {
+		r := bufio.NewReader(strings.NewReader(c))
+		if m, err := readIPPMessage(r); err == nil {
+			t.Errorf("Got %+v instead of an error for %q", m, c)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// An ipp input is an IPP/1.1 print server. Each queue is a printer at
+// /printers/<queue name>; if the input has no queues, every path is the same
+// printer. Like the LPD server, we print a job while the client waits for
+// the response to Print-Job, so by the time the client asks about the job it
+// has either completed or been aborted because the output couldn't write or
+// deliver it.
+
+// Job states.
+const (
+	ippJobProcessing = 5
+	ippJobAborted    = 8
+	ippJobCompleted  = 9
+)
+
+// Printer states.
+const (
+	ippPrinterIdle       = 3
+	ippPrinterProcessing = 4
+)
+
+// ippMaxDocumentSize is the largest document we will accept.
+const ippMaxDocumentSize = 64 * 1024 * 1024
+
+// ippMaxJobs is how many jobs we remember for Get-Jobs and
+// Get-Job-Attributes.
+const ippMaxJobs = 100
+
+// ippDocumentFormats are the document formats we accept. We treat
+// application/octet-stream as text, too, since clients send it when they
+// don't know what a document is.
+var ippDocumentFormats = []string{"text/plain", "application/octet-stream"}
+
+// ippJob is a job we've received.
+type ippJob struct {
+	id        int
+	queue     string
+	name      string
+	user      string
+	state     int
+	message   string
+	created   time.Time
+	completed time.Time
+}
+
+// ippServer is an ipp input's server.
+type ippServer struct {
+	ctx       context.Context
+	input     InputConfig
+	inputName string
+	queues    *printQueues
+	started   time.Time
+
+	mu     sync.Mutex
+	nextID int
+	jobs   []*ippJob // oldest first
+}
+
+// runIPP runs an IPP server for an input until ctx is done.
+func runIPP(ctx context.Context, input InputConfig, output OutputConfig,
+	stats *jobStats, inputName string) {
+
+	queues, err := newPrintQueues(input, output, stats, inputName)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		return
+	}
+	s := &ippServer{
+		ctx:       ctx,
+		input:     input,
+		inputName: inputName,
+		queues:    queues,
+		started:   time.Now(),
+		nextID:    1,
+	}
+
+	l, err := listen(ctx, input, inputName, stats)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		return
+	}
+
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: lpdTimeout}
+	// Shutdown makes Serve return right away, but waits for the jobs being
+	// printed.
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		srv.Shutdown(context.Background())
+		close(done)
+	})
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		if stop() {
+			return
+		}
+	}
+	<-done
+}
+
+// ServeHTTP handles an IPP request.
+func (s *ippServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "IPP requests must use POST",
+			http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/ipp" {
+		http.Error(w, "Content-Type must be application/ipp",
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body,
+		ippMaxDocumentSize+64*1024))
+	req, err := readIPPMessage(body)
+	if err != nil {
+		log.Printf("ERROR: [%s] reading IPP request from %s: %v",
+			s.inputName, r.RemoteAddr, err)
+		http.Error(w, "invalid IPP request", http.StatusBadRequest)
+		return
+	}
+
+	resp := &ippMessage{major: 1, minor: 1, requestID: req.requestID}
+	op := resp.addGroup(ippOperationGroup)
+	op.addString(ippTagCharset, "attributes-charset", "utf-8")
+	op.addString(ippTagLanguage, "attributes-natural-language", "en")
+
+	queueName := strings.TrimPrefix(r.URL.Path, "/printers/")
+	q := s.queues.queue(queueName)
+	printerURI := "ipp://" + r.Host + r.URL.Path
+
+	switch {
+	case req.major != 1:
+		resp.code = ippVersionNotSupported
+	case q == nil:
+		resp.code = ippNotFound
+		op.addString(ippTagText, "status-message",
+			fmt.Sprintf("no printer at %s", r.URL.Path))
+	default:
+		switch req.code {
+		case ippPrintJob:
+			s.printJob(req, resp, body, q, printerURI)
+		case ippValidateJob:
+			s.validateJob(req, resp)
+		case ippGetJobAttributes:
+			s.getJobAttributes(req, resp, q, printerURI)
+		case ippGetJobs:
+			s.getJobs(req, resp, q, printerURI)
+		case ippGetPrinterAttributes:
+			s.getPrinterAttributes(resp, q, printerURI)
+		default:
+			resp.code = ippOperationNotSupported
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/ipp")
+	if err := resp.encode(w); err != nil {
+		log.Printf("ERROR: [%s] writing IPP response to %s: %v",
+			s.inputName, r.RemoteAddr, err)
+	}
+}
+
+// checkIPPJob checks the operation attributes of a Print-Job or Validate-Job
+// request, returning the status code to respond with.
+func checkIPPJob(req *ippMessage) uint16 {
+	format := req.operationString("document-format", "text/plain")
+	// Ignore any parameters, such as charset.
+	format, _, _ = strings.Cut(format, ";")
+	supported := false
+	for _, f := range ippDocumentFormats {
+		if strings.EqualFold(strings.TrimSpace(format), f) {
+			supported = true
+		}
+	}
+	if !supported {
+		return ippDocumentFormatNotSupported
+	}
+	if c := req.operationString("compression", "none"); c != "none" {
+		return ippCompressionNotSupported
+	}
+	return ippOK
+}
+
+func (s *ippServer) validateJob(req, resp *ippMessage) {
+	resp.code = checkIPPJob(req)
+}
+
+func (s *ippServer) printJob(req, resp *ippMessage, body io.Reader,
+	q *printQueue, printerURI string) {
+
+	if resp.code = checkIPPJob(req); resp.code != ippOK {
+		return
+	}
+	doc, err := io.ReadAll(body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			resp.code = ippRequestTooLarge
+		} else {
+			resp.code = ippBadRequest
+		}
+		log.Printf("ERROR: [%s] receiving IPP document: %v", s.inputName,
+			err)
+		return
+	}
+
+	name := req.operationString("job-name",
+		req.operationString("document-name", "ipp"))
+	job := s.newJob(q.name, name,
+		req.operationString("requesting-user-name", "anonymous"))
+	tag := fmt.Sprintf("%s-%d", s.inputName, job.id)
+	log.Printf("INFO:  [%s] printing IPP job %d `%s` from %s on queue `%s`",
+		tag, job.id, job.name, job.user, q.name)
+
+	meta := scanner.JobMetadata{
+		Number:     strconv.Itoa(job.id),
+		Name:       fileJobName(job.name),
+		Programmer: job.user,
+	}
+	// We're already shutting down if ctx is done, but we still print the
+	// document we accepted.
+	err = q.print(context.WithoutCancel(s.ctx), bytes.NewReader(doc),
+		q.format, meta, s.input, tag)
+	if err != nil {
+		log.Printf("ERROR: [%s] printing IPP job: %v", tag, err)
+	}
+	s.finishJob(job, err)
+
+	s.addJobAttributes(resp.addGroup(ippJobGroup), job, printerURI, false)
+}
+
+// newJob adds a job to the list of jobs we remember.
+func (s *ippServer) newJob(queue, name, user string) *ippJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := &ippJob{
+		id:      s.nextID,
+		queue:   queue,
+		name:    name,
+		user:    user,
+		state:   ippJobProcessing,
+		created: time.Now(),
+	}
+	s.nextID++
+	s.jobs = append(s.jobs, job)
+	if len(s.jobs) > ippMaxJobs {
+		s.jobs = s.jobs[1:]
+	}
+	return job
+}
+
+// finishJob records whether a job was printed.
+func (s *ippServer) finishJob(job *ippJob, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.completed = time.Now()
+	if err != nil {
+		job.state = ippJobAborted
+		job.message = err.Error()
+	} else {
+		job.state = ippJobCompleted
+	}
+}
+
+// requestJobID returns the job a request is about, given by either job-id
+// or job-uri.
+func requestJobID(req *ippMessage) (int, bool) {
+	if id, ok := req.operationInteger("job-id"); ok {
+		return id, true
+	}
+	uri := req.operationString("job-uri", "")
+	i := strings.LastIndex(uri, "/")
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(uri[i+1:])
+	return id, err == nil
+}
+
+func (s *ippServer) getJobAttributes(req, resp *ippMessage, q *printQueue,
+	printerURI string) {
+
+	id, ok := requestJobID(req)
+	if !ok {
+		resp.code = ippBadRequest
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.id == id && job.queue == q.name {
+			s.addJobAttributes(resp.addGroup(ippJobGroup), job, printerURI,
+				true)
+			return
+		}
+	}
+	resp.code = ippNotFound
+}
+
+func (s *ippServer) getJobs(req, resp *ippMessage, q *printQueue,
+	printerURI string) {
+
+	completed := false
+	switch req.operationString("which-jobs", "not-completed") {
+	case "completed":
+		completed = true
+	case "not-completed":
+	default:
+		resp.code = ippBadRequest
+		return
+	}
+	limit, ok := req.operationInteger("limit")
+	if !ok || limit <= 0 {
+		limit = ippMaxJobs
+	}
+	user := ""
+	if req.operationBoolean("my-jobs") {
+		user = req.operationString("requesting-user-name", "anonymous")
+	}
+	details := false
+	if a := req.attr(ippOperationGroup, "requested-attributes"); a != nil {
+		for _, v := range a.values {
+			if string(v) != "job-id" && string(v) != "job-uri" {
+				details = true
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Completed jobs are listed newest first, and jobs that haven't
+	// completed oldest first.
+	jobs := make([]*ippJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.queue != q.name || (user != "" && job.user != user) ||
+			(job.state >= ippJobAborted) != completed {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if completed {
+		for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+			jobs[i], jobs[j] = jobs[j], jobs[i]
+		}
+	}
+	for i, job := range jobs {
+		if i == limit {
+			break
+		}
+		s.addJobAttributes(resp.addGroup(ippJobGroup), job, printerURI,
+			details)
+	}
+}
+
+// addJobAttributes adds a job's attributes to a response. Without details,
+// only the attributes that identify the job and its state are added.
+func (s *ippServer) addJobAttributes(g *ippGroup, job *ippJob,
+	printerURI string, details bool) {
+
+	g.addInteger(ippTagInteger, "job-id", job.id)
+	g.addString(ippTagURI, "job-uri",
+		fmt.Sprintf("%s/%d", printerURI, job.id))
+	g.addInteger(ippTagEnum, "job-state", job.state)
+	switch job.state {
+	case ippJobCompleted:
+		g.addString(ippTagKeyword, "job-state-reasons",
+			"job-completed-successfully")
+	case ippJobAborted:
+		g.addString(ippTagKeyword, "job-state-reasons",
+			"aborted-by-system")
+		g.addString(ippTagText, "job-state-message", job.message)
+	default:
+		g.addString(ippTagKeyword, "job-state-reasons", "job-printing")
+	}
+	if !details {
+		return
+	}
+
+	g.addString(ippTagName, "job-name", job.name)
+	g.addString(ippTagName, "job-originating-user-name", job.user)
+	g.addString(ippTagURI, "job-printer-uri", printerURI)
+	g.addInteger(ippTagInteger, "time-at-creation",
+		s.upTime(job.created))
+	if !job.completed.IsZero() {
+		g.addInteger(ippTagInteger, "time-at-completed",
+			s.upTime(job.completed))
+	}
+}
+
+func (s *ippServer) getPrinterAttributes(resp *ippMessage, q *printQueue,
+	printerURI string) {
+
+	state := ippPrinterIdle
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		if job.queue == q.name && job.state == ippJobProcessing {
+			state = ippPrinterProcessing
+		}
+	}
+	s.mu.Unlock()
+
+	name := q.name
+	if name == "*" {
+		name = s.inputName
+	}
+
+	g := resp.addGroup(ippPrinterGroup)
+	g.addString(ippTagURI, "printer-uri-supported", printerURI)
+	g.addString(ippTagKeyword, "uri-security-supported", "none")
+	g.addString(ippTagKeyword, "uri-authentication-supported", "none")
+	g.addString(ippTagName, "printer-name", name)
+	g.addString(ippTagText, "printer-info", "Virtual 1403 "+name)
+	g.addString(ippTagText, "printer-make-and-model", "Virtual 1403")
+	g.addInteger(ippTagEnum, "printer-state", state)
+	g.addString(ippTagKeyword, "printer-state-reasons", "none")
+	g.addBoolean("printer-is-accepting-jobs", true)
+	g.addInteger(ippTagInteger, "queued-job-count", 0)
+	g.addString(ippTagKeyword, "ipp-versions-supported", "1.0", "1.1")
+	g.addInteger(ippTagEnum, "operations-supported", ippPrintJob,
+		ippValidateJob, ippGetJobAttributes, ippGetJobs,
+		ippGetPrinterAttributes)
+	g.addString(ippTagCharset, "charset-configured", "utf-8")
+	g.addString(ippTagCharset, "charset-supported", "utf-8")
+	g.addString(ippTagLanguage, "natural-language-configured", "en")
+	g.addString(ippTagLanguage, "generated-natural-language-supported",
+		"en")
+	g.addString(ippTagMimeType, "document-format-default", "text/plain")
+	g.addString(ippTagMimeType, "document-format-supported",
+		ippDocumentFormats...)
+	g.addString(ippTagKeyword, "compression-supported", "none")
+	g.addString(ippTagKeyword, "pdl-override-supported", "not-attempted")
+	g.addInteger(ippTagInteger, "printer-up-time", s.upTime(time.Now()))
+}
+
+// upTime returns t as the number of seconds since the server started, which
+// is how IPP gives times.
+func (s *ippServer) upTime(t time.Time) int {
+	return int(t.Sub(s.started)/time.Second) + 1
+}
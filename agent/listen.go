@@ -0,0 +1,278 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A listen input is the other way around from a Hercules input: rather than
+// connecting to the emulator's printer socket, we listen for emulators that
+// connect to a remote printer (SIMH, or Hercules behind NAT with a tunnel).
+// Each connection is its own printer, with its own job state and its own
+// output handler, and the other end closing the connection ends the job in
+// progress.
+
+func validateListenConfig(name string, config InputConfig) []error {
+	var errs []error
+
+	if config.ListenAddress == "" {
+		errs = append(errs,
+			fmt.Errorf("input [%s] must set 'listen_address'", name))
+	}
+	network, _ := listenNetwork(config.ListenAddress)
+	if network == "unix" && len(config.AllowedClients) > 0 {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'allowed_clients' can't be used with a "+
+				"Unix domain socket", name))
+	}
+	if _, err := parseAllowedClients(config.AllowedClients); err != nil {
+		errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+	}
+
+	return errs
+}
+
+// listenNetwork returns the network and address to listen on for a
+// listen_address, which is either a TCP address such as ":1403", or
+// "unix:" followed by the path of a Unix domain socket.
+func listenNetwork(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", address
+}
+
+// parseAllowedClients parses a list of IP addresses and CIDR networks.
+func parseAllowedClients(clients []string) ([]*net.IPNet, error) {
+	var allowed []*net.IPNet
+	for _, client := range clients {
+		if strings.Contains(client, "/") {
+			_, network, err := net.ParseCIDR(client)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network `%s` in "+
+					"'allowed_clients'", client)
+			}
+			allowed = append(allowed, network)
+			continue
+		}
+
+		ip := net.ParseIP(client)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address `%s` in "+
+				"'allowed_clients'", client)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		allowed = append(allowed,
+			&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return allowed, nil
+}
+
+// clientAllowed returns true if a client connecting from addr is allowed by
+// the allow-list. An empty allow-list allows everyone.
+func clientAllowed(allowed []*net.IPNet, addr net.Addr) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range allowed {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// listen starts listening on the input's listen_address. The listener
+// rejects connections from clients that aren't in the input's
+// allowed_clients.
+func listen(ctx context.Context, input InputConfig, inputName string,
+	stats *jobStats) (net.Listener, error) {
+
+	network, address := listenNetwork(input.ListenAddress)
+	if network == "unix" {
+		// A socket left behind by an agent that didn't shut down cleanly
+		// would keep us from listening.
+		if info, err := os.Stat(address); err == nil &&
+			info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, network, address)
+	if err != nil {
+		if stats != nil {
+			stats.setError(err)
+		}
+		return nil, err
+	}
+	log.Printf("INFO:  [%s] Listening for connections on %s", inputName,
+		input.ListenAddress)
+	if stats != nil {
+		stats.setState(stateListening)
+	}
+	return &allowListener{Listener: l, allowed: input.allowed,
+		inputName: inputName}, nil
+}
+
+// allowListener is a net.Listener that only accepts connections from
+// allowed clients.
+type allowListener struct {
+	net.Listener
+	allowed   []*net.IPNet
+	inputName string
+}
+
+func (l *allowListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if clientAllowed(l.allowed, conn.RemoteAddr()) {
+			return conn, nil
+		}
+		log.Printf("WARN:  [%s] rejected connection from %s, which is "+
+			"not in 'allowed_clients'", l.inputName, conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// runListener accepts connections on the input's listen_address until ctx
+// is done, calling serve in a new goroutine for each connection from an
+// allowed client, and then waits for the connections to finish. tag
+// identifies the connection in log messages.
+func runListener(ctx context.Context, input InputConfig, inputName string,
+	stats *jobStats, serve func(conn net.Conn, tag string)) {
+
+	l, err := listen(ctx, input, inputName, stats)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't listen: %v", inputName, err)
+		return
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+	defer stop()
+
+	network, address := listenNetwork(input.ListenAddress)
+	var conns sync.WaitGroup
+	connNum := 0
+	for {
+		conn, err := l.Accept()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("ERROR: [%s] %v", inputName, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		connNum++
+		tag := fmt.Sprintf("%s-%d", inputName, connNum)
+		from := conn.RemoteAddr().String()
+		if network == "unix" {
+			from = address
+		}
+		log.Printf("INFO:  [%s] accepted connection from %s", tag, from)
+		if stats != nil {
+			conn = stats.watchConn(conn)
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			serve(conn, tag)
+		}()
+	}
+
+	conns.Wait()
+}
+
+// runListenInput prints the jobs from the emulators that connect to a
+// listen input until ctx is done.
+func runListenInput(ctx context.Context, input InputConfig,
+	output OutputConfig, stats *jobStats, inputName string) {
+
+	runListener(ctx, input, inputName, stats,
+		func(conn net.Conn, tag string) {
+			handleClient(ctx, input, output, stats, conn, tag)
+		})
+}
+
+// handleClient prints the jobs received on one listen input connection.
+func handleClient(ctx context.Context, input InputConfig,
+	output OutputConfig, stats *jobStats, conn net.Conn, tag string) {
+
+	conn = stats.countConn(conn)
+	if input.CaptureDir != "" {
+		conn = startCapture(conn, input.CaptureDir, tag)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("ERROR: [%s] %v", tag, err)
+		}
+	}()
+
+	handler, err := newInputHandler(input, output, tag)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", tag, err)
+		return
+	}
+	handler = newCountingHandler(handler, stats)
+
+	opts := scanOptions(input, output, tag)
+	opts.EndJobAtEOF = true
+	err = scanner.ScanContext(ctx, conn, handler, opts)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("INFO:  [%s] Closing connection.", tag)
+		return
+	}
+	if err == io.EOF {
+		log.Printf("INFO:  [%s] Client disconnected.", tag)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: [%s] error reading from client: %s", tag, err)
+		stats.setError(err)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// An lpd input is a line printer daemon (RFC 1179) print server. A client
+// sends each job as a control file, which names the job and the user and
+// lists the data files to print, and one or more data files. We print the
+// job as soon as we have the control file and all of the data files it
+// lists, rather than queueing it, so the queue is always empty when a client
+// asks about it.
+
+// LPD daemon commands.
+const (
+	lpdPrintWaiting = 0x01
+	lpdReceiveJob   = 0x02
+	lpdQueueShort   = 0x03
+	lpdQueueLong    = 0x04
+	lpdRemoveJobs   = 0x05
+)
+
+// LPD receive job subcommands.
+const (
+	lpdAbortJob    = 0x01
+	lpdControlFile = 0x02
+	lpdDataFile    = 0x03
+)
+
+// lpdMaxJobSize is the most we will accept in the control and data files of
+// one job, all together.
+const lpdMaxJobSize = 64 * 1024 * 1024
+
+// lpdMaxCommandSize is the longest command line we will accept, which is the
+// size of the buffer we read commands with.
+const lpdMaxCommandSize = 4096
+
+// lpdTimeout is how long we wait for a client to send the next part of a
+// command before giving up on it.
+const lpdTimeout = 2 * time.Minute
+
+// lpdServer is an lpd input's server.
+type lpdServer struct {
+	input  InputConfig
+	queues *printQueues
+}
+
+// runLPD runs an LPD server for an input until ctx is done.
+func runLPD(ctx context.Context, input InputConfig, output OutputConfig,
+	stats *jobStats, inputName string) {
+
+	queues, err := newPrintQueues(input, output, stats, inputName)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		return
+	}
+	s := &lpdServer{input: input, queues: queues}
+
+	runListener(ctx, input, inputName, stats,
+		func(conn net.Conn, tag string) {
+			s.serve(ctx, conn, tag)
+		})
+}
+
+// serve handles one LPD client connection.
+func (s *lpdServer) serve(ctx context.Context, conn net.Conn, tag string) {
+	defer conn.Close()
+
+	// If we're shut down while waiting on the client, give up on the job.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	r := bufio.NewReaderSize(conn, lpdMaxCommandSize)
+	conn.SetReadDeadline(time.Now().Add(lpdTimeout))
+	cmd, operands, err := readLPDCommand(r)
+	if err != nil {
+		log.Printf("ERROR: [%s] reading LPD command: %v", tag, err)
+		return
+	}
+	if len(operands) == 0 {
+		log.Printf("ERROR: [%s] LPD command %02x is missing the queue name",
+			tag, cmd)
+		return
+	}
+	queue := operands[0]
+
+	switch cmd {
+	case lpdReceiveJob:
+		s.receiveJob(ctx, conn, r, queue, tag)
+	case lpdQueueShort, lpdQueueLong:
+		// We print jobs as soon as we receive them, so there is never
+		// anything in the queue.
+		fmt.Fprintf(conn, "%s: no entries\n", queue)
+	case lpdPrintWaiting, lpdRemoveJobs:
+		// Likewise, there is never anything waiting to print or remove.
+	default:
+		log.Printf("ERROR: [%s] unknown LPD command %02x", tag, cmd)
+	}
+}
+
+// readLPDCommand reads an LPD command or subcommand: the command code,
+// followed by operands separated by white space, ending with LF. Commands
+// longer than r's buffer are an error.
+func readLPDCommand(r *bufio.Reader) (byte, []string, error) {
+	cmd, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return 0, nil, errors.New("LPD command is too long")
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return cmd, strings.Fields(string(line)), nil
+}
+
+// lpdJob is a job being received from a client.
+type lpdJob struct {
+	controlName string
+	control     []byte
+	data        map[string][]byte
+
+	// size is the total size of the files we've received.
+	size int
+}
+
+// receiveJob handles the receive job command for queue.
+func (s *lpdServer) receiveJob(ctx context.Context, conn net.Conn,
+	r *bufio.Reader, queue, tag string) {
+
+	q := s.queues.queue(queue)
+	if q == nil {
+		log.Printf("WARN:  [%s] rejected LPD job for unknown queue `%s`",
+			tag, queue)
+		conn.Write([]byte{1})
+		return
+	}
+	conn.Write([]byte{0})
+
+	job := lpdJob{data: make(map[string][]byte)}
+	for {
+		conn.SetReadDeadline(time.Now().Add(lpdTimeout))
+		sub, operands, err := readLPDCommand(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("ERROR: [%s] receiving LPD job: %v", tag, err)
+			return
+		}
+
+		if sub == lpdAbortJob {
+			job = lpdJob{data: make(map[string][]byte)}
+			continue
+		}
+		if sub != lpdControlFile && sub != lpdDataFile {
+			log.Printf("ERROR: [%s] unknown LPD subcommand %02x", tag, sub)
+			conn.Write([]byte{1})
+			return
+		}
+
+		var count int
+		if len(operands) == 2 {
+			count, err = strconv.Atoi(operands[0])
+		}
+		if len(operands) != 2 || err != nil || count < 0 {
+			log.Printf("ERROR: [%s] invalid LPD file operands %q", tag,
+				operands)
+			conn.Write([]byte{1})
+			return
+		}
+		if count > lpdMaxJobSize-job.size {
+			log.Printf("ERROR: [%s] rejected LPD job larger than %d MB", tag,
+				lpdMaxJobSize/(1024*1024))
+			conn.Write([]byte{1})
+			return
+		}
+		conn.Write([]byte{0})
+
+		// The file is followed by a zero byte. We let the buffer grow as
+		// the file arrives rather than trusting the client's count.
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, r, int64(count)+1)
+		file := buf.Bytes()
+		if err != nil || file[count] != 0 {
+			if err == nil {
+				err = errors.New("file doesn't end with a zero byte")
+			}
+			log.Printf("ERROR: [%s] receiving LPD file `%s`: %v", tag,
+				operands[1], err)
+			return
+		}
+		conn.Write([]byte{0})
+
+		if sub == lpdControlFile {
+			job.controlName = operands[1]
+			job.control = file[:count]
+		} else {
+			job.data[operands[1]] = file[:count]
+		}
+		job.size += count
+
+		if job.complete() {
+			s.printJob(ctx, q, job, tag)
+			return
+		}
+	}
+
+	if job.control == nil && len(job.data) == 0 {
+		return
+	}
+	log.Printf("ERROR: [%s] client disconnected before sending the whole "+
+		"LPD job", tag)
+}
+
+// lpdControl is what we use from an LPD control file.
+type lpdControl struct {
+	jobName string
+	user    string
+	host    string
+	source  string
+
+	// files are the data files to print, and how the client asked for each
+	// to be printed.
+	files []lpdPrintFile
+}
+
+type lpdPrintFile struct {
+	command byte
+	name    string
+}
+
+// parseLPDControl parses a control file.
+func parseLPDControl(control []byte) lpdControl {
+	var c lpdControl
+	for _, line := range strings.Split(string(control), "\n") {
+		if line == "" {
+			continue
+		}
+		operand := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'J':
+			c.jobName = operand
+		case 'P':
+			c.user = operand
+		case 'H':
+			c.host = operand
+		case 'N':
+			if c.source == "" {
+				c.source = operand
+			}
+		case 'c', 'd', 'f', 'g', 'l', 'n', 'o', 'p', 'r', 't', 'v':
+			c.files = append(c.files,
+				lpdPrintFile{command: line[0], name: operand})
+		}
+	}
+	return c
+}
+
+// complete returns true if we have the control file and all of the data
+// files it lists.
+func (j *lpdJob) complete() bool {
+	if j.control == nil {
+		return false
+	}
+	for _, f := range parseLPDControl(j.control).files {
+		if _, ok := j.data[f.name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// lpdJobNumber returns the job number from a control file name, which is
+// "cfA" followed by a three digit job number and the client's host name.
+func lpdJobNumber(controlName string) string {
+	if len(controlName) < 6 {
+		return ""
+	}
+	number := controlName[3:6]
+	if _, err := strconv.Atoi(number); err != nil {
+		return ""
+	}
+	return number
+}
+
+// printJob prints a complete job to queue q. All of the job's data files are
+// printed together as one job.
+func (s *lpdServer) printJob(ctx context.Context, q *printQueue, job lpdJob,
+	tag string) {
+
+	c := parseLPDControl(job.control)
+
+	format := q.format
+	var files []io.Reader
+	for _, f := range c.files {
+		switch f.command {
+		case 'f', 'l', 'p':
+		case 'r':
+			// FORTRAN carriage control is ASA carriage control.
+			if format == formatText {
+				format = formatASA
+			}
+		default:
+			log.Printf("WARN:  [%s] skipping LPD data file `%s`, which uses "+
+				"unsupported print command '%c'", tag, f.name, f.command)
+			continue
+		}
+		files = append(files, bytes.NewReader(job.data[f.name]))
+	}
+	if len(files) == 0 {
+		log.Printf("WARN:  [%s] LPD job has nothing to print", tag)
+		return
+	}
+
+	jobname := c.jobName
+	if jobname == "" {
+		jobname = c.source
+	}
+	if jobname == "" && len(c.files) > 0 {
+		jobname = c.files[0].name
+	}
+	meta := scanner.JobMetadata{
+		Number:     lpdJobNumber(job.controlName),
+		Name:       fileJobName(jobname),
+		Programmer: c.user,
+	}
+	log.Printf("INFO:  [%s] printing LPD job %s from %s@%s on queue `%s`",
+		tag, meta.Number, c.user, c.host, q.name)
+
+	err := q.print(ctx, io.MultiReader(files...), format, meta, s.input, tag)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("ERROR: [%s] printing LPD job: %v", tag, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseLPDControl(t *testing.T) {
+	type testcase struct {
+		control string
+		output  lpdControl
+	}
+	var testcases []testcase = []testcase{
+		{"", lpdControl{}},
+		{"Hhost\nPuser\nJMYJOB\nldfA001host\nUdfA001host\nNreport.txt\n",
+			lpdControl{jobName: "MYJOB", user: "user", host: "host",
+				source: "report.txt", files: []lpdPrintFile{
+					{'l', "dfA001host"}}}},
+		{"Hhost\r\nPuser\r\nfdfA002host\r\n", lpdControl{user: "user",
+			host: "host", files: []lpdPrintFile{{'f', "dfA002host"}}}},
+		{"Nfirst\nNsecond\nrdfA003host\npdfB003host\nTtitle\n",
+			lpdControl{source: "first", files: []lpdPrintFile{
+				{'r', "dfA003host"}, {'p', "dfB003host"}}}},
+		{"J\nMuser\n1font\n", lpdControl{}},
+	}
+
+	for _, c := range testcases {
+		output := parseLPDControl([]byte(c.control))
+		if fmt.Sprint(output) != fmt.Sprint(c.output) {
+			t.Errorf("Got %+v instead of %+v for control file %q", output,
+				c.output, c.control)
+		}
+	}
+}
+
+func TestLPDJobComplete(t *testing.T) {
+	type testcase struct {
+		control  string
+		data     []string
+		complete bool
+	}
+	control := "Hhost\nldfA001host\nldfB001host\n"
+	var testcases []testcase = []testcase{
+		{"", nil, false},
+		{"", []string{"dfA001host", "dfB001host"}, false},
+		{control, nil, false},
+		{control, []string{"dfA001host"}, false},
+		{control, []string{"dfA001host", "dfB001host"}, true},
+		{control, []string{"dfB001host", "dfA001host", "dfC001host"}, true},
+		{"Hhost\nPuser\n", nil, true},
+	}
+
+	for _, c := range testcases {
+		job := lpdJob{data: make(map[string][]byte)}
+		if c.control != "" {
+			job.control = []byte(c.control)
+		}
+		for _, name := range c.data {
+			job.data[name] = []byte("data")
+		}
+		if got := job.complete(); got != c.complete {
+			t.Errorf("Got %v instead of %v for control file %q with data "+
+				"files %v", got, c.complete, c.control, c.data)
+		}
+	}
+}
+
+func TestReadLPDCommand(t *testing.T) {
+	type testcase struct {
+		input    string
+		cmd      byte
+		operands string
+		valid    bool
+	}
+	var testcases []testcase = []testcase{
+		{"\x02lp\n", lpdReceiveJob, "lp", true},
+		{"\x03lp user1 user2\n", lpdQueueShort, "lp user1 user2", true},
+		{"\x0212 cfA001host\n", lpdReceiveJob, "12 cfA001host", true},
+		{"\x02lp", 0, "", false},
+		{"", 0, "", false},
+		{"\x02" + strings.Repeat("x", lpdMaxCommandSize) + "\n", 0, "",
+			false},
+	}
+
+	for _, c := range testcases {
+		r := bufio.NewReaderSize(strings.NewReader(c.input),
+			lpdMaxCommandSize)
+		cmd, operands, err := readLPDCommand(r)
+		if (err == nil) != c.valid {
+			t.Errorf("Got error %v for command %q", err, c.input)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if cmd != c.cmd || strings.Join(operands, " ") != c.operands {
+			t.Errorf("Got command %02x %q for %q", cmd, operands, c.input)
+		}
+	}
+}
+
+func TestLPDJobNumber(t *testing.T) {
+	type testcase struct {
+		controlName string
+		number      string
+	}
+	var testcases []testcase = []testcase{
+		{"cfA123host", "123"},
+		{"cfA007", "007"},
+		{"cfAx23host", ""},
+		{"cfA12", ""},
+		{"", ""},
+	}
+
+	for _, c := range testcases {
+		if number := lpdJobNumber(c.controlName); number != c.number {
+			t.Errorf("Got `%s` instead of `%s` for `%s`", number, c.number,
+				c.controlName)
+		}
+	}
+}
@@ -19,13 +19,15 @@ package main
 // along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"os"
-	"path/filepath"
 	"regexp"
 	"sync"
 	"time"
@@ -45,6 +47,34 @@ var useCDC = flag.Bool("cdc", false, "When using -printfile, file has CDC "+
 	"carriage control characters in first position of each line")
 var useASA = flag.Bool("asa", false, "When using -printfile, file has ASA "+
 	"carriage control characters in first position of each line")
+var useMCC = flag.Bool("mcc", false, "When using -printfile, file has IBM "+
+	"machine carriage control codes in first position of each line")
+var recfm = flag.String("recfm", "", "When using -printfile, file is a "+
+	"binary EBCDIC dataset with this record format (F, FB, V, VB, with an "+
+	"optional A or M suffix for ASA or machine carriage control)")
+var lrecl = flag.Int("lrecl", 0, "When using -recfm, the logical record "+
+	"length (required for fixed length records)")
+var ebcdicCodepage = flag.String("ebcdic", "037", "When using -recfm, the "+
+	"EBCDIC code page of the dataset (037, 273, 500, 1047, or 1140)")
+var replayFile = flag.String("replay", "",
+	"replay a capture file recorded with an input's capture_directory")
+var replayInput = flag.String("input", "default",
+	"input configuration (end-of-job detection, codepage) to use for -replay")
+var submit = flag.String("submit", "", "submit a JCL deck from a text file "+
+	"to a Hercules card reader. Use filename \"-\" for stdin")
+var readerName = flag.String("reader", "", "reader configuration to use for "+
+	"-submit (may be omitted if only one reader is configured)")
+var spoolCommand = flag.String("spool", "", "manage the jobs in online "+
+	"spools: \"list\", \"retry\", or \"purge\" them (all jobs, or the "+
+	"job IDs given as arguments; use -output to choose one output)")
+var watchConfig = flag.Bool("watchconfig", false, "reload the config file "+
+	"when it changes (it is always reloaded on SIGHUP)")
+var rerender = flag.String("rerender", "", "print a saved print job "+
+	"stream (.job.zst) again, or each one in a directory, to the -output")
+var profile = flag.String("profile", "", "When using -rerender, the "+
+	"profile to use instead of the output's")
+var fontFile = flag.String("font", "", "When using -rerender, the font "+
+	"file to use instead of the output's (local outputs only)")
 var trace = flag.Bool("trace", false, "enable trace logging")
 var displayVersion = flag.Bool("version", false, "display version and quit")
 
@@ -62,9 +92,10 @@ func main() {
 		log.Printf("TRACE: trace logging enabled")
 	}
 
-    if *useASA && *useCDC {
-        log.Fatalf("FATAL: the -asa and -cdc flags are mutually exclusive")
-    }
+	if countTrue(*useASA, *useCDC, *useMCC) > 1 {
+		log.Fatalf("FATAL: the -asa, -cdc, and -mcc flags are mutually " +
+			"exclusive")
+	}
 
 	if *useCDC && *printFile == "" {
 		log.Fatalf("FATAL: the -cdc flag is only used with the -printFile " +
@@ -76,13 +107,80 @@ func main() {
 			"parameter.")
 	}
 
+	if *useMCC && *printFile == "" {
+		log.Fatalf("FATAL: the -mcc flag is only used with the -printFile " +
+			"parameter.")
+	}
+
+	// When -recfm is used, dataset describes the binary EBCDIC file we're
+	// printing.
+	var dataset *binaryDataset
+	if *recfm != "" {
+		if *printFile == "" {
+			log.Fatalf("FATAL: the -recfm flag is only used with the " +
+				"-printFile parameter.")
+		}
+		recordFormat, control, err := scanner.ParseRecordFormat(*recfm)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		// The carriage control letter in the RECFM is the same as using the
+		// -asa or -mcc flag.
+		if control == scanner.ControlASA {
+			*useASA = true
+		} else if control == scanner.ControlMachine {
+			*useMCC = true
+		}
+		if countTrue(*useASA, *useCDC, *useMCC) > 1 {
+			log.Fatalf("FATAL: RECFM %s conflicts with the carriage control "+
+				"flags", *recfm)
+		}
+		codepage, err := scanner.LookupEBCDICCodepage(*ebcdicCodepage)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		dataset = &binaryDataset{recfm: recordFormat, codepage: codepage}
+	} else if *lrecl != 0 {
+		log.Fatalf("FATAL: the -lrecl flag is only used with the -recfm " +
+			"parameter.")
+	}
+
+	if *submit != "" && (*printFile != "" || *replayFile != "") {
+		log.Fatalf("FATAL: the -submit flag can't be used with -printfile " +
+			"or -replay")
+	}
+
+	if *spoolCommand != "" &&
+		(*printFile != "" || *replayFile != "" || *submit != "") {
+		log.Fatalf("FATAL: the -spool flag can't be used with -printfile, " +
+			"-replay, or -submit")
+	}
+
+	if *rerender != "" && (*printFile != "" || *replayFile != "" ||
+		*submit != "" || *spoolCommand != "") {
+		log.Fatalf("FATAL: the -rerender flag can't be used with " +
+			"-printfile, -replay, -submit, or -spool")
+	}
+
+	if (*profile != "" || *fontFile != "") && *rerender == "" {
+		log.Fatalf("FATAL: the -profile and -font flags are only used with " +
+			"the -rerender parameter.")
+	}
+
+	// Ctrl-C or SIGTERM stops the agent gracefully: we stop reading input,
+	// finish the jobs in progress with what we have, and wait for them to
+	// be delivered before exiting.
+	ctx := shutdownContext()
+
 	// Load configuration file
-	inputs, outputs, err := loadConfig(*configFile)
+	inputs, outputs, readers, status, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("FATAL: Unable to read config `%s`: %v", *configFile, err)
 	}
 
 	errs := validateConfig(inputs, outputs)
+	errs = append(errs, validateReaders(readers)...)
+	errs = append(errs, validateStatusConfig(status)...)
 	if errs != nil {
 		for _, err := range errs {
 			log.Printf("ERROR: %s", err.Error())
@@ -90,14 +188,171 @@ func main() {
 		log.Fatalf("FATAL: invalid configuration")
 	}
 
-	// Set up outputs
+	// Keep a copy of the configuration as it was in the file, to compare
+	// with the file when we reload it.
+	fileInputs, fileOutputs := maps.Clone(inputs), maps.Clone(outputs)
+	fileReaders := maps.Clone(readers)
+
+	if err := setupOutputs(outputs); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	if err := setupInputs(inputs, outputs); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	// Set up readers.
+	for name, conf := range readers {
+		if *printFile == "" && *replayFile == "" && *submit == "" {
+			for _, dir := range []string{conf.SubmitDir,
+				conf.SubmitArchiveDir} {
+				if dir == "" {
+					continue
+				}
+				if err = verifyOrCreateDir(dir); err != nil {
+					log.Fatalf("FATAL: [%s] %v", name, err.Error())
+				}
+			}
+		}
+		conf.codepage, _ = scanner.LookupEBCDICCodepage(conf.Codepage)
+		readers[name] = conf
+	}
+
+	// If user requested that we print a single file, we will do so then quit.
+	if *printFile != "" {
+		// Does the requested output config exist?
+		o, ok := outputs[*output]
+		if !ok {
+			log.Fatalf("FATAL: Output configuration [%s] doesn't exist",
+				*output)
+		}
+
+		runFilePrinter(ctx, o, *printFile, dataset)
+
+		return
+	}
+
+	// Or if the user requested that we replay a capture file, we will do so
+	// then quit.
+	if *replayFile != "" {
+		i, ok := inputs[*replayInput]
+		if !ok {
+			log.Fatalf("FATAL: Input configuration [%s] doesn't exist",
+				*replayInput)
+		}
+		o, ok := outputs[*output]
+		if !ok {
+			log.Fatalf("FATAL: Output configuration [%s] doesn't exist",
+				*output)
+		}
+
+		runReplay(ctx, i, o, *replayFile)
+
+		return
+	}
+
+	// Or if the user requested that we submit a deck to a card reader, we
+	// will do so then quit.
+	if *submit != "" {
+		name := *readerName
+		if name == "" {
+			if len(readers) != 1 {
+				log.Fatalf("FATAL: use -reader to choose which reader " +
+					"configuration to submit to")
+			}
+			for name = range readers {
+			}
+		}
+		r, ok := readers[name]
+		if !ok {
+			log.Fatalf("FATAL: Reader configuration [%s] doesn't exist",
+				name)
+		}
+
+		if err := submitFile(ctx, r, name, *submit); err != nil {
+			log.Fatalf("FATAL: [%s] %v", name, err)
+		}
+
+		return
+	}
+
+	// Or if the user requested that we manage the online spools, we will do
+	// so then quit.
+	if *spoolCommand != "" {
+		outputName := ""
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "output" {
+				outputName = *output
+			}
+		})
+		if err := runSpoolCommand(*spoolCommand, outputs, outputName,
+			flag.Args()); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+
+		return
+	}
+
+	// Or if the user requested that we print saved print job streams again,
+	// we will do so then quit.
+	if *rerender != "" {
+		o, ok := outputs[*output]
+		if !ok {
+			log.Fatalf("FATAL: Output configuration [%s] doesn't exist",
+				*output)
+		}
+		if *profile != "" {
+			o.Profile = *profile
+		}
+		if *fontFile != "" {
+			if o.Mode != "local" {
+				log.Fatalf("FATAL: the -font flag is only used with local " +
+					"outputs")
+			}
+			o.font, err = vprinter.LoadFont(*fontFile)
+			if err != nil {
+				log.Fatalf("FATAL: couldn't load requested font: %v", err)
+			}
+		}
+
+		runRerender(ctx, o, *rerender)
+
+		return
+	}
+
+	// Otherwise...
+	// Start a thread for each input and run until they all stop...which will
+	// usually be when the user hits Ctrl-C to shut down the agent. Online
+	// outputs with a spool retry the jobs that couldn't be sent, and the
+	// status server reports on all of it. The supervisor reloads the
+	// configuration file while we run.
+	var wg sync.WaitGroup
+	sup := newSupervisor(ctx, &wg, inputs, fileInputs, outputs, fileOutputs,
+		fileReaders, status)
+	sup.start()
+	go sup.watchReload(ctx, *configFile, *watchConfig)
+	// Readers with a submit_directory watch it for decks to submit.
+	for name, reader := range readers {
+		if reader.SubmitDir == "" {
+			continue
+		}
+		wg.Add(1)
+		go runReader(ctx, reader, name, &wg)
+	}
+	wg.Wait()
+	logSummary(sup.allStats())
+	log.Printf("INFO:  shutdown complete")
+}
+
+// setupOutputs prepares validated output configurations for use: creating
+// the output directories, loading fonts, and so on.
+func setupOutputs(outputs map[string]OutputConfig) error {
 	for name, conf := range outputs {
 		if conf.Mode == "local" {
 			// setup for local mode
 
 			// Make sure the output directory exists
-			if err = verifyOrCreateDir(conf.OutputDir); err != nil {
-				log.Fatalf("FATAL: [%s] %v", name, err.Error())
+			if err := verifyOrCreateDir(conf.OutputDir); err != nil {
+				return fmt.Errorf("[%s] %v", name, err)
 			}
 
 			// Verify we have a font we can use. If the user doesn't provide a
@@ -115,9 +370,10 @@ func main() {
 			} else {
 				log.Printf("INFO:  [%s] Attempting to load font %s", name,
 					conf.FontFile)
+				var err error
 				font, err = vprinter.LoadFont(conf.FontFile)
 				if err != nil {
-					log.Fatalf("FATAL: [%s] couldn't load requested font: %v",
+					return fmt.Errorf("[%s] couldn't load requested font: %v",
 						name, err)
 				}
 				log.Printf("INFO:  [%s] Successfully loaded font %s", name,
@@ -125,65 +381,113 @@ func main() {
 			}
 			o := outputs[name]
 			o.font = font
+			o.filenames, _ = parseFilenameTemplate(o.FilenameTemplate)
 			outputs[name] = o
 		}
-	}
 
-	// If user requested that we print a single file, we will do so then quit.
-	if *printFile != "" {
-		// Does the requested output config exist?
-		o, ok := outputs[*output]
-		if !ok {
-			log.Fatalf("FATAL: Output configuration [%s] doesn't exist",
-				*output)
+		// Online outputs may spool their jobs to retry them later.
+		if conf.OnlineSpoolDir != "" {
+			if err := verifyOrCreateDir(conf.OnlineSpoolDir); err != nil {
+				return fmt.Errorf("[%s] %v", name, err)
+			}
+			o := outputs[name]
+			o.spool = newOnlineSpool(o)
+			outputs[name] = o
 		}
 
-		runFilePrinter(o, *printFile)
-
-		return
+		// The configuration has already been validated, so creating the FCB
+		// and printer model won't fail.
+		o := outputs[name]
+		o.fcb, _ = newFCB(o)
+		o.model, _ = newPrinterModel(o)
+		o.overflow, _ = scanner.ParseOverflowPolicy(o.LineOverflow)
+		o.stats = &outputStats{}
+		outputs[name] = o
 	}
 
-	// Otherwise...
-	// Start a thread for each input and run until they all stop...which will
-	// usually be never; typically user will Ctrl-C out of the agent. We'll
-	// wait 250ms between startups so the initial log messages from each don't
-	// intermingle.
-	var wg sync.WaitGroup
-	for input := range inputs {
-		wg.Add(1)
-		// the output for the input is guaranteed to exist because of the
-		// earlier config validation.
-		go runPrinter(input, inputs[input].Output, inputs[input],
-			outputs[inputs[input].Output], &wg)
-		time.Sleep(250 * time.Millisecond)
+	return nil
+}
+
+// setupInputs prepares validated input configurations for use. The
+// configuration has already been validated, so creating the end-of-job
+// detectors and codepages won't fail.
+func setupInputs(inputs map[string]InputConfig,
+	outputs map[string]OutputConfig) error {
+
+	for name, conf := range inputs {
+		if conf.CaptureDir != "" && *printFile == "" && *replayFile == "" {
+			if err := verifyOrCreateDir(conf.CaptureDir); err != nil {
+				return fmt.Errorf("[%s] %v", name, err)
+			}
+		}
+		if inputType(conf) == inputSpool && *printFile == "" &&
+			*replayFile == "" {
+			for _, dir := range []string{conf.SpoolDir,
+				conf.SpoolArchiveDir} {
+				if dir == "" {
+					continue
+				}
+				if err := verifyOrCreateDir(dir); err != nil {
+					return fmt.Errorf("[%s] %v", name, err)
+				}
+			}
+		}
+		conf.eoj, _ = newEOJDetector(conf)
+		conf.codepage, _ = scanner.LookupCodepage(conf.Codepage)
+		conf.unmappable, _ = scanner.ParseUnmappablePolicy(conf.Unmappable)
+		conf.allowed, _ = parseAllowedClients(conf.AllowedClients)
+		conf.queueOutputs = queueOutputs(conf, outputs)
+		conf.routes, conf.routeOutputs = compileRoutes(conf, outputs)
+		if conf.DeckSeparator != "" {
+			conf.separator = regexp.MustCompile(conf.DeckSeparator)
+		}
+		inputs[name] = conf
 	}
-	wg.Wait()
+
+	return nil
 }
 
-func runPrinter(inputName, outputName string, input InputConfig,
-	output OutputConfig, wg *sync.WaitGroup) {
+func runPrinter(ctx context.Context, inputName, outputName string,
+	input InputConfig, output OutputConfig, stats *jobStats,
+	wg *sync.WaitGroup) {
 
 	defer wg.Done()
-	var handler scanner.PrinterHandler
-	var err error
+	defer stats.setState(stateStopped)
 
 	log.Printf("INFO:  starting input/output pair [%s]/[%s]",
 		inputName, outputName)
-	if output.Mode == "local" {
-		log.Printf("INFO:  [%s] Will create PDFs in directory `%s`",
-			inputName, output.OutputDir)
-		// Set up our output handler
-		handler, err = newPDFOutputHandler(output.OutputDir, output.Profile,
-			output.font, inputName)
-		if err != nil {
-			log.Printf("ERROR: [%s] %v", inputName, err)
-			return
-		}
-	} else {
-		log.Printf("INFO:  [%s] will use online print API at `%s`",
-			inputName, output.ServiceAddress)
-		handler = newOnlineOutputHandler(output.ServiceAddress, output.APIKey,
-			output.Profile, inputName)
+
+	// Listen and raw inputs set up a handler for each connection, and LPD
+	// and IPP inputs for each queue. Punch inputs have card handlers rather
+	// than printer handlers.
+	switch inputType(input) {
+	case inputListen:
+		runListenInput(ctx, input, output, stats, inputName)
+		return
+	case inputLPD:
+		runLPD(ctx, input, output, stats, inputName)
+		return
+	case inputIPP:
+		runIPP(ctx, input, output, stats, inputName)
+		return
+	case inputRaw:
+		runRawInput(ctx, input, output, stats, inputName)
+		return
+	case inputPunch:
+		runPunch(ctx, input, output, stats, inputName)
+		return
+	}
+
+	handler, err := newInputHandler(input, output, inputName)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		return
+	}
+	handler = newCountingHandler(handler, stats)
+
+	if inputType(input) == inputSpool {
+		runSpool(ctx, input, output, handler, stats, inputName)
+		return
 	}
 
 	// Hercules sometimes closes connections on the printer socket device even
@@ -192,17 +496,33 @@ func runPrinter(inputName, outputName string, input InputConfig,
 	// socket close on Hercules' side is queued up and immediately executed on
 	// the next client the connects. Also, if someone stops and starts
 	// Hercules, we want the agent to automatically re-connect. So, we just
-	// loop forever with a 10 second pause between connection failures or
-	// disconnects.
+	// loop until we are shut down with a 10 second pause between connection
+	// failures or disconnects.
 	for {
-		handleHercules(input.HerculesAddress, handler, inputName)
+		handleHercules(ctx, input, output, handler, stats, inputName)
+		if ctx.Err() != nil {
+			return
+		}
+		stats.reconnecting()
 		log.Printf("INFO:  [%s] Re-trying Hercules connection in 10 seconds...",
 			inputName)
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
 	}
 }
 
-func runFilePrinter(output OutputConfig, filename string) {
+// binaryDataset is the record format and code page of a binary EBCDIC
+// dataset given to -printfile.
+type binaryDataset struct {
+	recfm    scanner.RecordFormat
+	codepage *scanner.Codepage
+}
+
+func runFilePrinter(ctx context.Context, output OutputConfig,
+	filename string, dataset *binaryDataset) {
 	var r io.ReadCloser
 	var jobname string
 	if *printFile == "-" {
@@ -215,63 +535,114 @@ func runFilePrinter(output OutputConfig, filename string) {
 				*printFile, err)
 		}
 		r = f
-		jobname = filepath.Base(filename)
+		jobname = fileJobName(filename)
 	}
 	defer r.Close()
 
-	// job name character set is pretty restricted, we'll change any
-	// non-allowed character to _
-	jobRegex := regexp.MustCompile(`[^a-zA-Z0-9_]`)
-	jobname = jobRegex.ReplaceAllString(jobname, "_")
-	// and limit to 25 characters if needed
-	if len(jobname) > 25 {
-		jobname = jobname[:25]
+	handler, err := newOutputHandler(output, "fileReader")
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
 	}
-
-	var handler scanner.PrinterHandler
-	var err error
-
-	if output.Mode == "local" {
-		log.Printf("INFO:  Will create PDF in directory `%s`",
-			output.OutputDir)
-		// Set up our output handler
-		handler, err = newPDFOutputHandler(output.OutputDir, output.Profile,
-			output.font, "fileReader")
+	handler = scanner.NewLineWidthHandler(handler, output.model.Columns,
+		output.overflow)
+
+	// A binary dataset is turned into lines of UTF-8 text for the scanners.
+	// Machine carriage control codes are left untranslated.
+	var in io.Reader = r
+	if dataset != nil {
+		rr, err := scanner.NewRecordReader(r, dataset.recfm, *lrecl)
 		if err != nil {
-			log.Printf("ERROR: %v", err)
-			return
+			log.Fatalf("FATAL: %v", err)
 		}
-	} else {
-		log.Printf("INFO:  will use online print API at `%s`",
-			output.ServiceAddress)
-		handler = newOnlineOutputHandler(output.ServiceAddress, output.APIKey,
-			output.Profile, "fileReader")
+		in = scanner.NewEBCDICReader(rr, dataset.codepage,
+			scanner.UnmappableReplace, *useMCC)
 	}
+
     if *useCDC {
-        err = scanner.ScanCDCUTF8Single(r, jobname, handler, *trace)
+        err = scanner.ScanCDCUTF8SingleContext(ctx, in, jobname, handler,
+			*trace)
     } else if *useASA {
-		err = scanner.ScanASAUTF8Single(r, jobname, handler, *trace)
+		err = scanner.ScanASAUTF8SingleContext(ctx, in, jobname, handler,
+			*trace)
+	} else if *useMCC {
+		err = scanner.ScanMCCSingleContext(ctx, in, jobname, handler, *trace)
 	} else {
-		err = scanner.ScanUTF8Single(r, jobname, handler, *trace)
+		err = scanner.ScanUTF8SingleContext(ctx, in, jobname, handler, *trace)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("INFO:  printing stopped before the end of the file")
+		return
 	}
 	if err != nil {
 		log.Fatalf("FATAL: %v", err)
 	}
 }
 
-func handleHercules(address string, handler scanner.PrinterHandler,
+// jobErrorReporter is implemented by the output handlers, so that inputs
+// that need to tell their clients whether a job was printed can find out.
+type jobErrorReporter interface {
+	// lastJobError returns the error that kept the last job from being
+	// written or delivered, or nil if it was successful.
+	lastJobError() error
+}
+
+// newOutputHandler sets up the printer handler for an output configuration.
+// inputName is used to identify the handler in log messages.
+func newOutputHandler(output OutputConfig,
+	inputName string) (scanner.PrinterHandler, error) {
+
+	if output.Mode == "local" {
+		log.Printf("INFO:  [%s] Will create PDFs in directory `%s`",
+			inputName, output.OutputDir)
+		return newPDFOutputHandler(output, inputName)
+	}
+
+	log.Printf("INFO:  [%s] will use online print API at `%s`",
+		inputName, output.ServiceAddress)
+	return newOnlineOutputHandler(output, inputName), nil
+}
+
+func handleHercules(ctx context.Context, input InputConfig,
+	output OutputConfig, handler scanner.PrinterHandler, stats *jobStats,
 	inputName string) {
 	log.Printf("INFO:  [%s] Connecting to Hercules on %s...", inputName,
-		address)
-	conn, err := net.Dial("tcp", address)
+		input.HerculesAddress)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", input.HerculesAddress)
+	if ctx.Err() != nil {
+		// We may have connected just as we were told to stop.
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
 	if err != nil {
 		log.Printf("ERROR: [%s] Couldn't connect: %v", inputName, err)
+		stats.setState(stateDisconnected)
+		stats.setError(err)
 		return
 	}
-	defer conn.Close()
 	log.Printf("INFO:  [%s] Connection successful.", inputName)
+	stats.setState(stateConnected)
+	defer stats.setState(stateDisconnected)
+
+	conn = stats.countConn(conn)
+	if input.CaptureDir != "" {
+		conn = startCapture(conn, input.CaptureDir, inputName)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("ERROR: [%s] %v", inputName, err)
+		}
+	}()
 
-	err = scanner.ScanWithLogTag(conn, handler, *trace, inputName)
+	err = scanner.ScanContext(ctx, conn, handler,
+		scanOptions(input, output, inputName))
+	if errors.Is(err, context.Canceled) {
+		log.Printf("INFO:  [%s] Disconnecting from Hercules.", inputName)
+		return
+	}
 	if err == io.EOF {
 		// we're done!
 		log.Printf("WARN:  [%s] Hercules disconnected.", inputName)
@@ -280,10 +651,38 @@ func handleHercules(address string, handler scanner.PrinterHandler,
 	if err != nil {
 		log.Printf("ERROR: [%s] error reading from Hercules: %s", inputName,
 			err)
+		stats.setError(err)
 		return
 	}
 }
 
+// countTrue returns the number of its arguments that are true.
+func countTrue(flags ...bool) int {
+	n := 0
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
+}
+
+// scanOptions returns the Hercules scanner options for an input printing to
+// output. tag identifies the scanner in log messages.
+func scanOptions(input InputConfig, output OutputConfig,
+	tag string) scanner.Options {
+
+	return scanner.Options{
+		Trace:      *trace,
+		Tag:        tag,
+		EOJ:        input.eoj,
+		Codepage:   input.codepage,
+		Unmappable: input.unmappable,
+		LineWidth:  output.model.Columns,
+		Overflow:   output.overflow,
+	}
+}
+
 // verifyOrCreateDir will check if path exists and is a directory. If so, the
 // returned error will be nil. If path doesn't exist, we will try to create
 // the directory, and if successful, returned error will be nil. In other
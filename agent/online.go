@@ -21,103 +21,404 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/racingmars/virtual1403/scanner"
 )
 
 type onlineOutputHandler struct {
-	buf       bytes.Buffer
-	enc       *zstd.Encoder
-	w         *bufio.Writer
+	stream    *jobStream
 	api       string
 	key       string
 	profile   string
+	model     string
+	columns   int
+	fcb       string
 	inputName string
+	spool     *onlineSpool
+	stats     *outputStats
+	lastErr   error
 }
 
-func newOnlineOutputHandler(api, key, profile,
+func newOnlineOutputHandler(output OutputConfig,
 	inputName string) scanner.PrinterHandler {
 
 	o := &onlineOutputHandler{
-		api:       api,
-		key:       key,
-		profile:   profile,
+		api:       output.ServiceAddress,
+		key:       output.APIKey,
+		profile:   output.Profile,
+		model:     output.model.Name,
+		columns:   output.LineWidth,
 		inputName: inputName,
+		spool:     output.spool,
+		stats:     output.stats,
 	}
-	o.enc, _ = zstd.NewWriter(&o.buf)
-	o.w = bufio.NewWriter(o.enc)
+	o.stream = newJobStream()
 	if o.profile == "" {
 		o.profile = "default"
 	}
+	if output.fcb != nil {
+		o.fcb = output.fcb.String()
+	}
 
 	return o
 }
 
 func (o *onlineOutputHandler) AddLine(line string, linefeed bool) {
-	command := "L:"
-	if !linefeed {
-		command = "O:"
-	}
-	o.w.WriteString(command + line + "\n")
+	o.stream.addLine(line, linefeed)
 }
 
 func (o *onlineOutputHandler) PageBreak() {
-	o.w.WriteString("P:\n")
+	o.stream.pageBreak()
+}
+
+func (o *onlineOutputHandler) SkipToChannel(channel int) {
+	o.stream.skipToChannel(channel)
 }
 
-func (o *onlineOutputHandler) EndOfJob(jobinfo string) {
-	o.w.WriteString("J:" + jobinfo + "\n")
+func (o *onlineOutputHandler) EndOfJob(job scanner.JobMetadata) {
+	body := o.stream.end(job)
 
 	// No matter what happens, we always want to reset our state to a fresh
-	// new job.
+	// new job. uploadErr is the error sending the job. If the job was
+	// spooled to retry later, the attempt is counted as a retry and the
+	// spool counts the job once it's delivered or given up on.
+	var uploadErr error
+	var retrying bool
 	defer func() {
-		// We could use Buffer.Reset(), but if this was a particularly large
-		// job, there's no reason for us to hold on to that much allocated
-		// memory indefinitely. All things considered, this is a low-volume
-		// application to paying for the allocation of a new buffer slice
-		// isn't going to have a noticable performance penalty.
-		o.buf = bytes.Buffer{}
-		o.enc, _ = zstd.NewWriter(&o.buf)
-		o.w = bufio.NewWriter(o.enc)
+		o.stream = newJobStream()
+
+		if retrying {
+			o.stats.retry(uploadErr)
+			return
+		}
+		// The service renders the PDF, so we don't know how many pages
+		// it has.
+		if uploadErr == nil {
+			uploadErr = o.lastErr
+		}
+		o.stats.endJob(0, uploadErr)
 	}()
 
-	o.w.Flush()
-	o.enc.Close()
+	o.lastErr = nil
 
-	// We now have a complete zstd-compressed job stream in o.buf.
+	query := url.Values{}
+	query.Set("profile", o.profile)
+	if o.model != "" {
+		query.Set("model", o.model)
+	}
+	if o.columns != 0 {
+		query.Set("columns", strconv.Itoa(o.columns))
+	}
+	if o.fcb != "" {
+		query.Set("fcb", o.fcb)
+	}
 
-	req, err := http.NewRequest(http.MethodPost,
-		o.api+"?profile="+o.profile, &o.buf)
-	if err != nil {
-		log.Printf("ERROR: [%s] unable to create HTTP request: %v",
-			o.inputName, err)
+	// With an online spool, the job is saved before we try to send it, so
+	// the retry worker can send it again if this attempt fails.
+	var spooled *spooledJob
+	if o.spool != nil {
+		var err error
+		spooled, err = o.spool.add(body, query.Encode(),
+			job.JobInfo())
+		if err != nil {
+			log.Printf("ERROR: [%s] unable to spool print job; it won't be "+
+				"retried if sending fails: %v", o.inputName, err)
+		}
+	}
+
+	log.Printf("INFO:  [%s] Sending print job to online print API...",
+		o.inputName)
+	result := postJob(o.api, o.key, query.Encode(), body)
+	uploadErr = result.err
+	if result.err == nil {
+		log.Printf("INFO:  [%s] Print API response status: %s", o.inputName,
+			result.status)
+	} else {
+		log.Printf("ERROR: [%s] %v", o.inputName, result.err)
+		o.lastErr = result.err
+	}
+	if spooled == nil {
+		return
+	}
+	if err := o.spool.done(spooled, result); err != nil {
+		o.lastErr = err
 		return
 	}
+	if result.err != nil && result.retryable {
+		// The job is safe in the spool, so as far as the input is
+		// concerned, it was delivered.
+		log.Printf("WARN:  [%s] print job spooled as %s; will retry at %s",
+			o.inputName, spooled.ID, spooled.NextAttempt.Format(time.DateTime))
+		o.lastErr = nil
+		retrying = true
+	}
+}
+
+// postResult is the outcome of sending a job to the print API.
+type postResult struct {
+	status string // the HTTP response status, if we got a response
+	code   int    // the HTTP response status code
+	err    error
+
+	// version is the print job protocol version the service said it
+	// speaks, or 0 if it didn't.
+	version int
+
+	// retryable is true if the job may be sent again later: the service
+	// couldn't be reached, had a server error, or is over quota.
+	retryable bool
+
+	// retryAfter is how long the service asked us to wait before trying
+	// again, if it did.
+	retryAfter time.Duration
+}
+
+// postJob sends a compressed job stream to the print API at api. Jobs are
+// downgraded to version 1 of the print job protocol for servers that don't
+// speak version 2.
+func postJob(api, key, query string, body []byte) postResult {
+	if !legacyAPI(api) {
+		result := sendJob(api, key, query, body)
+		if result.code != http.StatusBadRequest || result.version != 0 {
+			return result
+		}
+		// The server doesn't say which version it speaks, so it's an
+		// older server, which rejects version 2 jobs as invalid. We'll
+		// send it version 1 jobs from now on.
+		setLegacyAPI(api)
+	}
+
+	body, err := downgradeJobStream(body)
+	if err != nil {
+		return postResult{
+			err: fmt.Errorf("unable to convert print job for print API "+
+				"version 1: %v", err)}
+	}
+	return sendJob(api, key, downgradeQuery(query), body)
+}
+
+// sendJob makes the HTTP request that sends a job to the print API.
+func sendJob(api, key, query string, body []byte) postResult {
+	req, err := http.NewRequest(http.MethodPost, api+"?"+query,
+		bytes.NewReader(body))
+	if err != nil {
+		return postResult{
+			err: fmt.Errorf("unable to create HTTP request: %v", err)}
+	}
 
 	req.Header.Set("Content-Encoding", "zstd")
 	req.Header.Set("Content-Type", "text/x-print-job")
-	req.Header.Set("Authorization", "Bearer "+o.key)
+	req.Header.Set("Authorization", "Bearer "+key)
 
-	log.Printf("INFO:  [%s] Sending print job to online print API...",
-		o.inputName)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("ERROR: [%s] unable to execute HTTP request: %v",
-			o.inputName, err)
-		return
+		return postResult{
+			err:       fmt.Errorf("unable to execute HTTP request: %v", err),
+			retryable: true,
+		}
 	}
 	defer resp.Body.Close()
 	defer io.ReadAll(resp.Body) // ensure keep-alive client reuse when able
 
+	version, _ := strconv.Atoi(resp.Header.Get("X-Print-Job-Version"))
+	if version >= 2 {
+		// The server may have been upgraded since we last heard from it.
+		clearLegacyAPI(api)
+	}
+
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		log.Printf("INFO:  [%s] Print API response status: %s", o.inputName,
-			resp.Status)
-	} else {
-		log.Printf("ERROR: [%s] Print API response status: %s", o.inputName,
-			resp.Status)
+		return postResult{status: resp.Status, code: resp.StatusCode,
+			version: version}
+	}
+	return postResult{
+		status:  resp.Status,
+		code:    resp.StatusCode,
+		version: version,
+		err:     fmt.Errorf("print API response status: %s", resp.Status),
+		retryable: resp.StatusCode >= 500 ||
+			resp.StatusCode == http.StatusTooManyRequests,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// Version 2 of the print job protocol added the C: and M: directives and
+// the "model", "columns", and "fcb" query parameters. Servers that speak it
+// say so in the X-Print-Job-Version header of every response; older servers
+// reject C: and M: as invalid directives.
+
+// legacyAPITTL is how long we keep sending version 1 jobs to a print API
+// before trying version 2 again, in case it was upgraded.
+const legacyAPITTL = time.Hour
+
+// legacyAPIs holds when we found each print API that only speaks version 1.
+var legacyAPIs = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// legacyAPI returns true if the print API at api only speaks version 1 of
+// the print job protocol, as far as we know.
+func legacyAPI(api string) bool {
+	legacyAPIs.Lock()
+	defer legacyAPIs.Unlock()
+	found, ok := legacyAPIs.m[api]
+	return ok && time.Since(found) < legacyAPITTL
+}
+
+func setLegacyAPI(api string) {
+	legacyAPIs.Lock()
+	legacyAPIs.m[api] = time.Now()
+	legacyAPIs.Unlock()
+}
+
+func clearLegacyAPI(api string) {
+	legacyAPIs.Lock()
+	delete(legacyAPIs.m, api)
+	legacyAPIs.Unlock()
+}
+
+// downgradeJobStream converts a compressed print job stream to version 1 of
+// the protocol: M: directives are dropped, a skip to channel 1 becomes a
+// page break, and skips to other channels are dropped, since a version 1
+// server has no forms control buffer.
+func downgradeJobStream(body []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	stream := newJobStream()
+	s := bufio.NewScanner(dec)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "M:"):
+			continue
+		case line == "C:1":
+			line = "P:"
+		case strings.HasPrefix(line, "C:"):
+			continue
+		}
+		stream.w.WriteString(line + "\n")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	stream.w.Flush()
+	stream.enc.Close()
+	return stream.buf.Bytes(), nil
+}
+
+// downgradeQuery removes the query parameters that version 1 of the print
+// job protocol doesn't have.
+func downgradeQuery(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+	for _, param := range []string{"model", "columns", "fcb"} {
+		values.Del(param)
+	}
+	return values.Encode()
+}
+
+// parseRetryAfter returns the wait asked for by a Retry-After header, which
+// is either a number of seconds or a time, or 0 if there isn't one.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func (o *onlineOutputHandler) lastJobError() error {
+	return o.lastErr
+}
+
+// jobStream builds the zstd-compressed print job stream for a job, in the
+// text/x-print-job format the online print API takes: a directive for each
+// printer operation, then the job's metadata.
+type jobStream struct {
+	buf bytes.Buffer
+	enc *zstd.Encoder
+	w   *bufio.Writer
+}
+
+func newJobStream() *jobStream {
+	// We could reuse the buffer for the next job, but if this was a
+	// particularly large job, there's no reason for us to hold on to that
+	// much allocated memory indefinitely. All things considered, this is a
+	// low-volume application so paying for the allocation of a new buffer
+	// slice isn't going to have a noticable performance penalty.
+	s := &jobStream{}
+	s.enc, _ = zstd.NewWriter(&s.buf)
+	s.w = bufio.NewWriter(s.enc)
+	return s
+}
+
+func (s *jobStream) addLine(line string, linefeed bool) {
+	command := "L:"
+	if !linefeed {
+		command = "O:"
+	}
+	s.w.WriteString(command + line + "\n")
+}
+
+func (s *jobStream) pageBreak() {
+	s.w.WriteString("P:\n")
+}
+
+func (s *jobStream) skipToChannel(channel int) {
+	s.w.WriteString("C:" + strconv.Itoa(channel) + "\n")
+}
+
+// end finishes the stream with the job's metadata, and returns the complete
+// compressed stream.
+func (s *jobStream) end(job scanner.JobMetadata) []byte {
+	writeMetadataDirectives(s.w, job)
+	s.w.WriteString("J:" + job.JobInfo() + "\n")
+	s.w.Flush()
+	s.enc.Close()
+	return s.buf.Bytes()
+}
+
+// writeMetadataDirectives writes an M: directive for each known field of the
+// job metadata.
+func writeMetadataDirectives(w *bufio.Writer, job scanner.JobMetadata) {
+	for _, f := range []struct{ key, value string }{
+		{"number", job.Number},
+		{"name", job.Name},
+		{"type", job.Type},
+		{"programmer", job.Programmer},
+		{"room", job.Room},
+		{"class", job.Class},
+	} {
+		if f.value != "" {
+			w.WriteString("M:" + f.key + "=" + f.value + "\n")
+		}
+	}
+	if !job.Start.IsZero() {
+		w.WriteString("M:start=" + job.Start.Format(time.RFC3339) + "\n")
+	}
+	if !job.End.IsZero() {
+		w.WriteString("M:end=" + job.End.Format(time.RFC3339) + "\n")
 	}
 }
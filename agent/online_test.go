@@ -0,0 +1,85 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+func TestPostJobVersions(t *testing.T) {
+	type testcase struct {
+		version  string // the server's X-Print-Job-Version header
+		valid    bool   // whether the server accepts the job
+		requests []int  // requests the server gets for each of two jobs
+		ok       bool
+	}
+	var testcases []testcase = []testcase{
+		{"2", true, []int{1, 1}, true},
+		{"2", false, []int{1, 1}, false},
+		{"", true, []int{2, 1}, true},
+		{"", false, []int{2, 1}, false},
+	}
+
+	s := newJobStream()
+	s.addLine("LINE", true)
+	s.skipToChannel(1)
+	body := s.end(scanner.JobMetadata{Name: "MYJOB"})
+
+	for _, c := range testcases {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if c.version != "" {
+					w.Header().Set("X-Print-Job-Version", c.version)
+				}
+				dec, _ := zstd.NewReader(r.Body)
+				job, _ := io.ReadAll(dec)
+				dec.Close()
+				// A version 1 server rejects version 2 jobs.
+				v2 := strings.Contains(string(job), "M:") ||
+					r.URL.Query().Has("model")
+				if !c.valid || (c.version == "" && v2) {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+
+		for i, want := range c.requests {
+			requests = 0
+			result := postJob(server.URL, "key", "profile=default&model=1403",
+				body)
+			if (result.err == nil) != c.ok || result.retryable {
+				t.Errorf("Got %+v for job %d with version `%s`", result, i,
+					c.version)
+			}
+			if requests != want {
+				t.Errorf("Got %d requests instead of %d for job %d with "+
+					"version `%s`", requests, want, i, c.version)
+			}
+		}
+		server.Close()
+	}
+}
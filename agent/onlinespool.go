@@ -0,0 +1,563 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// An online output with an online_spool_directory saves each job's
+// compressed job stream there before sending it to the print API, so that
+// jobs aren't lost when the service can't be reached. Each job is a .zst
+// file with the request body, and a .json file with what we need to send
+// it again and when to do so. If the first attempt fails with a network
+// error, a 5xx response, or a 429 (over quota) response, the job stays in
+// the spool and the retry worker sends it again later, backing off
+// exponentially or for as long as the service's Retry-After header says.
+// Jobs the service rejects for any other reason stay in the spool marked as
+// failed, until they are retried or purged with the -spool command.
+//
+// Whoever is working on a job -- an output handler sending it, the retry
+// worker, or a -spool command in another process -- first claims it by
+// creating its .claim file, which only one of them can do. Claims left
+// behind by an agent that didn't shut down cleanly are removed when the
+// retry worker starts.
+
+// Default limits on the online spool, when the output doesn't set them.
+const (
+	defaultOnlineSpoolMaxJobs = 1000
+	defaultOnlineSpoolMaxAge  = 7 * 24 * time.Hour
+)
+
+// Retry timing for spooled jobs.
+const (
+	onlineSpoolPollInterval = 10 * time.Second
+	onlineRetryMinBackoff   = 30 * time.Second
+	onlineRetryMaxBackoff   = time.Hour
+)
+
+// spooledJob is the .json file describing a job in the online spool.
+type spooledJob struct {
+	ID          string    `json:"-"`
+	JobInfo     string    `json:"job"`
+	Query       string    `json:"query"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Failed      bool      `json:"failed,omitempty"`
+}
+
+// onlineSpool is an online output's spool directory. The output handlers
+// for the output and its retry worker share it.
+type onlineSpool struct {
+	dir string
+
+	// opened is when we started using the spool. Claims older than that
+	// were left behind by an earlier run of the agent.
+	opened time.Time
+
+	// mu protects the limits, which can change when the configuration is
+	// reloaded.
+	mu      sync.Mutex
+	maxJobs int
+	maxAge  time.Duration
+}
+
+func newOnlineSpool(output OutputConfig) *onlineSpool {
+	s := &onlineSpool{
+		dir:     output.OnlineSpoolDir,
+		opened:  time.Now(),
+		maxJobs: output.OnlineSpoolMaxJobs,
+		maxAge:  time.Duration(output.OnlineSpoolMaxAge) * time.Hour,
+	}
+	if s.maxJobs == 0 {
+		s.maxJobs = defaultOnlineSpoolMaxJobs
+	}
+	if s.maxAge == 0 {
+		s.maxAge = defaultOnlineSpoolMaxAge
+	}
+	return s
+}
+
+// limits returns the most jobs the spool holds, and how long it keeps them.
+func (s *onlineSpool) limits() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxJobs, s.maxAge
+}
+
+// setLimits changes the most jobs the spool holds, and how long it keeps
+// them.
+func (s *onlineSpool) setLimits(maxJobs int, maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxJobs, s.maxAge = maxJobs, maxAge
+}
+
+func validateOnlineSpool(name string, config OutputConfig) []error {
+	var errs []error
+
+	if config.OnlineSpoolDir != "" && config.Mode != "online" {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'online_spool_directory' is only used "+
+				"in online mode", name))
+	}
+	if config.OnlineSpoolMaxJobs < 0 {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'online_spool_max_jobs' must not be "+
+				"negative", name))
+	}
+	if config.OnlineSpoolMaxAge < 0 {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'online_spool_max_age_hours' must not "+
+				"be negative", name))
+	}
+
+	return errs
+}
+
+// add saves a job in the spool before we first try to send it, and claims
+// it. The retry worker won't pick it up until done is called.
+func (s *onlineSpool) add(body []byte, query,
+	jobInfo string) (*spooledJob, error) {
+
+	jobs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if maxJobs, _ := s.limits(); len(jobs) >= maxJobs {
+		return nil, fmt.Errorf("online spool `%s` is full (%d jobs)", s.dir,
+			len(jobs))
+	}
+
+	// The timestamp at the start of the ID keeps the jobs in the order they
+	// were printed.
+	f, err := os.CreateTemp(s.dir,
+		time.Now().UTC().Format("20060102T150405")+"-*.zst")
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSuffix(filepath.Base(f.Name()), ".zst")
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &spooledJob{
+		ID:          id,
+		JobInfo:     jobInfo,
+		Query:       query,
+		Created:     now,
+		NextAttempt: now.Add(onlineRetryMinBackoff),
+	}
+	if err := s.createClaim(id); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := s.save(job); err != nil {
+		s.release(id)
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return job, nil
+}
+
+// claimPath returns the path of a job's claim file.
+func (s *onlineSpool) claimPath(id string) string {
+	return filepath.Join(s.dir, id+".claim")
+}
+
+// createClaim creates a job's claim file, failing if it already exists.
+func (s *onlineSpool) createClaim(id string) error {
+	f, err := os.OpenFile(s.claimPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	return f.Close()
+}
+
+// release gives up our claim on a job, letting others pick it up again.
+func (s *onlineSpool) release(id string) {
+	os.Remove(s.claimPath(id))
+}
+
+// claim claims a job and returns it as it is now, or returns nil if someone
+// else has claimed it or it is no longer in the spool.
+func (s *onlineSpool) claim(id string) (*spooledJob, error) {
+	if err := s.createClaim(id); errors.Is(err, os.ErrExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job, err := s.load(id)
+	if err != nil || job == nil {
+		s.release(id)
+		return nil, err
+	}
+	return job, nil
+}
+
+// removeStaleClaims removes the claims left behind by an earlier run of the
+// agent, which can't still be working on the jobs.
+func (s *onlineSpool) removeStaleClaims() error {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.claim"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err == nil && info.ModTime().Before(s.opened) {
+			err = os.Remove(m)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes a job's .json file. We write it to a temporary file first, so
+// a crash can't leave a partial file behind.
+func (s *onlineSpool) save(job *spooledJob) error {
+	b, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, job.ID+".json.tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, job.ID+".json"))
+}
+
+// remove deletes a job from the spool.
+func (s *onlineSpool) remove(id string) error {
+	err := os.Remove(filepath.Join(s.dir, id+".json"))
+	if err2 := os.Remove(filepath.Join(s.dir,
+		id+".zst")); err == nil && !os.IsNotExist(err2) {
+		err = err2
+	}
+	return err
+}
+
+// body returns a spooled job's compressed job stream.
+func (s *onlineSpool) body(id string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, id+".zst"))
+}
+
+// load reads a job's .json file, returning nil if the job isn't in the
+// spool.
+func (s *onlineSpool) load(id string) (*spooledJob, error) {
+	path := filepath.Join(s.dir, id+".json")
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := &spooledJob{}
+	if err := json.Unmarshal(b, job); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	job.ID = id
+	return job, nil
+}
+
+// list returns the jobs in the spool, oldest first.
+func (s *onlineSpool) list() ([]*spooledJob, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var jobs []*spooledJob
+	for _, m := range matches {
+		job, err := s.load(strings.TrimSuffix(filepath.Base(m), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			// Sent and removed while we were looking.
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// done records the result of an attempt to send a job, removing it from the
+// spool if it was sent, or scheduling the next attempt if not. It returns
+// the error, if any, that the job won't be retried after.
+func (s *onlineSpool) done(job *spooledJob, result postResult) error {
+	defer s.release(job.ID)
+
+	if result.err == nil {
+		return s.remove(job.ID)
+	}
+
+	job.Attempts++
+	job.LastError = result.err.Error()
+	if result.retryable {
+		backoff := onlineRetryMinBackoff << min(job.Attempts-1, 16)
+		backoff = min(backoff, onlineRetryMaxBackoff)
+		backoff = max(backoff, result.retryAfter)
+		job.NextAttempt = time.Now().Add(backoff)
+	} else {
+		job.Failed = true
+	}
+	if err := s.save(job); err != nil {
+		return err
+	}
+	if job.Failed {
+		return result.err
+	}
+	return nil
+}
+
+// runOnlineSpool sends the jobs in an output's online spool until ctx is
+// done.
+func runOnlineSpool(ctx context.Context, output OutputConfig,
+	outputName string, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	s := output.spool
+	log.Printf("INFO:  [%s] Retrying online jobs spooled in `%s`", outputName,
+		s.dir)
+	if err := s.removeStaleClaims(); err != nil {
+		log.Printf("ERROR: [%s] %v", outputName, err)
+	}
+
+	for {
+		s.retryDue(ctx, output, outputName)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(onlineSpoolPollInterval):
+		}
+	}
+}
+
+// retryDue expires the jobs in the spool that are too old, and sends the
+// ones whose next attempt is due.
+func (s *onlineSpool) retryDue(ctx context.Context, output OutputConfig,
+	outputName string) {
+
+	jobs, err := s.list()
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", outputName, err)
+		return
+	}
+
+	for _, listed := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		job, err := s.claim(listed.ID)
+		if err != nil {
+			log.Printf("ERROR: [%s] %v", outputName, err)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		if _, maxAge := s.limits(); time.Since(job.Created) > maxAge {
+			log.Printf("WARN:  [%s] discarding spooled job %s (%s), which "+
+				"is older than %v", outputName, job.ID, job.JobInfo, maxAge)
+			if err := s.remove(job.ID); err != nil {
+				log.Printf("ERROR: [%s] %v", outputName, err)
+			}
+			s.release(job.ID)
+			output.stats.endJob(0, fmt.Errorf("spooled job %s expired",
+				job.ID))
+			continue
+		}
+		if job.Failed || time.Now().Before(job.NextAttempt) {
+			s.release(job.ID)
+			continue
+		}
+
+		body, err := s.body(job.ID)
+		if err != nil {
+			log.Printf("ERROR: [%s] spooled job %s: %v", outputName, job.ID,
+				err)
+			s.release(job.ID)
+			continue
+		}
+		log.Printf("INFO:  [%s] Re-sending spooled job %s (%s) to online "+
+			"print API...", outputName, job.ID, job.JobInfo)
+		result := postJob(output.ServiceAddress, output.APIKey, job.Query,
+			body)
+		if err := s.done(job, result); err != nil {
+			log.Printf("ERROR: [%s] spooled job %s failed: %v", outputName,
+				job.ID, err)
+		}
+		// Each job is counted once, when it's delivered or given up on.
+		if result.err != nil && !job.Failed {
+			output.stats.retry(result.err)
+		} else {
+			output.stats.endJob(0, result.err)
+		}
+		if result.err == nil {
+			log.Printf("INFO:  [%s] sent spooled job %s", outputName, job.ID)
+			continue
+		}
+		if result.retryable {
+			log.Printf("WARN:  [%s] spooled job %s: %v; will retry at %s",
+				outputName, job.ID, result.err,
+				job.NextAttempt.Format(time.DateTime))
+			// The service is probably still unavailable, so the rest of
+			// the jobs can wait for the next pass.
+			return
+		}
+	}
+}
+
+// runSpoolCommand runs the -spool command on the online spools of outputs,
+// or only the one named outputName if it isn't empty. ids limits retry and
+// purge to those jobs.
+func runSpoolCommand(command string, outputs map[string]OutputConfig,
+	outputName string, ids []string) error {
+
+	switch command {
+	case "list", "retry", "purge":
+	default:
+		return fmt.Errorf("unknown -spool command `%s`; use list, retry, "+
+			"or purge", command)
+	}
+
+	var names []string
+	for name, o := range outputs {
+		if o.spool != nil && (outputName == "" || name == outputName) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return errors.New("no online outputs with an " +
+			"'online_spool_directory' are configured")
+	}
+	sort.Strings(names)
+
+	selected := func(id string) bool {
+		if len(ids) == 0 {
+			return true
+		}
+		for _, i := range ids {
+			if i == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	if command == "list" {
+		fmt.Fprintln(w, "OUTPUT\tID\tJOB\tCREATED\tATTEMPTS\tSTATUS")
+	}
+
+	for _, name := range names {
+		s := outputs[name].spool
+		jobs, err := s.list()
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if !selected(job.ID) {
+				continue
+			}
+			if command == "list" {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", name, job.ID,
+					job.JobInfo, job.Created.Format(time.DateTime),
+					job.Attempts, job.status())
+				continue
+			}
+			if err := s.spoolCommand(command, job.ID, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// spoolCommand retries or purges a job, unless the running agent is sending
+// it.
+func (s *onlineSpool) spoolCommand(command, id, outputName string) error {
+	job, err := s.claim(id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		log.Printf("WARN:  [%s] job %s is being sent or is already gone; "+
+			"try again later", outputName, id)
+		return nil
+	}
+	defer s.release(id)
+
+	if command == "purge" {
+		if err := s.remove(id); err != nil {
+			return err
+		}
+		log.Printf("INFO:  [%s] purged job %s", outputName, id)
+		return nil
+	}
+
+	// The retry worker picks the job up on its next pass.
+	job.Failed = false
+	job.NextAttempt = time.Now()
+	if err := s.save(job); err != nil {
+		return err
+	}
+	log.Printf("INFO:  [%s] job %s will be retried", outputName, id)
+	return nil
+}
+
+// status describes a spooled job's state for -spool list.
+func (job *spooledJob) status() string {
+	if job.Failed {
+		return "failed: " + job.LastError
+	}
+	if job.LastError != "" {
+		return fmt.Sprintf("retry at %s (%s)",
+			job.NextAttempt.Format(time.DateTime), job.LastError)
+	}
+	return "waiting"
+}
@@ -0,0 +1,229 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	type testcase struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}
+	var testcases []testcase = []testcase{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			59 * time.Minute, time.Hour},
+	}
+
+	for _, c := range testcases {
+		if got := parseRetryAfter(c.value); got < c.min || got > c.max {
+			t.Errorf("Got %v instead of %v to %v for `%s`", got, c.min,
+				c.max, c.value)
+		}
+	}
+}
+
+func TestOnlineSpoolDone(t *testing.T) {
+	type testcase struct {
+		attempts int // before this one
+		result   postResult
+		backoff  time.Duration
+		failed   bool
+	}
+	errSend := errors.New("send failed")
+	var testcases []testcase = []testcase{
+		{0, postResult{err: errSend, retryable: true},
+			onlineRetryMinBackoff, false},
+		{1, postResult{err: errSend, retryable: true},
+			2 * onlineRetryMinBackoff, false},
+		{3, postResult{err: errSend, retryable: true},
+			8 * onlineRetryMinBackoff, false},
+		{40, postResult{err: errSend, retryable: true},
+			onlineRetryMaxBackoff, false},
+		{0, postResult{err: errSend, retryable: true,
+			retryAfter: 10 * time.Minute}, 10 * time.Minute, false},
+		{0, postResult{err: errSend, retryable: true,
+			retryAfter: time.Second}, onlineRetryMinBackoff, false},
+		{40, postResult{err: errSend, retryable: true,
+			retryAfter: 2 * time.Hour}, 2 * time.Hour, false},
+		{0, postResult{err: errSend}, 0, true},
+	}
+
+	s := newOnlineSpool(OutputConfig{OnlineSpoolDir: t.TempDir()})
+	for _, c := range testcases {
+		job, err := s.add([]byte("body"), "", "J1_TEST")
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Attempts = c.attempts
+
+		start := time.Now()
+		err = s.done(job, c.result)
+		if c.failed != (err != nil) {
+			t.Errorf("Got error %v for %+v", err, c)
+		}
+
+		saved, loadErr := s.load(job.ID)
+		if loadErr != nil || saved == nil {
+			t.Fatalf("job %s isn't in the spool: %v", job.ID, loadErr)
+		}
+		if saved.Attempts != c.attempts+1 || saved.Failed != c.failed ||
+			saved.LastError != errSend.Error() {
+			t.Errorf("Got saved job %+v for %+v", saved, c)
+		}
+		if !c.failed {
+			backoff := saved.NextAttempt.Sub(start)
+			if backoff < c.backoff || backoff > c.backoff+time.Second {
+				t.Errorf("Got backoff %v instead of %v for %+v", backoff,
+					c.backoff, c)
+			}
+		}
+		if _, err := os.Stat(s.claimPath(job.ID)); err == nil {
+			t.Errorf("job %s is still claimed", job.ID)
+		}
+	}
+}
+
+func TestOnlineSpoolDoneSent(t *testing.T) {
+	s := newOnlineSpool(OutputConfig{OnlineSpoolDir: t.TempDir()})
+	job, err := s.add([]byte("body"), "", "J1_TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.done(job, postResult{status: "200 OK"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*"))
+	if len(files) != 0 {
+		t.Errorf("Got %v left in the spool", files)
+	}
+}
+
+func TestOnlineSpoolClaim(t *testing.T) {
+	s := newOnlineSpool(OutputConfig{OnlineSpoolDir: t.TempDir()})
+	job, err := s.add([]byte("body"), "", "J1_TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add claims the job, so no one else can until it's released.
+	if claimed, err := s.claim(job.ID); claimed != nil || err != nil {
+		t.Errorf("Claimed a job that was already claimed: %v", err)
+	}
+	s.release(job.ID)
+	claimed, err := s.claim(job.ID)
+	if claimed == nil || err != nil {
+		t.Fatalf("Couldn't claim a released job: %v", err)
+	}
+	if claimed.JobInfo != "J1_TEST" {
+		t.Errorf("Got claimed job %+v", claimed)
+	}
+
+	// A claim from before the spool was opened is stale.
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(s.claimPath(job.ID), old, old)
+	if err := s.removeStaleClaims(); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := s.claim(job.ID); claimed == nil {
+		t.Errorf("Stale claim wasn't removed")
+	}
+
+	s.release(job.ID)
+	s.remove(job.ID)
+	if claimed, err := s.claim(job.ID); claimed != nil || err != nil {
+		t.Errorf("Claimed a job that isn't in the spool: %v", err)
+	}
+}
+
+func TestOnlineSpoolRetryStats(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Print-Job-Version", "2")
+			w.WriteHeader(status)
+		}))
+	defer server.Close()
+
+	stats := &outputStats{}
+	output := OutputConfig{ServiceAddress: server.URL,
+		OnlineSpoolDir: t.TempDir(), stats: stats}
+	s := newOnlineSpool(output)
+
+	// retry makes the spool try to send its jobs now, however long it
+	// would have waited.
+	retry := func() {
+		jobs, err := s.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range jobs {
+			job.NextAttempt = time.Time{}
+			if err := s.save(job); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.retryDue(context.Background(), output, "default")
+	}
+	job, err := s.add([]byte("body"), "", "J1_TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.release(job.ID)
+
+	// Each failed attempt is a retry, and the job is counted once it's
+	// finally sent.
+	for _, status = range []int{http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable, http.StatusOK} {
+		retry()
+	}
+	if stats.jobs != 1 || stats.failures != 0 || stats.retries != 2 {
+		t.Errorf("Got %d jobs, %d failures, and %d retries instead of 1, "+
+			"0, and 2", stats.jobs, stats.failures, stats.retries)
+	}
+
+	// A job the service rejects is a single failure, and isn't tried
+	// again.
+	job, err = s.add([]byte("body"), "", "J2_TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.release(job.ID)
+	status = http.StatusBadRequest
+	retry()
+	retry()
+	if stats.jobs != 1 || stats.failures != 1 || stats.retries != 2 {
+		t.Errorf("Got %d jobs, %d failures, and %d retries instead of 1, "+
+			"1, and 2", stats.jobs, stats.failures, stats.retries)
+	}
+}
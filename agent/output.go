@@ -19,10 +19,7 @@ package main
 // along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
 
 import (
-	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/racingmars/virtual1403/scanner"
@@ -32,43 +29,113 @@ import (
 type pdfOutputHandler struct {
 	job       vprinter.Job
 	outputDir string
+	filenames *filenameTemplate
 	font      []byte
 	inputName string
 	profile   string
+	model     vprinter.PrinterModel
+	fcb       *vprinter.FCB
+	stats     *outputStats
+	lastErr   error
+
+	// filter and postJob are the output's hook commands, and text is the
+	// job's text for the filter and the archive, if keepText is set.
+	filter      []string
+	postJob     []string
+	hookTimeout time.Duration
+	keepText    bool
+	text        jobText
+
+	// archive collects what we archive next to the PDF, if anything.
+	archive *jobArchive
 }
 
-func newPDFOutputHandler(outputDir, profile string, fontOverride []byte,
+func newPDFOutputHandler(output OutputConfig,
 	inputName string) (scanner.PrinterHandler, error) {
 
 	o := &pdfOutputHandler{
-		outputDir: outputDir,
-		font:      fontOverride,
+		outputDir: output.OutputDir,
+		filenames: output.filenames,
+		font:      output.font,
 		inputName: inputName,
-		profile:   profile,
+		profile:   output.Profile,
+		model:     output.model,
+		fcb:       output.fcb,
+		stats:     output.stats,
+
+		filter:      output.FilterCommand,
+		postJob:     output.PostJobCommand,
+		hookTimeout: hookTimeout(output),
+		archive:     newJobArchive(output),
 	}
+	o.keepText = o.filter != nil || output.ArchiveText
 	var err error
 
-	o.job, err = vprinter.NewProfile(profile, fontOverride, 11.4)
+	o.job, err = o.newJob()
 	if err != nil {
 		return nil, err
 	}
 	return o, nil
 }
 
+// newJob creates a virtual printer for a new job, loaded with our FCB.
+func (o *pdfOutputHandler) newJob() (vprinter.Job, error) {
+	job, err := vprinter.NewProfileForModel(o.profile, o.model, o.font, 11.4)
+	if err != nil {
+		return nil, err
+	}
+	if o.fcb != nil {
+		job.LoadFCB(o.fcb)
+	}
+	return job, nil
+}
+
 func (o *pdfOutputHandler) AddLine(line string, linefeed bool) {
-	o.job.AddLine(line, linefeed)
+	pages := o.job.AddLine(line, linefeed)
+	if o.keepText {
+		o.text.addLine(line, linefeed)
+		o.text.setPages(pages)
+	}
+	if o.archive != nil {
+		o.archive.addLine(line, linefeed)
+	}
 }
 
 func (o *pdfOutputHandler) PageBreak() {
-	o.job.NewPage()
+	pages := o.job.NewPage()
+	if o.keepText {
+		o.text.setPages(pages)
+	}
+	if o.archive != nil {
+		o.archive.pageBreak()
+	}
 }
 
-func (o *pdfOutputHandler) EndOfJob(jobinfo string) {
+func (o *pdfOutputHandler) SkipToChannel(channel int) {
+	pages := o.job.SkipToChannel(channel)
+	if o.keepText {
+		o.text.setPages(pages)
+	}
+	if o.archive != nil {
+		o.archive.skipToChannel(channel)
+	}
+}
+
+func (o *pdfOutputHandler) EndOfJob(job scanner.JobMetadata) {
 	// No matter what happens, we always want to reset our state to a fresh
 	// new job.
+	pages := 0
+	vetoed := false
 	defer func() {
+		if !vetoed {
+			o.stats.endJob(pages, o.lastErr)
+		}
+		o.text.reset()
+		if o.archive != nil {
+			o.archive.reset()
+		}
 		var err error
-		o.job, err = vprinter.NewProfile(o.profile, o.font, 11.4)
+		o.job, err = o.newJob()
 		if err != nil {
 			log.Printf("ERROR: [%s] couldn't re-initialize virtual 1403: %v",
 				o.inputName, err)
@@ -78,27 +145,78 @@ func (o *pdfOutputHandler) EndOfJob(jobinfo string) {
 		}
 	}()
 
-	if jobinfo != "" {
-		jobinfo = jobinfo + "-"
+	o.lastErr = nil
+	if o.archive != nil {
+		o.archive.endJob()
+	}
+	hook := hookJob{job: job, inputName: o.inputName, profile: o.profile}
+	if o.filter != nil {
+		ok, err := filterJob(o.filter, o.hookTimeout, hook, o.text.Bytes())
+		if err != nil {
+			log.Printf("ERROR: [%s] filter command failed; printing job "+
+				"%s anyway: %v", o.inputName, job.JobInfo(), err)
+		} else if !ok {
+			log.Printf("INFO:  [%s] filter command vetoed job %s",
+				o.inputName, job.JobInfo())
+			vetoed = true
+			return
+		}
 	}
-	jobfilename := fmt.Sprintf("v1403-%s%s.pdf", jobinfo,
-		time.Now().UTC().Format("20060102T030405"))
-	filename := filepath.Join(o.outputDir, jobfilename)
 
-	f, err := os.Create(filename)
+	f, err := createOutputFile(o.outputDir, "pdf")
 	if err != nil {
 		log.Printf("ERROR: [%s] couldn't create output file: %v",
 			o.inputName, err)
+		o.lastErr = err
 		return
 	}
-	defer f.Close()
-	n, err := o.job.EndJob(f)
+	pages, err = o.job.EndJob(f)
 	if err != nil {
+		f.discard()
 		log.Printf("ERROR: [%s] couldn't write PDF output: %v", o.inputName,
 			err)
+		o.lastErr = err
 		return
 	}
+	files := []*outputFile{f}
+	if o.archive != nil {
+		archived, err := o.archive.writeFiles(o.outputDir, job,
+			o.text.Bytes(), jobManifest{
+				Input:        o.inputName,
+				Profile:      hook.profileName(),
+				PrinterModel: o.model.Name,
+				Pages:        pages,
+				Written:      time.Now(),
+			})
+		if err != nil {
+			log.Printf("ERROR: [%s] couldn't write archive files for job "+
+				"%s: %v", o.inputName, job.JobInfo(), err)
+		}
+		files = append(files, archived...)
+	}
+	names, err := commitOutputFiles(o.outputDir, o.filenames,
+		nameFields{job: job, input: o.inputName, pages: pages,
+			time: time.Now().UTC()}, files...)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't write PDF output: %v", o.inputName,
+			err)
+		o.lastErr = err
+		return
+	}
+	filename := names[0]
 
-	log.Printf("INFO:  [%s] wrote %d page PDF to %s", o.inputName, n,
+	log.Printf("INFO:  [%s] wrote %d page PDF to %s", o.inputName, pages,
 		filename)
+
+	if o.postJob != nil {
+		hook.file, hook.pages = filename, pages
+		if err := runHook(o.postJob, o.hookTimeout, hook, nil); err != nil {
+			log.Printf("ERROR: [%s] post-job command failed for %s: %v",
+				o.inputName, filename, err)
+		}
+	}
+}
+
+func (o *pdfOutputHandler) lastJobError() error {
+	return o.lastErr
 }
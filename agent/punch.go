@@ -0,0 +1,437 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+	"github.com/racingmars/virtual1403/vprinter"
+)
+
+// A punch input connects to a Hercules sockdev card punch (2540P or 3525)
+// and collects the cards it punches into decks. Hercules sends each card
+// either as an 80-byte EBCDIC card image ("ebcdic"), or translated to ASCII
+// as a line of text ("ascii"). There is no end-of-job marker in punch
+// output, so a deck ends when the punch has been idle for the deck timeout,
+// at a separator card, or when the connection is closed.
+//
+// With a local output, each deck is written as a PDF of the punched cards
+// and as a file of the raw card images, which Hercules can read back in
+// with a card reader defined with the "ebcdic" option. The online service
+// only prints listings, so with an online output we print the deck as one
+// line of text per card.
+
+// defaultDeckTimeout is how long the punch must be idle to end a deck, if
+// the input doesn't set deck_timeout_seconds.
+const defaultDeckTimeout = 2 * time.Second
+
+// jclJobCard matches the JOB statement of a JCL deck, to name the deck after
+// the job.
+var jclJobCard = regexp.MustCompile(`^//([A-Z@#$][A-Z0-9@#$]{0,7})\s+JOB\b`)
+
+func validatePunchConfig(name string, config InputConfig) []error {
+	var errs []error
+
+	if config.HerculesAddress == "" {
+		errs = append(errs,
+			fmt.Errorf("input [%s] must set 'hercules_address'", name))
+	}
+	switch punchEncoding(config) {
+	case readerASCII, readerEBCDIC:
+	default:
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'encoding' must be 'ascii' or 'ebcdic'",
+				name))
+	}
+	if _, err := regexp.Compile(config.DeckSeparator); err != nil {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'deck_separator' is invalid: %v", name,
+				err))
+	}
+	if _, err := punchCodepage(config); err != nil {
+		errs = append(errs, fmt.Errorf("input [%s]: %v", name, err))
+	}
+	if config.DeckTimeout < 0 {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'deck_timeout_seconds' must not be "+
+				"negative", name))
+	}
+
+	return errs
+}
+
+// punchEncoding returns the card encoding of a punch input, which is
+// "ebcdic" if it isn't set.
+func punchEncoding(config InputConfig) string {
+	if config.Encoding == "" {
+		return readerEBCDIC
+	}
+	return strings.ToLower(config.Encoding)
+}
+
+// punchCodepage returns the EBCDIC codepage of the cards punched by a punch
+// input: the guest part of its Hercules codepage, or 037 for Hercules'
+// default codepage.
+func punchCodepage(config InputConfig) (*scanner.Codepage, error) {
+	name := strings.ToLower(strings.TrimSpace(config.Codepage))
+	if _, guest, found := strings.Cut(name, "/"); found {
+		name = guest
+	}
+	if name == "default" {
+		name = ""
+	}
+	return scanner.LookupEBCDICCodepage(name)
+}
+
+// cardHandler receives the decks from a punch input.
+type cardHandler interface {
+	// AddCard adds a card to the deck. card is the EBCDIC card image, and
+	// text is its interpretation.
+	AddCard(card []byte, text string)
+
+	// EndOfDeck finishes the deck and sends it to the output.
+	EndOfDeck(job scanner.JobMetadata)
+}
+
+// newCardHandler sets up the card handler for an output configuration.
+func newCardHandler(output OutputConfig,
+	inputName string) (cardHandler, error) {
+
+	if output.Mode == "local" {
+		log.Printf("INFO:  [%s] Will create card deck PDFs in directory `%s`",
+			inputName, output.OutputDir)
+		return newPDFCardHandler(output, inputName)
+	}
+
+	log.Printf("INFO:  [%s] will use online print API at `%s`",
+		inputName, output.ServiceAddress)
+	return &listingCardHandler{
+		PrinterHandler: newOnlineOutputHandler(output, inputName),
+	}, nil
+}
+
+// pdfCardHandler writes each deck to a PDF of the cards and a file of card
+// images in the output directory.
+type pdfCardHandler struct {
+	deck      vprinter.CardDeck
+	images    bytes.Buffer
+	outputDir string
+	filenames *filenameTemplate
+	font      []byte
+	inputName string
+	stats     *outputStats
+}
+
+func newPDFCardHandler(output OutputConfig,
+	inputName string) (*pdfCardHandler, error) {
+
+	h := &pdfCardHandler{
+		outputDir: output.OutputDir,
+		filenames: output.filenames,
+		font:      output.font,
+		inputName: inputName,
+		stats:     output.stats,
+	}
+	var err error
+	h.deck, err = vprinter.NewCardDeck(h.font)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *pdfCardHandler) AddCard(card []byte, text string) {
+	h.deck.AddCard(card, text)
+	h.images.Write(card)
+}
+
+func (h *pdfCardHandler) EndOfDeck(job scanner.JobMetadata) {
+	// No matter what happens, we always want to reset our state to a fresh
+	// new deck.
+	defer func() {
+		var err error
+		h.deck, err = vprinter.NewCardDeck(h.font)
+		if err != nil {
+			log.Printf("ERROR: [%s] couldn't re-initialize card punch: %v",
+				h.inputName, err)
+		}
+		h.images.Reset()
+	}()
+
+	images, err := createOutputFile(h.outputDir, "cards")
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't create output file: %v",
+			h.inputName, err)
+		h.stats.endJob(0, err)
+		return
+	}
+	if _, err := images.Write(h.images.Bytes()); err != nil {
+		images.discard()
+		log.Printf("ERROR: [%s] couldn't write card images: %v",
+			h.inputName, err)
+		h.stats.endJob(0, err)
+		return
+	}
+
+	f, err := createOutputFile(h.outputDir, "pdf")
+	if err != nil {
+		images.discard()
+		log.Printf("ERROR: [%s] couldn't create output file: %v",
+			h.inputName, err)
+		h.stats.endJob(0, err)
+		return
+	}
+	n, err := h.deck.EndJob(f)
+	if err != nil {
+		images.discard()
+		f.discard()
+		log.Printf("ERROR: [%s] couldn't write PDF output: %v", h.inputName,
+			err)
+		h.stats.endJob(0, err)
+		return
+	}
+	names, err := commitOutputFiles(h.outputDir, h.filenames,
+		nameFields{job: job, input: h.inputName, pages: n,
+			time: time.Now().UTC()}, f, images)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't write PDF output: %v", h.inputName,
+			err)
+		h.stats.endJob(0, err)
+		return
+	}
+	filename := names[0]
+	h.stats.endJob(n, nil)
+
+	log.Printf("INFO:  [%s] wrote %d card deck (%d page PDF) to %s",
+		h.inputName, h.images.Len()/vprinter.CardColumns, n, filename)
+}
+
+// listingCardHandler prints each deck as a listing, one line per card.
+type listingCardHandler struct {
+	scanner.PrinterHandler
+}
+
+func (h *listingCardHandler) AddCard(card []byte, text string) {
+	h.AddLine(text, true)
+}
+
+func (h *listingCardHandler) EndOfDeck(job scanner.JobMetadata) {
+	h.EndOfJob(job)
+}
+
+// runPunch collects the decks from a Hercules card punch until ctx is done,
+// reconnecting when the connection is lost.
+func runPunch(ctx context.Context, input InputConfig, output OutputConfig,
+	stats *jobStats, inputName string) {
+
+	handler, err := newCardHandler(output, inputName)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		return
+	}
+	// The configuration has already been validated, so this won't fail.
+	ebcdic, _ := punchCodepage(input)
+
+	for {
+		handlePunch(ctx, input, ebcdic, handler, stats, inputName)
+		if ctx.Err() != nil {
+			return
+		}
+		stats.reconnecting()
+		log.Printf("INFO:  [%s] Re-trying Hercules connection in 10 seconds...",
+			inputName)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// punchedCard is a card read from the punch, or the error that ended the
+// connection.
+type punchedCard struct {
+	card []byte
+	text string
+	err  error
+}
+
+// handlePunch reads decks from one connection to a Hercules card punch.
+func handlePunch(ctx context.Context, input InputConfig,
+	ebcdic *scanner.Codepage, handler cardHandler, stats *jobStats,
+	inputName string) {
+
+	log.Printf("INFO:  [%s] Connecting to Hercules on %s...", inputName,
+		input.HerculesAddress)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", input.HerculesAddress)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: [%s] Couldn't connect: %v", inputName, err)
+		stats.setState(stateDisconnected)
+		stats.setError(err)
+		return
+	}
+	log.Printf("INFO:  [%s] Connection successful.", inputName)
+	stats.setState(stateConnected)
+	defer stats.setState(stateDisconnected)
+	conn = stats.countConn(conn)
+	defer conn.Close()
+
+	cards := make(chan punchedCard)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			card, text, err := readCard(r, input, ebcdic)
+			select {
+			case cards <- punchedCard{card, text, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := defaultDeckTimeout
+	if input.DeckTimeout > 0 {
+		timeout = time.Duration(input.DeckTimeout) * time.Second
+	}
+
+	var deck deckCollector
+	endDeck := func() {
+		if deck.cards == 0 {
+			return
+		}
+		handler.EndOfDeck(deck.job)
+		stats.endJob(deck.cards, 0)
+		deck = deckCollector{}
+	}
+	defer endDeck()
+
+	// idle only fires while a deck is in progress.
+	idle := time.NewTimer(timeout)
+	idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("INFO:  [%s] Disconnecting from Hercules.", inputName)
+			return
+		case <-idle.C:
+			endDeck()
+		case c := <-cards:
+			if c.err == io.EOF {
+				log.Printf("INFO:  [%s] Hercules disconnected.", inputName)
+				return
+			}
+			if c.err != nil {
+				log.Printf("ERROR: [%s] error reading from Hercules: %v",
+					inputName, c.err)
+				stats.setError(c.err)
+				return
+			}
+			if input.separator != nil && input.separator.MatchString(c.text) {
+				endDeck()
+				continue
+			}
+			if deck.cards == 0 {
+				deck.job.Start = time.Now()
+				stats.startJob()
+			}
+			deck.add(c.text)
+			handler.AddCard(c.card, c.text)
+			idle.Reset(timeout)
+		}
+	}
+}
+
+// deckCollector keeps track of the deck being punched.
+type deckCollector struct {
+	cards int
+	job   scanner.JobMetadata
+}
+
+// add counts a card, naming the deck after the first JOB statement in it.
+func (d *deckCollector) add(text string) {
+	d.cards++
+	d.job.End = time.Now()
+	if d.job.Name != "" {
+		return
+	}
+	if m := jclJobCard.FindStringSubmatch(text); m != nil {
+		d.job.Name = m[1]
+	}
+}
+
+// readCard reads the next card from a punch, returning the EBCDIC card
+// image in the ebcdic codepage and its interpretation.
+func readCard(r *bufio.Reader, input InputConfig,
+	ebcdic *scanner.Codepage) ([]byte, string, error) {
+
+	card := make([]byte, vprinter.CardColumns)
+
+	if punchEncoding(input) == readerEBCDIC {
+		if _, err := io.ReadFull(r, card); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errors.New("connection closed in the middle of a card")
+			}
+			return nil, "", err
+		}
+		return card, ebcdic.Decode(card, scanner.UnmappableReplace), nil
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	// Hercules translated the card from EBCDIC with the input's codepage,
+	// one byte per column, and dropped the trailing blanks.
+	var text strings.Builder
+	for i := range card {
+		card[i] = 0x40
+		if i >= len(line) {
+			continue
+		}
+		r, ok := input.codepage.Rune(line[i])
+		if !ok {
+			r = '?'
+		}
+		if b, ok := ebcdic.Byte(r); ok {
+			card[i] = b
+		}
+		text.WriteRune(r)
+	}
+	return card, text.String(), nil
+}
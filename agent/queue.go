@@ -0,0 +1,233 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// The print server inputs (LPD and IPP) have named queues, each of which
+// prints to an output in a format, optionally with a different profile than
+// the output's own. Clients may send jobs at the same time, but each queue
+// only prints one job at a time.
+
+func validateQueues(name string, config InputConfig,
+	outputs map[string]OutputConfig) []error {
+
+	var errs []error
+
+	seen := make(map[string]bool)
+	for _, q := range config.Queues {
+		if q.Name == "" {
+			errs = append(errs,
+				fmt.Errorf("input [%s] all queues require a value in the "+
+					"\"name\" field", name))
+		}
+		if seen[q.Name] {
+			errs = append(errs,
+				fmt.Errorf("input [%s] has more than one queue named `%s`",
+					name, q.Name))
+		}
+		seen[q.Name] = true
+		if _, ok := outputs[q.Output]; q.Output != "" && !ok {
+			errs = append(errs,
+				fmt.Errorf("input [%s] queue `%s` refers to output `%s`, "+
+					"which doesn't exist", name, q.Name, q.Output))
+		}
+		if err := validateFormat(q.Format); err != nil {
+			errs = append(errs,
+				fmt.Errorf("input [%s] queue `%s`: %v", name, q.Name, err))
+		}
+	}
+
+	return errs
+}
+
+// queueOutputs returns the output configuration for each of an input's
+// queues. Queues that don't set an output use the input's output.
+func queueOutputs(input InputConfig,
+	outputs map[string]OutputConfig) map[string]OutputConfig {
+
+	m := make(map[string]OutputConfig)
+	for _, q := range input.Queues {
+		o := outputs[input.Output]
+		if q.Output != "" {
+			o = outputs[q.Output]
+		}
+		if q.Profile != "" {
+			o.Profile = q.Profile
+		}
+		m[q.Name] = o
+	}
+	return m
+}
+
+// printQueue is the printer behind a queue.
+type printQueue struct {
+	name    string
+	format  string
+	output  OutputConfig
+	handler scanner.PrinterHandler
+	stats   *jobStats
+
+	// results is the output handler under handler, which tells us if jobs
+	// were printed successfully, or nil if it can't.
+	results jobErrorReporter
+
+	mu sync.Mutex
+}
+
+// printQueues are the queues of a print server input.
+type printQueues struct {
+	queues map[string]*printQueue
+
+	// anyQueue, if not nil, accepts jobs for every queue name. We use it
+	// when the input doesn't configure any queues.
+	anyQueue *printQueue
+}
+
+// newPrintQueues sets up the queues of an input printing to output.
+func newPrintQueues(input InputConfig, output OutputConfig,
+	stats *jobStats, inputName string) (*printQueues, error) {
+
+	newQueue := func(name, format string,
+		output OutputConfig) (*printQueue, error) {
+
+		handler, err := newInputHandler(input, output, inputName+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		// Most programs printing to a network printer send plain text, so
+		// that is the default format for queues.
+		if format == "" {
+			format = input.Format
+		}
+		if format == "" {
+			format = formatText
+		}
+		// Not every handler can tell us if a job failed; jobs printed by
+		// one that can't are assumed to succeed.
+		results, _ := handler.(jobErrorReporter)
+		return &printQueue{
+			name:    name,
+			format:  strings.ToLower(format),
+			output:  output,
+			handler: newCountingHandler(handler, stats),
+			stats:   stats,
+			results: results,
+		}, nil
+	}
+
+	p := &printQueues{queues: make(map[string]*printQueue)}
+	if len(input.Queues) == 0 {
+		q, err := newQueue("*", "", output)
+		if err != nil {
+			return nil, err
+		}
+		p.anyQueue = q
+	}
+	for _, qc := range input.Queues {
+		q, err := newQueue(qc.Name, qc.Format, input.queueOutputs[qc.Name])
+		if err != nil {
+			return nil, err
+		}
+		p.queues[qc.Name] = q
+	}
+	return p, nil
+}
+
+// queue returns the queue with the given name, or nil if there isn't one.
+func (p *printQueues) queue(name string) *printQueue {
+	if p.anyQueue != nil {
+		return p.anyQueue
+	}
+	return p.queues[name]
+}
+
+// print prints everything in r to the queue, in format, using meta for
+// whatever the scanner can't find out about the job. Returns an error if the
+// data couldn't be read or any job in it couldn't be written or delivered
+// by the output.
+func (q *printQueue) print(ctx context.Context, r io.Reader, format string,
+	meta scanner.JobMetadata, input InputConfig, tag string) error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	handler := &jobResultHandler{
+		PrinterHandler: &jobMetadataHandler{PrinterHandler: q.handler,
+			job: meta},
+		results: q.results,
+	}
+	if err := scanFormat(ctx, q.stats.countReader(r), format, meta.Name,
+		input, q.output, handler, tag); err != nil {
+		q.stats.setError(err)
+		return err
+	}
+	if handler.err != nil {
+		q.stats.setError(handler.err)
+	}
+	return handler.err
+}
+
+// jobMetadataHandler fills in the job metadata the scanner didn't find with
+// what we know about the job from elsewhere.
+type jobMetadataHandler struct {
+	scanner.PrinterHandler
+	job scanner.JobMetadata
+}
+
+func (h *jobMetadataHandler) EndOfJob(job scanner.JobMetadata) {
+	for _, f := range []struct{ dst, src *string }{
+		{&job.Number, &h.job.Number},
+		{&job.Name, &h.job.Name},
+		{&job.Type, &h.job.Type},
+		{&job.Programmer, &h.job.Programmer},
+		{&job.Room, &h.job.Room},
+		{&job.Class, &h.job.Class},
+	} {
+		if *f.dst == "" {
+			*f.dst = *f.src
+		}
+	}
+	h.PrinterHandler.EndOfJob(job)
+}
+
+// jobResultHandler keeps the first error from the jobs it ends.
+type jobResultHandler struct {
+	scanner.PrinterHandler
+	results jobErrorReporter
+	err     error
+}
+
+func (h *jobResultHandler) EndOfJob(job scanner.JobMetadata) {
+	h.PrinterHandler.EndOfJob(job)
+	if h.results == nil {
+		return
+	}
+	if err := h.results.lastJobError(); err != nil && h.err == nil {
+		h.err = err
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A raw input is a "raw TCP/IP" (JetDirect-style, usually port 9100) print
+// server. Everything a client sends before it closes the connection is one
+// job. The input's end-of-job detector may also split the data from one
+// connection into several jobs at separator pages: always with the
+// "hercules" format, and with the text formats if eoj_detector is set. Unlike
+// a Hercules connection, pauses in the data never end a job.
+
+// runRawInput prints the jobs sent to a raw input until ctx is done.
+func runRawInput(ctx context.Context, input InputConfig, output OutputConfig,
+	stats *jobStats, inputName string) {
+
+	runListener(ctx, input, inputName, stats,
+		func(conn net.Conn, tag string) {
+			handleRawClient(ctx, input, output, stats, conn, tag)
+		})
+}
+
+// handleRawClient prints what a client sends on one raw connection.
+func handleRawClient(ctx context.Context, input InputConfig,
+	output OutputConfig, stats *jobStats, conn net.Conn, tag string) {
+
+	conn = stats.countConn(conn)
+	if input.CaptureDir != "" {
+		conn = startCapture(conn, input.CaptureDir, tag)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("ERROR: [%s] %v", tag, err)
+		}
+	}()
+
+	// If we're shut down, stop reading and print what we have so far.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	handler, err := newInputHandler(input, output, tag)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", tag, err)
+		return
+	}
+	handler = newCountingHandler(handler, stats)
+
+	// Like LPD, plain text is the most likely thing to be sent to a raw
+	// printer port, so that is the default format.
+	format := input.Format
+	if format == "" {
+		format = formatText
+	}
+	if input.EOJDetector != "" &&
+		!strings.EqualFold(format, formatHercules) {
+		handler = &jobSplitter{PrinterHandler: handler, eoj: input.eoj,
+			jobname: fileJobName(tag)}
+	}
+	err = scanFormat(ctx, conn, format, fileJobName(tag), input, output,
+		handler, tag)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("INFO:  [%s] Closing connection.", tag)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: [%s] error reading from client: %s", tag, err)
+		stats.setError(err)
+		return
+	}
+	log.Printf("INFO:  [%s] Client disconnected.", tag)
+}
+
+// jobSplitter splits the job a text scanner prints into several jobs at the
+// separator pages an EOJDetector recognizes, much as the Hercules scanner
+// does: a page whose last non-blank line is the end of a job ends the job,
+// and a page whose first non-blank line is the start of a job starts a new
+// one. (The text scanners send a blank line before each page break that
+// follows a newline, so the last line of a page is usually blank.)
+type jobSplitter struct {
+	scanner.PrinterHandler
+	eoj     scanner.EOJDetector
+	jobname string
+
+	// job is what we learned from the start of the current job, started is
+	// when it started, and lines counts what it has printed.
+	job     scanner.JobMetadata
+	started time.Time
+	lines   int
+
+	// lastLine is the last non-blank line printed on the current page.
+	lastLine string
+
+	// After a page break, we hold on to it and any blank lines until we
+	// see whether the page's first line starts a new job.
+	pageTop   bool
+	heldPage  bool
+	heldLines []bool
+}
+
+func (s *jobSplitter) AddLine(line string, linefeed bool) {
+	if s.pageTop {
+		if strings.TrimSpace(line) == "" {
+			s.heldLines = append(s.heldLines, linefeed)
+			return
+		}
+		s.pageTop = false
+		if job, ok := s.eoj.StartOfJob(line); ok {
+			if s.lines > 0 {
+				// The held page break belongs to the job we are ending,
+				// and is dropped.
+				s.heldPage = false
+				s.endJob(scanner.JobMetadata{})
+			}
+			s.job = job
+		}
+	}
+	s.flushHeld()
+	s.print()
+	s.PrinterHandler.AddLine(line, linefeed)
+	if strings.TrimSpace(line) != "" {
+		s.lastLine = line
+	}
+}
+
+func (s *jobSplitter) PageBreak() {
+	s.flushHeld()
+	if job, ok := s.eoj.EndOfJob(s.lastLine); ok && s.lines > 0 {
+		s.endJob(job)
+		return
+	}
+	s.lastLine = ""
+	s.heldPage = true
+	s.pageTop = true
+}
+
+func (s *jobSplitter) SkipToChannel(channel int) {
+	s.flushHeld()
+	s.print()
+	s.PrinterHandler.SkipToChannel(channel)
+}
+
+// EndOfJob ends the scanner's job, which ends whatever job we're in.
+func (s *jobSplitter) EndOfJob(job scanner.JobMetadata) {
+	if s.lines == 0 {
+		// The data ended with a separator page that already ended the
+		// job.
+		return
+	}
+	s.endJob(scanner.JobMetadata{})
+}
+
+// print notes that the current job has printed something.
+func (s *jobSplitter) print() {
+	if s.lines == 0 {
+		s.started = time.Now()
+	}
+	s.lines++
+}
+
+// flushHeld sends the page break and blank lines we were holding on to while
+// looking for the start of a new job to the handler. A job doesn't start
+// with a page break.
+func (s *jobSplitter) flushHeld() {
+	if s.heldPage && s.lines > 0 {
+		s.PrinterHandler.PageBreak()
+	}
+	s.heldPage = false
+	for _, linefeed := range s.heldLines {
+		s.print()
+		s.PrinterHandler.AddLine("", linefeed)
+	}
+	s.heldLines = s.heldLines[:0]
+	s.pageTop = false
+}
+
+// endJob ends the current job, described by what we learned about it from
+// the end of the job, or failing that, from its start.
+func (s *jobSplitter) endJob(job scanner.JobMetadata) {
+	if job == (scanner.JobMetadata{}) {
+		job = s.job
+	}
+	if job.Name == "" && job.Number == "" {
+		job.Name = s.jobname
+	}
+	if job.Start.IsZero() {
+		job.Start = s.started
+	}
+	if job.End.IsZero() {
+		job.End = time.Now()
+	}
+	s.PrinterHandler.EndOfJob(job)
+
+	s.job = scanner.JobMetadata{}
+	s.lines = 0
+	s.lastLine = ""
+	s.heldPage = false
+	s.heldLines = s.heldLines[:0]
+	s.pageTop = true
+}
@@ -0,0 +1,294 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A reader is a Hercules sockdev card reader (2540R or 3505). Hercules
+// listens on the reader's socket, and reads everything a client sends until
+// the client closes the connection as one deck. We send each line of a text
+// file as one card, so JCL written in any editor can be submitted. Define
+// the reader in Hercules with the "eof" option, so that the end of the deck
+// is an end-of-file condition for the guest rather than intervention
+// required, e.g.:
+//
+//	000C 3505 3505 sockdev ascii trunc eof
+
+// Card reader deck encodings. With "ascii", Hercules translates the cards to
+// EBCDIC, and the reader must be defined with the "ascii" option. With
+// "ebcdic", we translate the cards ourselves and send 80-byte card images,
+// and the reader must be defined with the "ebcdic" option.
+const (
+	readerASCII  = "ascii"
+	readerEBCDIC = "ebcdic"
+)
+
+// cardColumns is the number of columns on a card.
+const cardColumns = 80
+
+// submitPattern matches the files we submit from a reader's
+// submit_directory.
+const submitPattern = "*.[jJ][cC][lL]"
+
+// readerEncoding returns the deck encoding of a reader configuration, which
+// is "ascii" if it isn't set.
+func readerEncoding(config ReaderConfig) string {
+	if config.Encoding == "" {
+		return readerASCII
+	}
+	return strings.ToLower(config.Encoding)
+}
+
+func validateReaders(readers map[string]ReaderConfig) []error {
+	var errs []error
+
+	addresses := make(map[string]string)
+	for name, config := range readers {
+		if config.HerculesAddress == "" {
+			errs = append(errs,
+				fmt.Errorf("reader [%s] must set 'hercules_address'", name))
+		} else if other, ok := addresses[config.HerculesAddress]; ok {
+			errs = append(errs,
+				fmt.Errorf("readers [%s] and [%s] have the same "+
+					"'hercules_address'", other, name))
+		} else {
+			addresses[config.HerculesAddress] = name
+		}
+
+		switch readerEncoding(config) {
+		case readerASCII, readerEBCDIC:
+		default:
+			errs = append(errs,
+				fmt.Errorf("reader [%s] 'encoding' must be 'ascii' or "+
+					"'ebcdic'", name))
+		}
+		if _, err := scanner.LookupEBCDICCodepage(
+			config.Codepage); err != nil {
+			errs = append(errs, fmt.Errorf("reader [%s]: %v", name, err))
+		}
+
+		if config.SubmitArchiveDir != "" && config.SubmitDir == "" {
+			errs = append(errs,
+				fmt.Errorf("reader [%s] 'submit_archive_directory' requires "+
+					"'submit_directory'", name))
+		}
+		if config.SubmitArchiveDir != "" && config.SubmitDir != "" &&
+			filepath.Clean(config.SubmitArchiveDir) ==
+				filepath.Clean(config.SubmitDir) {
+			errs = append(errs,
+				fmt.Errorf("reader [%s] 'submit_archive_directory' must be "+
+					"different from 'submit_directory'", name))
+		}
+	}
+
+	return errs
+}
+
+// buildDeck turns each line of the text in r into a card for the reader,
+// returning the deck and the number of cards in it. Lines shorter than a card
+// are padded with blanks; lines longer than a card are an error, since
+// cutting them off would quietly change the job.
+func buildDeck(r io.Reader, reader ReaderConfig) ([]byte, int, error) {
+	var deck bytes.Buffer
+	encoding := readerEncoding(reader)
+
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := []rune(strings.TrimSuffix(s.Text(), "\r"))
+		if len(text) > cardColumns {
+			return nil, 0, fmt.Errorf("line %d is longer than %d columns",
+				line, cardColumns)
+		}
+
+		for _, c := range text {
+			if encoding == readerASCII {
+				if c < ' ' || c > '~' {
+					return nil, 0, fmt.Errorf("line %d has the character "+
+						"%q, which an ascii reader can't read", line, c)
+				}
+				deck.WriteRune(c)
+				continue
+			}
+			b, ok := reader.codepage.Byte(c)
+			if !ok {
+				return nil, 0, fmt.Errorf("line %d has the character %q, "+
+					"which isn't in EBCDIC codepage %s", line, c,
+					reader.codepage.Name())
+			}
+			deck.WriteByte(b)
+		}
+
+		if encoding == readerASCII {
+			deck.WriteString(strings.Repeat(" ", cardColumns-len(text)))
+			deck.WriteByte('\n')
+		} else {
+			deck.Write(bytes.Repeat([]byte{0x40}, cardColumns-len(text)))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	if line == 0 {
+		return nil, 0, errors.New("there are no cards in the deck")
+	}
+	return deck.Bytes(), line, nil
+}
+
+// submitDeck sends a deck to the reader. Closing the connection when we're
+// done tells Hercules that it has the whole deck, so once we've connected we
+// always send the whole deck, even if ctx is done: stopping partway would
+// hand the guest a job with cards missing.
+func submitDeck(ctx context.Context, reader ReaderConfig, readerName string,
+	deck []byte) error {
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", reader.HerculesAddress)
+	if err != nil {
+		return err
+	}
+
+	// Hercules only takes the cards as the guest reads them, so the
+	// write can wait for a long time if the reader isn't started.
+	stop := context.AfterFunc(ctx, func() {
+		log.Printf("INFO:  [%s] waiting for Hercules to read the rest of "+
+			"the deck", readerName)
+	})
+	defer stop()
+
+	if _, err := conn.Write(deck); err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.Close()
+}
+
+// submitFile submits the text file filename, or stdin if filename is "-",
+// to a reader.
+func submitFile(ctx context.Context, reader ReaderConfig, readerName,
+	filename string) error {
+
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	deck, cards, err := buildDeck(r, reader)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO:  [%s] submitting %d card(s) from `%s` to %s",
+		readerName, cards, filename, reader.HerculesAddress)
+	return submitDeck(ctx, reader, readerName, deck)
+}
+
+// readerRetryTime is how long we wait before trying again when we can't
+// connect to a reader.
+const readerRetryTime = 10 * time.Second
+
+// runReader submits the JCL files that appear in the reader's
+// submit_directory until ctx is done.
+func runReader(ctx context.Context, reader ReaderConfig, readerName string,
+	wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	log.Printf("INFO:  [%s] Watching submit directory `%s`", readerName,
+		reader.SubmitDir)
+
+	files := make(map[string]*spoolFile)
+	for {
+		wait := spoolPollInterval
+		ready, err := readySpoolFiles(reader.SubmitDir, submitPattern, files,
+			defaultSpoolQuietTime)
+		if err != nil {
+			log.Printf("ERROR: [%s] %v", readerName, err)
+		}
+		for _, name := range ready {
+			if ctx.Err() != nil {
+				break
+			}
+			err := submitDirectoryFile(ctx, reader, readerName, name)
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				// Hercules isn't running, or the reader isn't defined yet.
+				// The deck is fine, so we'll try it again later.
+				log.Printf("ERROR: [%s] Couldn't connect: %v", readerName,
+					err)
+				wait = readerRetryTime
+				break
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Printf("ERROR: [%s] submitting `%s`: %v", readerName,
+					name, err)
+				files[name].failed = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// submitDirectoryFile submits the file name in the reader's submit
+// directory, then archives or deletes it.
+func submitDirectoryFile(ctx context.Context, reader ReaderConfig,
+	readerName, name string) error {
+
+	path := filepath.Join(reader.SubmitDir, name)
+	if err := submitFile(ctx, reader, readerName, path); err != nil {
+		return err
+	}
+
+	var err error
+	if reader.SubmitArchiveDir == "" {
+		err = os.Remove(path)
+	} else {
+		err = os.Rename(path, archivePath(reader.SubmitArchiveDir, name))
+	}
+	if err != nil {
+		return fmt.Errorf("cleaning up: %v", err)
+	}
+	return nil
+}
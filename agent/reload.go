@@ -0,0 +1,447 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The supervisor runs the inputs, online spool workers, and status server,
+// and reloads the configuration file when we receive SIGHUP (or, with
+// -watchconfig, when the file changes). A configuration that doesn't
+// validate is reported and ignored. Otherwise, inputs that were removed or
+// whose configuration changed -- including any of the outputs they print
+// to -- are stopped once they have finished the job they're printing. Then
+// the changed inputs are started again with their new configuration, and
+// inputs that are new are started. Inputs and outputs that didn't change
+// keep running undisturbed.
+//
+// Readers are only started when the agent starts, so changes to them take
+// effect the next time the agent is restarted.
+
+// drainQuietTime is how long an input we're stopping must go without
+// receiving any data, with no job in progress, before we stop it.
+const drainQuietTime = time.Second
+
+// configPollInterval is how often -watchconfig checks the configuration
+// file for changes.
+const configPollInterval = 2 * time.Second
+
+// task is a goroutine started by the supervisor, which it can stop.
+type task struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop cancels the task and waits for it to finish.
+func (t *task) stop() {
+	t.cancel()
+	<-t.done
+}
+
+type supervisor struct {
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	started time.Time
+
+	// mu protects everything below. inputs and outputs are the running
+	// configuration, prepared for use, and the file* fields are the same
+	// configuration as it was read from the configuration file, to compare
+	// with the file when it is reloaded.
+	mu          sync.Mutex
+	inputs      map[string]InputConfig
+	outputs     map[string]OutputConfig
+	status      StatusConfig
+	fileInputs  map[string]InputConfig
+	fileOutputs map[string]OutputConfig
+	fileReaders map[string]ReaderConfig
+
+	// stats has every input we've run, including ones that have since been
+	// removed, for the summary when we shut down.
+	stats map[string]*jobStats
+
+	inputTasks     map[string]*task
+	spoolTasks     map[string]*task
+	retentionTasks map[string]*task
+	statusTask     *task
+}
+
+func newSupervisor(ctx context.Context, wg *sync.WaitGroup,
+	inputs, fileInputs map[string]InputConfig,
+	outputs, fileOutputs map[string]OutputConfig,
+	fileReaders map[string]ReaderConfig, status StatusConfig) *supervisor {
+
+	return &supervisor{
+		ctx:            ctx,
+		wg:             wg,
+		started:        time.Now(),
+		inputs:         inputs,
+		outputs:        outputs,
+		status:         status,
+		fileInputs:     fileInputs,
+		fileOutputs:    fileOutputs,
+		fileReaders:    fileReaders,
+		stats:          make(map[string]*jobStats),
+		inputTasks:     make(map[string]*task),
+		spoolTasks:     make(map[string]*task),
+		retentionTasks: make(map[string]*task),
+	}
+}
+
+// startTask runs run in a new goroutine, with a context that the task's
+// stop method cancels.
+func (s *supervisor) startTask(run func(ctx context.Context,
+	wg *sync.WaitGroup)) *task {
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	t := &task{cancel: cancel, done: make(chan struct{})}
+	s.wg.Add(1)
+	go func() {
+		defer close(t.done)
+		run(ctx, s.wg)
+	}()
+	return t
+}
+
+// start starts everything in the running configuration. We'll wait 250ms
+// between input startups so the initial log messages from each don't
+// intermingle.
+func (s *supervisor) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.inputs {
+		s.startInput(name)
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	for name := range s.outputs {
+		s.startSpool(name)
+		s.startRetention(name)
+	}
+	s.startStatus()
+}
+
+// startInput starts the input name from the running configuration. The
+// caller must hold s.mu.
+func (s *supervisor) startInput(name string) {
+	input := s.inputs[name]
+	stats := s.stats[name]
+	if stats == nil {
+		stats = &jobStats{}
+		s.stats[name] = stats
+	}
+	stats.setState(stateStarting)
+
+	// the output for the input is guaranteed to exist because of the
+	// earlier config validation.
+	output := s.outputs[input.Output]
+	s.inputTasks[name] = s.startTask(func(ctx context.Context,
+		wg *sync.WaitGroup) {
+		runPrinter(ctx, name, input.Output, input, output, stats, wg)
+	})
+}
+
+// startSpool starts the online spool worker for the output name, if it has
+// a spool. The caller must hold s.mu.
+func (s *supervisor) startSpool(name string) {
+	output := s.outputs[name]
+	if output.spool == nil {
+		return
+	}
+	s.spoolTasks[name] = s.startTask(func(ctx context.Context,
+		wg *sync.WaitGroup) {
+		runOnlineSpool(ctx, output, name, wg)
+	})
+}
+
+// startRetention starts the retention cleanup for the output name, if it
+// has a retention policy. The caller must hold s.mu.
+func (s *supervisor) startRetention(name string) {
+	output := s.outputs[name]
+	if !hasRetention(output) {
+		return
+	}
+	s.retentionTasks[name] = s.startTask(func(ctx context.Context,
+		wg *sync.WaitGroup) {
+		runRetention(ctx, output, name, wg)
+	})
+}
+
+// startStatus starts the status server, if it is configured. The caller
+// must hold s.mu.
+func (s *supervisor) startStatus() {
+	if s.status.ListenAddress == "" {
+		return
+	}
+	status := s.status
+	status.allowed, _ = parseAllowedClients(status.AllowedClients)
+	s.statusTask = s.startTask(func(ctx context.Context,
+		wg *sync.WaitGroup) {
+		runStatusServer(ctx, status, s, wg)
+	})
+}
+
+// watchReload reloads the configuration file path when we receive SIGHUP,
+// or if watch is true, when the file changes, until ctx is done.
+func (s *supervisor) watchReload(ctx context.Context, path string,
+	watch bool) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	var lastMod time.Time
+	var lastSize int64
+	if watch {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+		if info, err := os.Stat(path); err == nil {
+			lastMod, lastSize = info.ModTime(), info.Size()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("INFO:  received SIGHUP; reloading `%s`", path)
+		case <-poll:
+			info, err := os.Stat(path)
+			if err != nil || (info.ModTime().Equal(lastMod) &&
+				info.Size() == lastSize) {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+			log.Printf("INFO:  `%s` changed; reloading", path)
+		}
+		s.reload(path)
+	}
+}
+
+// reload loads the configuration file path, and if it is valid, makes it
+// the running configuration. It returns once the inputs that changed have
+// been restarted.
+func (s *supervisor) reload(path string) {
+	inputs, outputs, readers, status, err := loadConfig(path)
+	if err != nil {
+		log.Printf("ERROR: Unable to read config `%s`: %v", path, err)
+		log.Printf("ERROR: configuration not reloaded")
+		return
+	}
+	errs := validateConfig(inputs, outputs)
+	errs = append(errs, validateReaders(readers)...)
+	errs = append(errs, validateStatusConfig(status)...)
+	if errs != nil {
+		for _, err := range errs {
+			log.Printf("ERROR: %s", err.Error())
+		}
+		log.Printf("ERROR: invalid configuration; configuration not " +
+			"reloaded")
+		return
+	}
+
+	fileInputs, fileOutputs := maps.Clone(inputs), maps.Clone(outputs)
+	if err := setupOutputs(outputs); err != nil {
+		log.Printf("ERROR: %v", err)
+		log.Printf("ERROR: configuration not reloaded")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Outputs keep their counters, and share their online spool with the
+	// handlers of inputs that are still finishing a job, so a job is never
+	// sent by two spool workers.
+	for name, o := range outputs {
+		old, ok := s.outputs[name]
+		if !ok {
+			continue
+		}
+		o.stats = old.stats
+		if o.spool != nil && old.spool != nil && o.spool.dir == old.spool.dir {
+			old.spool.setLimits(o.spool.limits())
+			o.spool = old.spool
+		}
+		outputs[name] = o
+	}
+	if err := setupInputs(inputs, outputs); err != nil {
+		log.Printf("ERROR: %v", err)
+		log.Printf("ERROR: configuration not reloaded")
+		return
+	}
+
+	var added, changed, removed []string
+	for name := range s.fileInputs {
+		if _, ok := fileInputs[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for name, input := range fileInputs {
+		if _, ok := s.fileInputs[name]; !ok {
+			added = append(added, name)
+			continue
+		}
+		if !reflect.DeepEqual(input, s.fileInputs[name]) {
+			changed = append(changed, name)
+			continue
+		}
+		for _, o := range inputOutputs(input) {
+			if !reflect.DeepEqual(fileOutputs[o], s.fileOutputs[o]) {
+				changed = append(changed, name)
+				break
+			}
+		}
+	}
+	var changedOutputs []string
+	for name, o := range fileOutputs {
+		if old, ok := s.fileOutputs[name]; !ok ||
+			!reflect.DeepEqual(o, old) {
+			changedOutputs = append(changedOutputs, name)
+		}
+	}
+	for name := range s.fileOutputs {
+		if _, ok := fileOutputs[name]; !ok {
+			changedOutputs = append(changedOutputs, name)
+		}
+	}
+	log.Printf("INFO:  reloading configuration: %d input(s) added, %d "+
+		"changed, %d removed", len(added), len(changed), len(removed))
+	if !reflect.DeepEqual(readers, s.fileReaders) {
+		log.Printf("WARN:  changes to readers take effect when the agent " +
+			"is restarted")
+	}
+
+	oldStatus := s.status
+	s.inputs, s.outputs, s.status = inputs, outputs, status
+	s.fileInputs, s.fileOutputs = fileInputs, fileOutputs
+
+	for _, name := range changedOutputs {
+		if t := s.spoolTasks[name]; t != nil {
+			t.stop()
+			delete(s.spoolTasks, name)
+		}
+		if t := s.retentionTasks[name]; t != nil {
+			t.stop()
+			delete(s.retentionTasks, name)
+		}
+		if _, ok := outputs[name]; ok {
+			s.startSpool(name)
+			s.startRetention(name)
+		}
+	}
+
+	if !reflect.DeepEqual(status, oldStatus) {
+		if s.statusTask != nil {
+			s.statusTask.stop()
+			s.statusTask = nil
+		}
+		s.startStatus()
+	}
+
+	// Draining inputs can take a while, so we let go of the lock while we
+	// wait. Reloads still happen one at a time, since we don't return until
+	// the inputs have been restarted. We only start inputs once every input
+	// we're stopping is stopped, since an input may take over another's
+	// listen_address or Hercules connection.
+	var drains sync.WaitGroup
+	for _, name := range append(changed, removed...) {
+		t, stats := s.inputTasks[name], s.stats[name]
+		delete(s.inputTasks, name)
+		drains.Add(1)
+		go func() {
+			defer drains.Done()
+			s.drainInput(name, t, stats)
+		}()
+	}
+	s.mu.Unlock()
+	drains.Wait()
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		return
+	}
+	for _, name := range append(changed, added...) {
+		s.startInput(name)
+	}
+	log.Printf("INFO:  configuration reloaded")
+}
+
+// drainInput stops an input once it has finished the job it is printing:
+// when it has no job in progress and hasn't received anything for a moment.
+func (s *supervisor) drainInput(name string, t *task, stats *jobStats) {
+	log.Printf("INFO:  [%s] stopping input after its current job", name)
+	for s.ctx.Err() == nil && !stats.idle(drainQuietTime) {
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	t.stop()
+}
+
+// inputOutputs returns the names of the outputs an input prints to.
+func inputOutputs(input InputConfig) []string {
+	names := []string{input.Output}
+	for _, q := range input.Queues {
+		if q.Output != "" {
+			names = append(names, q.Output)
+		}
+	}
+	for _, r := range input.Routes {
+		names = append(names, r.Outputs...)
+	}
+	return names
+}
+
+// snapshot returns the running inputs and outputs, with the stats of each
+// input, for the status server.
+func (s *supervisor) snapshot() (map[string]InputConfig,
+	map[string]OutputConfig, map[string]*jobStats) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]*jobStats)
+	for name := range s.inputs {
+		if st := s.stats[name]; st != nil {
+			stats[name] = st
+		}
+	}
+	return s.inputs, s.outputs, stats
+}
+
+// allStats returns the stats of every input we've run.
+func (s *supervisor) allStats() map[string]*jobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.stats)
+}
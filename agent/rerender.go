@@ -0,0 +1,244 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// -rerender prints a job again from its print job stream: the directives an
+// online output sends to the print API, which a local output saves as a
+// .job.zst file with archive_directives. The job can go to any output, so it
+// can be rendered with a different profile, or sent to the online service.
+
+// zstdMagic starts every zstd-compressed stream.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// jobStreamExts are the file name extensions of the print job streams that
+// -rerender prints from a directory.
+var jobStreamExts = []string{".job.zst", ".job"}
+
+// runRerender prints the print job stream in path, or if path is a
+// directory, each print job stream in it, to output.
+func runRerender(ctx context.Context, output OutputConfig, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = jobStreamFiles(path)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("INFO:  [rerender] found %d job(s) in `%s`", len(files),
+			path)
+	}
+
+	handler, err := newOutputHandler(output, "rerender")
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	handler = scanner.NewLineWidthHandler(handler, output.model.Columns,
+		output.overflow)
+
+	printed := 0
+	for _, name := range files {
+		if ctx.Err() != nil {
+			log.Printf("INFO:  [rerender] stopped before printing all jobs")
+			break
+		}
+		if err := rerenderFile(name, handler); err != nil {
+			log.Printf("ERROR: [rerender] `%s`: %v", name, err)
+			continue
+		}
+		printed++
+	}
+	log.Printf("INFO:  [rerender] printed %d of %d job(s)", printed,
+		len(files))
+}
+
+// jobStreamFiles returns the print job streams in dir and its
+// subdirectories, which filename templates can put archive files in, in
+// order by path.
+func jobStreamFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry,
+		err error) error {
+
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for _, ext := range jobStreamExts {
+			if strings.HasSuffix(d.Name(), ext) {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// rerenderFile prints the print job stream in the file name to handler.
+func rerenderFile(name string, handler scanner.PrinterHandler) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, zstdMagic) {
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		r = dec
+	}
+
+	// We read the whole job before printing any of it, so that a damaged
+	// file doesn't leave part of a job in the printer.
+	ops, job, err := parseJobStream(r)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		switch op.kind {
+		case opLine:
+			handler.AddLine(op.line, op.linefeed)
+		case opPageBreak:
+			handler.PageBreak()
+		case opSkip:
+			handler.SkipToChannel(op.channel)
+		}
+	}
+	handler.EndOfJob(job)
+	if r, ok := handler.(jobErrorReporter); ok {
+		return r.lastJobError()
+	}
+	return nil
+}
+
+// parseJobStream reads the directives of an uncompressed print job stream,
+// returning the printer operations and the job's metadata.
+func parseJobStream(r io.Reader) ([]printerOp, scanner.JobMetadata, error) {
+	var ops []printerOp
+	var job scanner.JobMetadata
+	var jobInfo string
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := s.Text()
+		if len(line) < 2 || line[1] != ':' {
+			return nil, job, fmt.Errorf("line %d: invalid directive",
+				lineNum)
+		}
+		directive, value := line[:2], line[2:]
+		switch directive {
+		case "L:", "O:":
+			ops = append(ops, printerOp{kind: opLine, line: value,
+				linefeed: directive == "L:"})
+		case "P:":
+			ops = append(ops, printerOp{kind: opPageBreak})
+		case "C:":
+			channel, err := strconv.Atoi(value)
+			if err != nil || channel < 1 || channel > 12 {
+				return nil, job, fmt.Errorf("line %d: invalid channel `%s`",
+					lineNum, value)
+			}
+			ops = append(ops, printerOp{kind: opSkip, channel: channel})
+		case "J:":
+			jobInfo = value
+		case "M:":
+			if err := setJobMetadata(&job, value); err != nil {
+				return nil, job, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+		default:
+			return nil, job, fmt.Errorf("line %d: unknown directive `%s`",
+				lineNum, directive)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, job, err
+	}
+
+	// Streams from before the M: directives only have the job info, which
+	// will have to do as the job's name.
+	if job == (scanner.JobMetadata{}) {
+		job.Name = jobInfo
+	}
+	return ops, job, nil
+}
+
+// setJobMetadata applies the key=value parameter of an M: directive to job.
+// Like the print API, we ignore metadata we don't know about.
+func setJobMetadata(job *scanner.JobMetadata, param string) error {
+	key, value, found := strings.Cut(param, "=")
+	if !found {
+		return errors.New("invalid job metadata directive")
+	}
+
+	var err error
+	switch key {
+	case "number":
+		job.Number = value
+	case "name":
+		job.Name = value
+	case "type":
+		job.Type = value
+	case "programmer":
+		job.Programmer = value
+	case "room":
+		job.Room = value
+	case "class":
+		job.Class = value
+	case "start":
+		job.Start, err = time.Parse(time.RFC3339, value)
+	case "end":
+		job.End, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return errors.New("invalid job metadata time")
+	}
+	return nil
+}
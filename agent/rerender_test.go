@@ -0,0 +1,168 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// opStrings returns printer operations as the directives they came from.
+func opStrings(ops []printerOp) string {
+	var s []string
+	for _, op := range ops {
+		switch op.kind {
+		case opLine:
+			if op.linefeed {
+				s = append(s, "L:"+op.line)
+			} else {
+				s = append(s, "O:"+op.line)
+			}
+		case opPageBreak:
+			s = append(s, "P:")
+		case opSkip:
+			s = append(s, "C:"+strconv.Itoa(op.channel))
+		}
+	}
+	return strings.Join(s, "|")
+}
+
+func TestParseJobStream(t *testing.T) {
+	type testcase struct {
+		stream string
+		ops    string
+		job    scanner.JobMetadata
+		valid  bool
+	}
+	var testcases []testcase = []testcase{
+		{"", "", scanner.JobMetadata{}, true},
+		{"L:LINE 1\nO:OVER\nL:LINE 2\nP:\nC:12\nL:\nJ:J12_MYJOB\n",
+			"L:LINE 1|O:OVER|L:LINE 2|P:|C:12|L:",
+			scanner.JobMetadata{Name: "J12_MYJOB"}, true},
+		{"L:A:B\nM:number=12\nM:name=MYJOB\nM:class=A\nM:room=R1\n" +
+			"M:type=JOB\nM:programmer=HERC01\nM:other=x\nJ:J12_MYJOB\n",
+			"L:A:B",
+			scanner.JobMetadata{Number: "12", Name: "MYJOB", Class: "A",
+				Room: "R1", Type: "JOB", Programmer: "HERC01"}, true},
+		{"M:start=2026-10-17T10:31:07Z\nM:end=2026-10-17T10:32:07Z\n", "",
+			scanner.JobMetadata{
+				Start: time.Date(2026, 10, 17, 10, 31, 7, 0, time.UTC),
+				End:   time.Date(2026, 10, 17, 10, 32, 7, 0, time.UTC)},
+			true},
+		{"L:LINE\r\n", "L:LINE", scanner.JobMetadata{}, true},
+		{"LINE 1\n", "", scanner.JobMetadata{}, false},
+		{"L\n", "", scanner.JobMetadata{}, false},
+		{"\n", "", scanner.JobMetadata{}, false},
+		{"X:value\n", "", scanner.JobMetadata{}, false},
+		{"C:0\n", "", scanner.JobMetadata{}, false},
+		{"C:13\n", "", scanner.JobMetadata{}, false},
+		{"C:one\n", "", scanner.JobMetadata{}, false},
+		{"M:name\n", "", scanner.JobMetadata{}, false},
+		{"M:start=yesterday\n", "", scanner.JobMetadata{}, false},
+	}
+
+	for _, c := range testcases {
+		ops, job, err := parseJobStream(strings.NewReader(c.stream))
+		if (err == nil) != c.valid {
+			t.Errorf("Got error %v for stream %q", err, c.stream)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := opStrings(ops); got != c.ops {
+			t.Errorf("Got operations %q instead of %q for stream %q", got,
+				c.ops, c.stream)
+		}
+		if job != c.job {
+			t.Errorf("Got job %+v instead of %+v for stream %q", job, c.job,
+				c.stream)
+		}
+	}
+}
+
+func TestJobStreamRoundTrip(t *testing.T) {
+	job := scanner.JobMetadata{Number: "12", Name: "MYJOB", Type: "JOB",
+		Programmer: "HERC01", Class: "A",
+		Start: time.Date(2026, 10, 17, 10, 31, 7, 0, time.UTC)}
+	s := newJobStream()
+	s.addLine("LINE 1", true)
+	s.addLine("OVER", false)
+	s.pageBreak()
+	s.skipToChannel(3)
+	s.addLine("", true)
+	compressed := s.end(job)
+
+	dec, err := zstd.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	ops, got, err := parseJobStream(dec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	directives := opStrings(ops)
+	if directives != "L:LINE 1|O:OVER|P:|C:3|L:" {
+		t.Errorf("Got operations %q", directives)
+	}
+	if !got.Start.Equal(job.Start) {
+		t.Errorf("Got start %v instead of %v", got.Start, job.Start)
+	}
+	got.Start = job.Start
+	if got != job {
+		t.Errorf("Got job %+v instead of %+v", got, job)
+	}
+}
+
+func TestJobStreamFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"b.job.zst", "a.job", "a.pdf", "notes.txt",
+		"2026/10/c.job.zst", "2026/10/c.pdf", "2026/a.job.zst",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := jobStreamFiles(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range files {
+		rel, _ := filepath.Rel(dir, files[i])
+		files[i] = filepath.ToSlash(rel)
+	}
+	want := []string{"2026/10/c.job.zst", "2026/a.job.zst", "a.job",
+		"b.job.zst"}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("Got files %v instead of %v", files, want)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A local output with a retention policy removes old jobs from its output
+// directory: jobs older than retention_days, the oldest jobs beyond
+// retention_max_jobs, and the oldest jobs beyond retention_max_mb in total. A
+// job is its PDF along with the other files with the same name (card images
+// and archive files), which are removed together. Only files named by the
+// output's filename template are considered, so other files in the directory
+// are left alone, as are files named by a template the output used before.
+// Instead of removing them, the jobs can be moved into a compressed tar file
+// in the retention_archive_directory, and with retention_dry_run we only log
+// what we would remove.
+
+// retentionInterval is how often we clean up an output directory.
+const retentionInterval = time.Hour
+
+// jobFileExts are the file name extensions of the files a local output
+// writes for a job, longest first so that ".job.zst" is found before any
+// shorter extension it ends with.
+var jobFileExts = []string{
+	"." + archiveDirectivesExt,
+	"." + archiveManifestExt,
+	"." + archiveTextExt,
+	".cards",
+	".pdf",
+}
+
+func validateRetention(name string, config OutputConfig,
+	inputs map[string]InputConfig, outputs map[string]OutputConfig) []error {
+
+	var errs []error
+
+	limits := config.RetentionDays != 0 || config.RetentionMaxJobs != 0 ||
+		config.RetentionMaxMB != 0
+	if limits && config.Mode != "local" {
+		errs = append(errs,
+			fmt.Errorf("output [%s] retention is only used in local mode",
+				name))
+	}
+	if !limits && (config.RetentionDryRun ||
+		config.RetentionArchive != "") {
+		errs = append(errs,
+			fmt.Errorf("output [%s] 'retention_dry_run' and "+
+				"'retention_archive_directory' require 'retention_days', "+
+				"'retention_max_jobs', or 'retention_max_mb'", name))
+	}
+	for _, v := range []struct {
+		key   string
+		value int
+	}{
+		{"retention_days", config.RetentionDays},
+		{"retention_max_jobs", config.RetentionMaxJobs},
+		{"retention_max_mb", config.RetentionMaxMB},
+	} {
+		if v.value < 0 {
+			errs = append(errs,
+				fmt.Errorf("output [%s] '%s' must not be negative", name,
+					v.key))
+		}
+	}
+	if !hasRetention(config) || config.OutputDir == "" {
+		return errs
+	}
+
+	// Cleaning up the output directory must not touch the files of
+	// anything else that keeps its files in there.
+	nested := func(key, dir string) {
+		if dir != "" && withinDir(dir, config.OutputDir) {
+			errs = append(errs,
+				fmt.Errorf("output [%s] has a retention policy, so %s `%s` "+
+					"must not be in its 'output_directory'", name, key, dir))
+		}
+	}
+	nested("'retention_archive_directory'", config.RetentionArchive)
+	for _, other := range sortedKeys(inputs) {
+		input := inputs[other]
+		nested(fmt.Sprintf("input [%s] 'spool_directory'", other),
+			input.SpoolDir)
+		nested(fmt.Sprintf("input [%s] 'spool_archive_directory'", other),
+			input.SpoolArchiveDir)
+		nested(fmt.Sprintf("input [%s] 'capture_directory'", other),
+			input.CaptureDir)
+	}
+	for _, other := range sortedKeys(outputs) {
+		output := outputs[other]
+		nested(fmt.Sprintf("output [%s] 'online_spool_directory'", other),
+			output.OnlineSpoolDir)
+		if other != name && output.OutputDir != "" &&
+			!sameDir(output.OutputDir, config.OutputDir) {
+			nested(fmt.Sprintf("output [%s] 'output_directory'", other),
+				output.OutputDir)
+		}
+	}
+
+	return errs
+}
+
+// withinDir returns true if dir is parent or is somewhere inside it.
+func withinDir(dir, parent string) bool {
+	rel, err := filepath.Rel(absDir(parent), absDir(dir))
+	return err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sameDir returns true if a and b are the same directory.
+func sameDir(a, b string) bool {
+	return absDir(a) == absDir(b)
+}
+
+// absDir returns the absolute path of dir, or just cleans it up if we can't.
+func absDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
+
+// hasRetention returns true if an output has a retention policy.
+func hasRetention(config OutputConfig) bool {
+	return config.Mode == "local" && (config.RetentionDays > 0 ||
+		config.RetentionMaxJobs > 0 || config.RetentionMaxMB > 0)
+}
+
+// retentionMu keeps cleanups of outputs that share an output directory from
+// running at the same time.
+var retentionMu sync.Mutex
+
+// runRetention cleans up the output directory of output now, and then every
+// retentionInterval until ctx is done.
+func runRetention(ctx context.Context, output OutputConfig, outputName string,
+	wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	for {
+		retentionMu.Lock()
+		err := cleanOutputDir(output, outputName)
+		retentionMu.Unlock()
+		if err != nil {
+			log.Printf("ERROR: [%s] retention: %v", outputName, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retentionInterval):
+		}
+	}
+}
+
+// storedJob is the files of a job in an output directory.
+type storedJob struct {
+	base    string // the path of the files, without their extensions
+	files   []string
+	size    int64
+	modTime time.Time // of the newest file
+}
+
+// storedJobs returns the jobs in dir and its subdirectories that are named
+// by tmpl, oldest first.
+func storedJobs(dir string, tmpl *filenameTemplate) ([]*storedJob, error) {
+	pattern := tmpl.pattern()
+	jobs := make(map[string]*storedJob)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry,
+		err error) error {
+
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for _, ext := range jobFileExts {
+			base, ok := strings.CutSuffix(path, ext)
+			if !ok {
+				continue
+			}
+			rel, err := filepath.Rel(dir, base)
+			if err != nil || !pattern.MatchString(filepath.ToSlash(rel)) {
+				break
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			job := jobs[base]
+			if job == nil {
+				job = &storedJob{base: base}
+				jobs[base] = job
+			}
+			job.files = append(job.files, path)
+			job.size += info.Size()
+			if info.ModTime().After(job.modTime) {
+				job.modTime = info.ModTime()
+			}
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sorted []*storedJob
+	for _, job := range jobs {
+		sorted = append(sorted, job)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].modTime.Equal(sorted[j].modTime) {
+			return sorted[i].base < sorted[j].base
+		}
+		return sorted[i].modTime.Before(sorted[j].modTime)
+	})
+	return sorted, nil
+}
+
+// expiredJobs returns the jobs, which are oldest first, that the output's
+// retention policy doesn't keep.
+func expiredJobs(output OutputConfig, jobs []*storedJob) []*storedJob {
+	var total int64
+	for _, job := range jobs {
+		total += job.size
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -output.RetentionDays)
+	maxBytes := int64(output.RetentionMaxMB) * 1024 * 1024
+	n := 0
+	for ; n < len(jobs); n++ {
+		remaining := len(jobs) - n
+		if (output.RetentionDays > 0 && jobs[n].modTime.Before(cutoff)) ||
+			(output.RetentionMaxJobs > 0 &&
+				remaining > output.RetentionMaxJobs) ||
+			(maxBytes > 0 && total > maxBytes) {
+			total -= jobs[n].size
+			continue
+		}
+		break
+	}
+	return jobs[:n]
+}
+
+// cleanOutputDir removes, archives, or with a dry run, logs the jobs in the
+// output directory that the retention policy doesn't keep.
+func cleanOutputDir(output OutputConfig, outputName string) error {
+	tmpl, err := parseFilenameTemplate(output.FilenameTemplate)
+	if err != nil {
+		return err
+	}
+	jobs, err := storedJobs(output.OutputDir, tmpl)
+	if err != nil {
+		return err
+	}
+	expired := expiredJobs(output, jobs)
+	if len(expired) == 0 {
+		return nil
+	}
+	var size int64
+	for _, job := range expired {
+		size += job.size
+	}
+
+	if output.RetentionDryRun {
+		for _, job := range expired {
+			log.Printf("INFO:  [%s] retention: would remove %s (%s)",
+				outputName, job.base, strings.Join(jobExts(job), ", "))
+		}
+		log.Printf("INFO:  [%s] retention: would remove %d of %d job(s), "+
+			"%.1f MB (dry run)", outputName, len(expired), len(jobs),
+			float64(size)/(1024*1024))
+		return nil
+	}
+
+	action := "removed"
+	if output.RetentionArchive != "" {
+		name, err := archiveJobs(output.RetentionArchive,
+			output.OutputDir, expired)
+		if err != nil {
+			return fmt.Errorf("couldn't archive expired jobs: %v", err)
+		}
+		action = "archived to " + name + " and removed"
+	}
+
+	removed := 0
+	for _, job := range expired {
+		for _, file := range job.files {
+			if err := os.Remove(file); err != nil {
+				log.Printf("ERROR: [%s] retention: %v", outputName, err)
+			}
+		}
+		removeEmptyDirs(output.OutputDir, filepath.Dir(job.base))
+		removed++
+	}
+	log.Printf("INFO:  [%s] retention: %s %d job(s), %.1f MB", outputName,
+		action, removed, float64(size)/(1024*1024))
+	return nil
+}
+
+// jobExts returns the file name extensions of a job's files.
+func jobExts(job *storedJob) []string {
+	var exts []string
+	for _, file := range job.files {
+		exts = append(exts, strings.TrimPrefix(file, job.base))
+	}
+	return exts
+}
+
+// removeEmptyDirs removes dir, and then its parents, if they're empty, up to
+// but not including root. Filename templates with subdirectories would
+// otherwise leave empty directories behind.
+func removeEmptyDirs(root, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root &&
+		strings.HasPrefix(dir, root+string(filepath.Separator)); dir =
+		filepath.Dir(dir) {
+		// Remove fails if the directory isn't empty.
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// archiveJobs writes the files of jobs to a new zstd-compressed tar file in
+// archiveDir, with their paths relative to outputDir, and returns its name.
+func archiveJobs(archiveDir, outputDir string,
+	jobs []*storedJob) (string, error) {
+
+	if err := verifyOrCreateDir(archiveDir); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(archiveDir, ".v1403-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	enc, err := zstd.NewWriter(f)
+	if err != nil {
+		return "", err
+	}
+	tw := tar.NewWriter(enc)
+	for _, job := range jobs {
+		for _, file := range job.files {
+			if err := addToTar(tw, outputDir, file); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(archiveDir, "v1403-expired-"+
+		time.Now().UTC().Format("20060102T150405")+".tar.zst")
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(name); err != nil {
+			break
+		}
+		name = filepath.Join(archiveDir, fmt.Sprintf("v1403-expired-%s-%d"+
+			".tar.zst", time.Now().UTC().Format("20060102T150405"), n))
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// addToTar adds the file to tw, named with its path relative to dir.
+func addToTar(tw *tar.Writer, dir, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
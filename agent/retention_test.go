@@ -0,0 +1,195 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredJobs(t *testing.T) {
+	type testcase struct {
+		output  OutputConfig
+		expired int
+	}
+	// Five jobs of 1 MB each, 10, 8, 6, 4, and 2 days old.
+	var jobs []*storedJob
+	for age := 10; age > 0; age -= 2 {
+		jobs = append(jobs, &storedJob{
+			base:    fmt.Sprintf("job%d", age),
+			size:    1024 * 1024,
+			modTime: time.Now().AddDate(0, 0, -age),
+		})
+	}
+	var testcases []testcase = []testcase{
+		{OutputConfig{}, 0},
+		{OutputConfig{RetentionDays: 30}, 0},
+		{OutputConfig{RetentionDays: 7}, 2},
+		{OutputConfig{RetentionDays: 1}, 5},
+		{OutputConfig{RetentionMaxJobs: 5}, 0},
+		{OutputConfig{RetentionMaxJobs: 3}, 2},
+		{OutputConfig{RetentionMaxMB: 5}, 0},
+		{OutputConfig{RetentionMaxMB: 4}, 1},
+		{OutputConfig{RetentionMaxMB: 1}, 4},
+		{OutputConfig{RetentionDays: 7, RetentionMaxJobs: 2}, 3},
+		{OutputConfig{RetentionDays: 9, RetentionMaxMB: 2}, 3},
+	}
+
+	for _, c := range testcases {
+		if got := len(expiredJobs(c.output, jobs)); got != c.expired {
+			t.Errorf("Got %d instead of %d expired jobs for days %d, jobs "+
+				"%d, MB %d", got, c.expired, c.output.RetentionDays,
+				c.output.RetentionMaxJobs, c.output.RetentionMaxMB)
+		}
+	}
+}
+
+func TestStoredJobs(t *testing.T) {
+	type testcase struct {
+		template string
+		jobs     []string // base and extensions of each job, oldest first
+	}
+	dir := t.TempDir()
+	files := []struct {
+		name string
+		age  int // days
+	}{
+		{"v1403-J1_A-20260101T000000.pdf", 3},
+		{"v1403-J1_A-20260101T000000.txt", 3},
+		{"v1403-J1_A-20260101T000000.job.zst", 3},
+		{"v1403-J2_B-20260102T000000.pdf", 2},
+		{"v1403-J2_B-20260102T000000-2.pdf", 1},
+		{"v1403-J2_B-20260102T000000-2.cards", 1},
+		{"notes.txt", 5},
+		{"v1403-J3_C-20260103T000000.log", 5},
+		{"2026/01/C.pdf", 4},
+		{"2026/01/C.json", 4},
+		{"2026/D.pdf", 5},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().AddDate(0, 0, -f.age)
+		os.Chtimes(path, when, when)
+	}
+	var testcases []testcase = []testcase{
+		{"", []string{
+			"v1403-J1_A-20260101T000000 .job.zst .pdf .txt",
+			"v1403-J2_B-20260102T000000 .pdf",
+			"v1403-J2_B-20260102T000000-2 .cards .pdf",
+		}},
+		{"{yyyy}/{mm}/{jobname}", []string{
+			"2026/01/C .json .pdf",
+		}},
+		{"{yyyy}/{jobname}", []string{
+			"2026/D .pdf",
+		}},
+	}
+
+	for _, c := range testcases {
+		tmpl, err := parseFilenameTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs, err := storedJobs(dir, tmpl)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for _, job := range jobs {
+			rel, _ := filepath.Rel(dir, job.base)
+			exts := jobExts(job)
+			sort.Strings(exts)
+			got = append(got, filepath.ToSlash(rel)+" "+
+				strings.Join(exts, " "))
+		}
+		if strings.Join(got, "|") != strings.Join(c.jobs, "|") {
+			t.Errorf("Got jobs %q instead of %q for template `%s`", got,
+				c.jobs, c.template)
+		}
+	}
+}
+
+func TestValidateRetentionDirectories(t *testing.T) {
+	type testcase struct {
+		output OutputConfig
+		inputs map[string]InputConfig
+		valid  bool
+	}
+	base := OutputConfig{Mode: "local", OutputDir: "pdfs",
+		RetentionDays: 30}
+	with := func(f func(o *OutputConfig)) OutputConfig {
+		o := base
+		f(&o)
+		return o
+	}
+	var testcases []testcase = []testcase{
+		{base, nil, true},
+		{with(func(o *OutputConfig) { o.RetentionArchive = "expired" }),
+			nil, true},
+		{with(func(o *OutputConfig) { o.RetentionArchive = "pdfs" }),
+			nil, false},
+		{with(func(o *OutputConfig) { o.RetentionArchive = "pdfs/old" }),
+			nil, false},
+		{with(func(o *OutputConfig) { o.RetentionArchive = "./pdfs/../x" }),
+			nil, true},
+		{base, map[string]InputConfig{"s": {SpoolDir: "pdfs/in"}}, false},
+		{base, map[string]InputConfig{"s": {SpoolDir: "in",
+			SpoolArchiveDir: "pdfs/done"}}, false},
+		{base, map[string]InputConfig{"c": {CaptureDir: "pdfs"}}, false},
+		{base, map[string]InputConfig{"s": {SpoolDir: "pdfs-in"}}, true},
+		{with(func(o *OutputConfig) { o.RetentionDays = 0 }),
+			map[string]InputConfig{"s": {SpoolDir: "pdfs/in"}}, true},
+	}
+
+	for _, c := range testcases {
+		outputs := map[string]OutputConfig{"default": c.output}
+		errs := validateRetention("default", c.output, c.inputs, outputs)
+		if (len(errs) == 0) != c.valid {
+			t.Errorf("Got errors %v for output %+v with inputs %+v", errs,
+				c.output, c.inputs)
+		}
+	}
+
+	// Outputs may share a directory, but not keep one inside another's.
+	outputs := map[string]OutputConfig{
+		"default": base,
+		"same":    {Mode: "local", OutputDir: "./pdfs"},
+		"online":  {Mode: "online", OnlineSpoolDir: "spool"},
+	}
+	errs := validateRetention("default", base, nil, outputs)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	outputs["nested"] = OutputConfig{Mode: "local", OutputDir: "pdfs/x"}
+	outputs["online"] = OutputConfig{Mode: "online",
+		OnlineSpoolDir: "pdfs/spool"}
+	errs = validateRetention("default", base, nil, outputs)
+	if len(errs) != 2 {
+		t.Errorf("Got errors %v instead of two", errs)
+	}
+}
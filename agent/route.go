@@ -0,0 +1,325 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// An input's routing rules decide which outputs each job is printed on. We
+// don't know who a job belongs to until the scanner has seen all of it, so
+// the routing handler holds on to the job until it ends, then checks the
+// rules in order. The first rule that matches sends the job to each of its
+// outputs, or drops it; jobs that don't match any rule go to the input's own
+// output.
+
+// RouteConfig is a routing rule. A job matches the rule if each of the
+// patterns that are set matches.
+type RouteConfig struct {
+	JobName   string   `yaml:"job_name"`
+	JobNumber string   `yaml:"job_number"`
+	JobType   string   `yaml:"job_type"`
+	Class     string   `yaml:"class"`
+	FirstPage string   `yaml:"first_page"`
+	Outputs   []string `yaml:"outputs"`
+	Drop      bool     `yaml:"drop"`
+}
+
+// route is a routing rule with its patterns compiled.
+type route struct {
+	name, number, jobType, class *regexp.Regexp
+	firstPage                    *regexp.Regexp
+	outputs                      []string
+	drop                         bool
+}
+
+// fieldPattern compiles a routing rule's pattern for a job field, which must
+// match the whole field.
+func fieldPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// pagePattern compiles a routing rule's pattern for the first page, where ^
+// and $ match at the start and end of each line.
+func pagePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?m)" + pattern)
+}
+
+// compileRoute compiles a routing rule.
+func compileRoute(config RouteConfig) (route, error) {
+	r := route{outputs: config.Outputs, drop: config.Drop}
+	for _, p := range []struct {
+		name    string
+		pattern string
+		re      **regexp.Regexp
+		compile func(string) (*regexp.Regexp, error)
+	}{
+		{"job_name", config.JobName, &r.name, fieldPattern},
+		{"job_number", config.JobNumber, &r.number, fieldPattern},
+		{"job_type", config.JobType, &r.jobType, fieldPattern},
+		{"class", config.Class, &r.class, fieldPattern},
+		{"first_page", config.FirstPage, &r.firstPage, pagePattern},
+	} {
+		re, err := p.compile(p.pattern)
+		if err != nil {
+			return route{}, fmt.Errorf("'%s' is invalid: %v", p.name, err)
+		}
+		*p.re = re
+	}
+	return r, nil
+}
+
+func validateRoutes(name string, config InputConfig,
+	outputs map[string]OutputConfig) []error {
+
+	var errs []error
+
+	if len(config.Routes) > 0 && inputType(config) == inputPunch {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'routes' can't be used with punch inputs",
+				name))
+	}
+	for i, rc := range config.Routes {
+		if _, err := compileRoute(rc); err != nil {
+			errs = append(errs,
+				fmt.Errorf("input [%s] route %d: %v", name, i+1, err))
+		}
+		if rc.Drop == (len(rc.Outputs) > 0) {
+			errs = append(errs,
+				fmt.Errorf("input [%s] route %d must set either 'outputs' "+
+					"or 'drop'", name, i+1))
+		}
+		for _, o := range rc.Outputs {
+			if _, ok := outputs[o]; !ok {
+				errs = append(errs,
+					fmt.Errorf("input [%s] route %d refers to output `%s`, "+
+						"which doesn't exist", name, i+1, o))
+			}
+		}
+	}
+
+	return errs
+}
+
+// compileRoutes returns an input's compiled routing rules, and the
+// configuration of each output they refer to.
+func compileRoutes(input InputConfig,
+	outputs map[string]OutputConfig) ([]route, map[string]OutputConfig) {
+
+	var routes []route
+	routeOutputs := make(map[string]OutputConfig)
+	for _, rc := range input.Routes {
+		// The configuration has already been validated, so this won't fail.
+		r, _ := compileRoute(rc)
+		routes = append(routes, r)
+		for _, o := range rc.Outputs {
+			routeOutputs[o] = outputs[o]
+		}
+	}
+	return routes, routeOutputs
+}
+
+// matches returns true if job, whose first page is firstPage, matches the
+// rule.
+func (r route) matches(job scanner.JobMetadata, firstPage string) bool {
+	for _, f := range []struct {
+		re    *regexp.Regexp
+		value string
+	}{
+		{r.name, job.Name},
+		{r.number, job.Number},
+		{r.jobType, job.Type},
+		{r.class, job.Class},
+		{r.firstPage, firstPage},
+	} {
+		if f.re != nil && !f.re.MatchString(f.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// newInputHandler sets up the printer handler for the jobs from an input
+// whose own output is output: the output handler, or a routing handler if
+// the input has routing rules. tag identifies the handler in log messages.
+func newInputHandler(input InputConfig, output OutputConfig,
+	tag string) (scanner.PrinterHandler, error) {
+
+	handler, err := newOutputHandler(output, tag)
+	if err != nil || len(input.routes) == 0 {
+		return handler, err
+	}
+	return &routingHandler{
+		routes:       input.routes,
+		routeOutputs: input.routeOutputs,
+		defaultOut:   handler,
+		handlers:     make(map[string]scanner.PrinterHandler),
+		tag:          tag,
+	}, nil
+}
+
+// Kinds of printer operations a routing handler holds on to.
+const (
+	opLine = iota
+	opPageBreak
+	opSkip
+)
+
+type printerOp struct {
+	kind     int
+	line     string
+	linefeed bool
+	channel  int
+}
+
+// routingHandler holds on to each job until it ends, then sends it to the
+// outputs chosen by the routing rules.
+type routingHandler struct {
+	routes       []route
+	routeOutputs map[string]OutputConfig
+	defaultOut   scanner.PrinterHandler
+	tag          string
+
+	// handlers are the output handlers for the routing rules' outputs,
+	// created when a job is first sent to them.
+	handlers map[string]scanner.PrinterHandler
+
+	ops []printerOp
+
+	// firstPage is the text of the job's first page that isn't blank.
+	// firstPageDone is set at the page break that ends it.
+	firstPage     strings.Builder
+	firstPageDone bool
+
+	lastErr error
+}
+
+func (h *routingHandler) AddLine(line string, linefeed bool) {
+	h.ops = append(h.ops,
+		printerOp{kind: opLine, line: line, linefeed: linefeed})
+	if !h.firstPageDone {
+		h.firstPage.WriteString(line)
+		if linefeed {
+			h.firstPage.WriteByte('\n')
+		}
+	}
+}
+
+func (h *routingHandler) PageBreak() {
+	h.ops = append(h.ops, printerOp{kind: opPageBreak})
+	h.endFirstPage()
+}
+
+func (h *routingHandler) SkipToChannel(channel int) {
+	h.ops = append(h.ops, printerOp{kind: opSkip, channel: channel})
+	// Channel 1 is the top of the form.
+	if channel == 1 {
+		h.endFirstPage()
+	}
+}
+
+// endFirstPage ends the first page at a page break, unless nothing has been
+// printed on it yet.
+func (h *routingHandler) endFirstPage() {
+	if strings.TrimSpace(h.firstPage.String()) != "" {
+		h.firstPageDone = true
+	}
+}
+
+func (h *routingHandler) EndOfJob(job scanner.JobMetadata) {
+	// No matter what happens, we always want to reset our state for a fresh
+	// new job.
+	defer func() {
+		h.ops = nil
+		h.firstPage.Reset()
+		h.firstPageDone = false
+	}()
+
+	h.lastErr = nil
+	targets := []scanner.PrinterHandler{h.defaultOut}
+	for i, r := range h.routes {
+		if !r.matches(job, h.firstPage.String()) {
+			continue
+		}
+		if r.drop {
+			log.Printf("INFO:  [%s] dropping job %s (route %d)", h.tag,
+				job.JobInfo(), i+1)
+			return
+		}
+		targets = nil
+		for _, o := range r.outputs {
+			handler, err := h.outputHandler(o)
+			if err != nil {
+				log.Printf("ERROR: [%s] %v", h.tag, err)
+				h.lastErr = err
+				continue
+			}
+			targets = append(targets, handler)
+		}
+		break
+	}
+
+	for _, t := range targets {
+		for _, op := range h.ops {
+			switch op.kind {
+			case opLine:
+				t.AddLine(op.line, op.linefeed)
+			case opPageBreak:
+				t.PageBreak()
+			case opSkip:
+				t.SkipToChannel(op.channel)
+			}
+		}
+		t.EndOfJob(job)
+		if r, ok := t.(jobErrorReporter); ok && h.lastErr == nil {
+			h.lastErr = r.lastJobError()
+		}
+	}
+}
+
+// outputHandler returns the output handler for the named output, setting it
+// up the first time.
+func (h *routingHandler) outputHandler(name string) (scanner.PrinterHandler,
+	error) {
+
+	if handler, ok := h.handlers[name]; ok {
+		return handler, nil
+	}
+	handler, err := newOutputHandler(h.routeOutputs[name], h.tag+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+	h.handlers[name] = handler
+	return handler, nil
+}
+
+func (h *routingHandler) lastJobError() error {
+	return h.lastErr
+}
@@ -0,0 +1,98 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"testing"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+func TestRouteMatches(t *testing.T) {
+	type testcase struct {
+		config    RouteConfig
+		job       scanner.JobMetadata
+		firstPage string
+		matches   bool
+	}
+	job := scanner.JobMetadata{Name: "MYJOB", Number: "1234", Type: "JOB",
+		Class: "A"}
+	var testcases []testcase = []testcase{
+		{RouteConfig{}, job, "", true},
+		{RouteConfig{JobName: "MYJOB"}, job, "", true},
+		{RouteConfig{JobName: "MY"}, job, "", false},
+		{RouteConfig{JobName: "MY.*"}, job, "", true},
+		{RouteConfig{JobName: "OTHER|MYJOB"}, job, "", true},
+		{RouteConfig{JobName: "MYJOB", Class: "B"}, job, "", false},
+		{RouteConfig{JobNumber: `\d+`, JobType: "JOB"}, job, "", true},
+		{RouteConfig{JobType: "STC"}, job, "", false},
+		{RouteConfig{FirstPage: "^IEF142I"}, job,
+			"HEADER\nIEF142I MYJOB STEP1\n", true},
+		{RouteConfig{FirstPage: "^STEP1"}, job,
+			"HEADER\nIEF142I MYJOB STEP1\n", false},
+		{RouteConfig{FirstPage: "STEP1$"}, job,
+			"HEADER\nIEF142I MYJOB STEP1\n", true},
+		{RouteConfig{JobName: "SYSLOG"}, scanner.JobMetadata{}, "", false},
+		{RouteConfig{JobName: ".*"}, scanner.JobMetadata{}, "", true},
+	}
+
+	for _, c := range testcases {
+		r, err := compileRoute(c.config)
+		if err != nil {
+			t.Errorf("couldn't compile route %+v: %v", c.config, err)
+			continue
+		}
+		if got := r.matches(c.job, c.firstPage); got != c.matches {
+			t.Errorf("Got %v instead of %v for route %+v with job %+v and "+
+				"first page %q", got, c.matches, c.config, c.job,
+				c.firstPage)
+		}
+	}
+}
+
+func TestCompileRoute(t *testing.T) {
+	type testcase struct {
+		config RouteConfig
+		valid  bool
+	}
+	var testcases []testcase = []testcase{
+		{RouteConfig{JobName: "MYJOB", Outputs: []string{"a"}}, true},
+		{RouteConfig{Drop: true}, true},
+		{RouteConfig{JobName: "("}, false},
+		{RouteConfig{JobNumber: "[0-9"}, false},
+		{RouteConfig{JobType: "*"}, false},
+		{RouteConfig{Class: `\`}, false},
+		{RouteConfig{FirstPage: "(?P<x"}, false},
+	}
+
+	for _, c := range testcases {
+		r, err := compileRoute(c.config)
+		if (err == nil) != c.valid {
+			t.Errorf("Got error %v for route %+v", err, c.config)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.drop != c.config.Drop ||
+			len(r.outputs) != len(c.config.Outputs) {
+			t.Errorf("Route %+v compiled to %+v", c.config, r)
+		}
+	}
+}
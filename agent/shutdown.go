@@ -0,0 +1,142 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// shutdownContext returns a context that is done when we receive an
+// interrupt or termination signal. After the first signal, we go back to the
+// default signal handling, so a second Ctrl-C will stop the agent right away
+// if finishing up takes too long.
+func shutdownContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		log.Printf("INFO:  shutting down; finishing print jobs in progress " +
+			"(interrupt again to quit immediately)")
+		stop()
+	}()
+	return ctx
+}
+
+// jobStats counts the jobs an input printed, for the summary we log when we
+// shut down and for the status server.
+type jobStats struct {
+	mu    sync.Mutex
+	jobs  int
+	lines int
+	pages int
+	bytes int64
+
+	// state is the input's connection state, and reconnects counts the
+	// times it has had to connect to Hercules again.
+	state      string
+	reconnects int
+
+	lastJob       time.Time
+	lastError     string
+	lastErrorTime time.Time
+
+	// active counts the jobs in progress, and lastRead is when the input
+	// last received anything, so that we can tell when it's safe to stop
+	// the input when the configuration is reloaded.
+	active   int
+	lastRead time.Time
+}
+
+// countingHandler passes everything through to another handler while
+// keeping count of jobs, lines, and pages in stats.
+type countingHandler struct {
+	scanner.PrinterHandler
+	stats *jobStats
+	lines int
+	pages int
+
+	// started is set once the current job has printed something.
+	started bool
+}
+
+func newCountingHandler(handler scanner.PrinterHandler,
+	stats *jobStats) scanner.PrinterHandler {
+
+	return &countingHandler{PrinterHandler: handler, stats: stats}
+}
+
+func (h *countingHandler) AddLine(line string, linefeed bool) {
+	h.start()
+	h.lines++
+	h.PrinterHandler.AddLine(line, linefeed)
+}
+
+func (h *countingHandler) PageBreak() {
+	h.start()
+	h.pages++
+	h.PrinterHandler.PageBreak()
+}
+
+func (h *countingHandler) SkipToChannel(channel int) {
+	h.start()
+	h.PrinterHandler.SkipToChannel(channel)
+}
+
+func (h *countingHandler) EndOfJob(job scanner.JobMetadata) {
+	h.start()
+	h.PrinterHandler.EndOfJob(job)
+	h.stats.endJob(h.lines, h.pages+1)
+	h.lines = 0
+	h.pages = 0
+	h.started = false
+}
+
+// start counts the job as in progress when it first prints something.
+func (h *countingHandler) start() {
+	if !h.started {
+		h.started = true
+		h.stats.startJob()
+	}
+}
+
+// logSummary logs how many jobs each input printed.
+func logSummary(stats map[string]*jobStats) {
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := stats[name]
+		s.mu.Lock()
+		log.Printf("INFO:  [%s] printed %d job(s), %d line(s)", name, s.jobs,
+			s.lines)
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racingmars/virtual1403/scanner"
+)
+
+// A spool input watches a directory for printer files written by Hercules
+// (a printer device with a file name rather than a sockdev address) or any
+// other program. We can't tell when another program is done writing a file,
+// so we wait until a file has gone without changing for the quiet period
+// before printing it. Programs that can write files under a temporary name
+// and rename them when they are complete should use a name that starts with
+// a "." or doesn't match spool_pattern; we ignore those files.
+//
+// Normally a file is removed or archived once it is printed, so the program
+// writing it must be done with it by then: anything it writes to the file
+// later would never be printed. A Hercules printer device keeps its file
+// open and appends to it until the device is reinitialized, so for those
+// files, spool_follow leaves the files in place and prints whatever has been
+// added to them each time they go quiet. How much of each file we've printed
+// is kept in a hidden file next to it, so a restart doesn't print it again.
+
+// spoolOffsetPrefix starts the names of the files where spool_follow keeps
+// how much of each file it has printed.
+const spoolOffsetPrefix = ".v1403-printed-"
+
+// defaultSpoolQuietTime is how long a file must go without changing before
+// we print it, if the input doesn't set spool_quiet_seconds.
+const defaultSpoolQuietTime = 5 * time.Second
+
+// spoolPollInterval is how often we look for new files in the spool
+// directory.
+const spoolPollInterval = time.Second
+
+// spoolFile is what we know about a file in the spool directory.
+type spoolFile struct {
+	size    int64
+	modTime time.Time
+
+	// changed is when we first saw the file with its current size and
+	// modification time.
+	changed time.Time
+
+	// failed is true if we couldn't print or clean up the file; we won't
+	// try again until it changes.
+	failed bool
+}
+
+func validateSpoolConfig(name string, config InputConfig) []error {
+	var errs []error
+
+	if config.SpoolDir == "" {
+		errs = append(errs,
+			fmt.Errorf("input [%s] must set 'spool_directory'", name))
+	}
+	if _, err := filepath.Match(config.SpoolPattern, ""); err != nil {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'spool_pattern' is invalid: %v", name,
+				err))
+	}
+	if config.SpoolQuietTime < 0 {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'spool_quiet_seconds' must not be "+
+				"negative", name))
+	}
+	if config.SpoolArchiveDir != "" && config.SpoolDir != "" &&
+		filepath.Clean(config.SpoolArchiveDir) ==
+			filepath.Clean(config.SpoolDir) {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'spool_archive_directory' must be "+
+				"different from 'spool_directory'", name))
+	}
+	if config.SpoolFollow && config.SpoolArchiveDir != "" {
+		errs = append(errs,
+			fmt.Errorf("input [%s] 'spool_archive_directory' can't be used "+
+				"with 'spool_follow', which leaves files in place", name))
+	}
+
+	return errs
+}
+
+// runSpool prints the files that appear in the input's spool directory
+// until ctx is done.
+func runSpool(ctx context.Context, input InputConfig, output OutputConfig,
+	handler scanner.PrinterHandler, stats *jobStats, inputName string) {
+
+	log.Printf("INFO:  [%s] Watching spool directory `%s`", inputName,
+		input.SpoolDir)
+	stats.setState(stateWatching)
+
+	quiet := defaultSpoolQuietTime
+	if input.SpoolQuietTime > 0 {
+		quiet = time.Duration(input.SpoolQuietTime) * time.Second
+	}
+
+	// With spool_follow, offsets is how much of each file we've printed.
+	var offsets map[string]int64
+	if input.SpoolFollow {
+		offsets = make(map[string]int64)
+		removeStaleSpoolOffsets(input.SpoolDir)
+	}
+
+	files := make(map[string]*spoolFile)
+	for {
+		ready, err := readySpoolFiles(input.SpoolDir, input.SpoolPattern,
+			files, quiet)
+		if err != nil {
+			log.Printf("ERROR: [%s] %v", inputName, err)
+			stats.setError(err)
+		}
+		for _, name := range ready {
+			if ctx.Err() != nil {
+				break
+			}
+			var ok bool
+			if offsets != nil {
+				ok = followSpoolFile(ctx, input, output, handler, stats,
+					inputName, name, offsets)
+			} else {
+				ok = printSpoolFile(ctx, input, output, handler, stats,
+					inputName, name)
+			}
+			if !ok {
+				files[name].failed = true
+			}
+		}
+		for name := range offsets {
+			if files[name] == nil {
+				delete(offsets, name)
+				os.Remove(spoolOffsetPath(input.SpoolDir, name))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(spoolPollInterval):
+		}
+	}
+}
+
+// readySpoolFiles updates files with the current contents of the directory
+// dir that match pattern, and returns the names of the files that have been
+// quiet for long enough to use, oldest first.
+func readySpoolFiles(dir, pattern string, files map[string]*spoolFile,
+	quiet time.Duration) ([]string, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	now := time.Now()
+	present := make(map[string]bool)
+	var ready []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The file was removed since we read the directory.
+			continue
+		}
+		present[name] = true
+
+		f := files[name]
+		if f == nil || f.size != info.Size() ||
+			!f.modTime.Equal(info.ModTime()) {
+			f = &spoolFile{size: info.Size(), modTime: info.ModTime(),
+				changed: now}
+			files[name] = f
+		}
+		if !f.failed && now.Sub(f.changed) >= quiet {
+			ready = append(ready, name)
+		}
+	}
+
+	for name := range files {
+		if !present[name] {
+			delete(files, name)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		return files[ready[i]].modTime.Before(files[ready[j]].modTime)
+	})
+	return ready, nil
+}
+
+// printSpoolFile prints the file name in the spool directory, then archives
+// or deletes it. Returns false if the file couldn't be printed or cleaned
+// up and is still in the spool directory.
+func printSpoolFile(ctx context.Context, input InputConfig,
+	output OutputConfig, handler scanner.PrinterHandler, stats *jobStats,
+	inputName, name string) bool {
+
+	path := filepath.Join(input.SpoolDir, name)
+	log.Printf("INFO:  [%s] printing spool file `%s`", inputName, name)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		stats.setError(err)
+		return false
+	}
+	// Once we've started printing a file, we finish it even if we're asked
+	// to stop, since we can't pick up where we left off and would print it
+	// all again next time.
+	err = scanFormat(context.WithoutCancel(ctx), stats.countReader(f),
+		input.Format, fileJobName(name), input, output, handler, inputName)
+	f.Close()
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't print spool file `%s`: %v",
+			inputName, name, err)
+		stats.setError(err)
+		return false
+	}
+
+	if input.SpoolArchiveDir == "" {
+		err = os.Remove(path)
+	} else {
+		err = os.Rename(path, archivePath(input.SpoolArchiveDir, name))
+	}
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't clean up spool file `%s`: %v",
+			inputName, name, err)
+		stats.setError(err)
+		return false
+	}
+	return true
+}
+
+// followSpoolFile prints what has been added to the file name in the spool
+// directory since we last printed it, and leaves the file in place. Returns
+// false if the file couldn't be printed.
+func followSpoolFile(ctx context.Context, input InputConfig,
+	output OutputConfig, handler scanner.PrinterHandler, stats *jobStats,
+	inputName, name string, offsets map[string]int64) bool {
+
+	offset, ok := offsets[name]
+	if !ok {
+		offset = readSpoolOffset(input.SpoolDir, name)
+		offsets[name] = offset
+	}
+
+	path := filepath.Join(input.SpoolDir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		stats.setError(err)
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		stats.setError(err)
+		return false
+	}
+	size := info.Size()
+	if size < offset {
+		// The file was truncated or replaced, so it's all new.
+		offset = 0
+	}
+	if size == offset {
+		return true
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("ERROR: [%s] %v", inputName, err)
+		stats.setError(err)
+		return false
+	}
+
+	log.Printf("INFO:  [%s] printing %d new bytes of spool file `%s`",
+		inputName, size-offset, name)
+	// Anything written while we're printing waits for the next time the
+	// file goes quiet.
+	err = scanFormat(context.WithoutCancel(ctx),
+		stats.countReader(io.LimitReader(f, size-offset)), input.Format,
+		fileJobName(name), input, output, handler, inputName)
+	if err != nil {
+		log.Printf("ERROR: [%s] couldn't print spool file `%s`: %v",
+			inputName, name, err)
+		stats.setError(err)
+		return false
+	}
+
+	offsets[name] = size
+	if err := writeSpoolOffset(input.SpoolDir, name, size); err != nil {
+		log.Printf("ERROR: [%s] couldn't save how much of spool file `%s` "+
+			"was printed: %v", inputName, name, err)
+		stats.setError(err)
+	}
+	return true
+}
+
+// spoolOffsetPath returns the path of the file that keeps how much of the
+// file name in the spool directory dir we've printed.
+func spoolOffsetPath(dir, name string) string {
+	return filepath.Join(dir, spoolOffsetPrefix+name)
+}
+
+// readSpoolOffset returns how much of the file name in the spool directory
+// dir we've printed, or 0 if we haven't printed any of it.
+func readSpoolOffset(dir, name string) int64 {
+	data, err := os.ReadFile(spoolOffsetPath(dir, name))
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// writeSpoolOffset saves how much of the file name in the spool directory
+// dir we've printed.
+func writeSpoolOffset(dir, name string, offset int64) error {
+	f, err := os.CreateTemp(dir, ".v1403-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n", offset)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), spoolOffsetPath(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// removeStaleSpoolOffsets removes the offsets kept for files that are no
+// longer in the spool directory dir, so that a new file with the same name
+// is printed from the start.
+func removeStaleSpoolOffsets(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), spoolOffsetPrefix)
+		if !ok {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, name)); errors.Is(err,
+			os.ErrNotExist) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// archivePath returns the path to move a file named name to in the archive
+// directory dir. If a file with the same name was already archived,
+// we add a timestamp to the name so that we don't overwrite it, since
+// programs often use the same name for every file.
+func archivePath(dir, name string) string {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+	return filepath.Join(dir,
+		fmt.Sprintf("%s.%s", name, time.Now().Format("20060102T150405.000")))
+}
@@ -0,0 +1,485 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The status server is an optional HTTP server that lets monitoring tools
+// see what the agent is doing: /healthz answers as long as the agent is
+// running, /status is a JSON document with the state and counters of each
+// input and output, and /metrics has the same counters for Prometheus.
+
+// StatusConfig is the configuration of the status server.
+type StatusConfig struct {
+	ListenAddress  string   `yaml:"listen_address"`
+	AllowedClients []string `yaml:"allowed_clients"`
+	allowed        []*net.IPNet
+}
+
+func validateStatusConfig(config StatusConfig) []error {
+	var errs []error
+
+	if config.ListenAddress == "" && len(config.AllowedClients) > 0 {
+		errs = append(errs,
+			errors.New("status 'allowed_clients' requires 'listen_address'"))
+	}
+	if _, err := parseAllowedClients(config.AllowedClients); err != nil {
+		errs = append(errs, fmt.Errorf("status: %v", err))
+	}
+
+	return errs
+}
+
+// Input connection states.
+const (
+	stateStarting     = "starting"
+	stateConnected    = "connected"
+	stateDisconnected = "disconnected"
+	stateListening    = "listening"
+	stateWatching     = "watching"
+	stateStopped      = "stopped"
+)
+
+// setState records the input's connection state.
+func (s *jobStats) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// reconnecting counts another attempt to connect to Hercules again.
+func (s *jobStats) reconnecting() {
+	s.mu.Lock()
+	s.reconnects++
+	s.mu.Unlock()
+}
+
+// setError records the last error the input had.
+func (s *jobStats) setError(err error) {
+	s.mu.Lock()
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+	s.mu.Unlock()
+}
+
+// startJob counts a job in progress, until endJob is called.
+func (s *jobStats) startJob() {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+}
+
+// endJob counts a finished job.
+func (s *jobStats) endJob(lines, pages int) {
+	s.mu.Lock()
+	if s.active > 0 {
+		s.active--
+	}
+	s.jobs++
+	s.lines += lines
+	s.pages += pages
+	s.lastJob = time.Now()
+	s.mu.Unlock()
+}
+
+// idle returns true if the input has no jobs in progress and hasn't
+// received anything for at least d.
+func (s *jobStats) idle(d time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active == 0 && time.Since(s.lastRead) >= d
+}
+
+// received records that the input received n bytes.
+func (s *jobStats) received(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.bytes += int64(n)
+	s.lastRead = time.Now()
+	s.mu.Unlock()
+}
+
+// countReader returns a reader that counts the bytes read from r.
+func (s *jobStats) countReader(r io.Reader) io.Reader {
+	return &countingReader{Reader: r, stats: s}
+}
+
+type countingReader struct {
+	io.Reader
+	stats *jobStats
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.stats.received(n)
+	return n, err
+}
+
+// countConn returns a connection that counts the bytes read from conn.
+func (s *jobStats) countConn(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, stats: s}
+}
+
+type countingConn struct {
+	net.Conn
+	stats *jobStats
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.received(n)
+	return n, err
+}
+
+// watchConn returns a connection that records when the input last received
+// anything from conn, without counting the bytes.
+func (s *jobStats) watchConn(conn net.Conn) net.Conn {
+	return &watchedConn{Conn: conn, stats: s}
+}
+
+type watchedConn struct {
+	net.Conn
+	stats *jobStats
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.stats.mu.Lock()
+		c.stats.lastRead = time.Now()
+		c.stats.mu.Unlock()
+	}
+	return n, err
+}
+
+// outputStats counts the jobs an output wrote or delivered. Handlers for
+// outputs that aren't being watched (-printfile and -replay) have none, so
+// the methods do nothing when s is nil.
+type outputStats struct {
+	mu       sync.Mutex
+	jobs     int
+	failures int
+	retries  int
+	pages    int
+
+	lastJob       time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// endJob counts a job the output finished, which failed if err isn't nil.
+func (s *outputStats) endJob(pages int, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastJob = time.Now()
+	if err != nil {
+		s.failures++
+		s.lastError = err.Error()
+		s.lastErrorTime = s.lastJob
+		return
+	}
+	s.jobs++
+	s.pages += pages
+}
+
+// retry counts an attempt to deliver a spooled job that failed with err
+// and will be tried again. The job itself is counted by endJob once it's
+// delivered or given up on.
+func (s *outputStats) retry(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// inputStatus and outputStatus are how we report inputs and outputs in
+// the /status document.
+type inputStatus struct {
+	Type          string     `json:"type"`
+	Output        string     `json:"output"`
+	State         string     `json:"state"`
+	Reconnects    int        `json:"reconnects"`
+	Jobs          int        `json:"jobs"`
+	Lines         int        `json:"lines"`
+	Pages         int        `json:"pages"`
+	Bytes         int64      `json:"bytes"`
+	LastJob       *time.Time `json:"last_job,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+type outputStatus struct {
+	Mode          string     `json:"mode"`
+	Jobs          int        `json:"jobs"`
+	Failures      int        `json:"failures"`
+	Retries       int        `json:"retries"`
+	Pages         int        `json:"pages"`
+	Spooled       *int       `json:"spooled,omitempty"`
+	LastJob       *time.Time `json:"last_job,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+type agentStatus struct {
+	Version string                  `json:"version"`
+	Started time.Time               `json:"started"`
+	Inputs  map[string]inputStatus  `json:"inputs"`
+	Outputs map[string]outputStatus `json:"outputs"`
+}
+
+// optionalTime returns a pointer to t, or nil if t is zero, so that times
+// that never happened are left out of the status document.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// statusServer serves the agent's status.
+type statusServer struct {
+	sup *supervisor
+}
+
+// status takes a snapshot of the agent's status.
+func (s *statusServer) status() agentStatus {
+	inputs, outputs, allStats := s.sup.snapshot()
+	st := agentStatus{
+		Version: version,
+		Started: s.sup.started,
+		Inputs:  make(map[string]inputStatus),
+		Outputs: make(map[string]outputStatus),
+	}
+
+	for name, stats := range allStats {
+		stats.mu.Lock()
+		st.Inputs[name] = inputStatus{
+			Type:          inputType(inputs[name]),
+			Output:        inputs[name].Output,
+			State:         stats.state,
+			Reconnects:    stats.reconnects,
+			Jobs:          stats.jobs,
+			Lines:         stats.lines,
+			Pages:         stats.pages,
+			Bytes:         stats.bytes,
+			LastJob:       optionalTime(stats.lastJob),
+			LastError:     stats.lastError,
+			LastErrorTime: optionalTime(stats.lastErrorTime),
+		}
+		stats.mu.Unlock()
+	}
+
+	for name, o := range outputs {
+		stats := o.stats
+		stats.mu.Lock()
+		out := outputStatus{
+			Mode:          o.Mode,
+			Jobs:          stats.jobs,
+			Failures:      stats.failures,
+			Retries:       stats.retries,
+			Pages:         stats.pages,
+			LastJob:       optionalTime(stats.lastJob),
+			LastError:     stats.lastError,
+			LastErrorTime: optionalTime(stats.lastErrorTime),
+		}
+		stats.mu.Unlock()
+		if o.spool != nil {
+			if jobs, err := o.spool.list(); err == nil {
+				n := len(jobs)
+				out.Spooled = &n
+			}
+		}
+		st.Outputs[name] = out
+	}
+
+	return st
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/healthz":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "ok\n")
+	case "/status":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.status())
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s.status())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeMetrics writes the agent's status in the Prometheus text format.
+func writeMetrics(w io.Writer, st agentStatus) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP virtual1403_%s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE virtual1403_%s %s\n", name, kind)
+	}
+	value := func(name, label, labelValue string, v float64) {
+		fmt.Fprintf(w, "virtual1403_%s{%s=%q} %g\n", name, label,
+			labelValue, v)
+	}
+	timestamp := func(t *time.Time) float64 {
+		if t == nil {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+
+	inputs := sortedKeys(st.Inputs)
+	outputs := sortedKeys(st.Outputs)
+
+	metric("input_up", "gauge",
+		"Whether the input is connected to Hercules or listening.")
+	for _, name := range inputs {
+		up := 0.0
+		switch st.Inputs[name].State {
+		case stateConnected, stateListening, stateWatching:
+			up = 1
+		}
+		value("input_up", "input", name, up)
+	}
+	for _, m := range []struct {
+		name, kind, help string
+		get              func(inputStatus) float64
+	}{
+		{"input_reconnects_total", "counter",
+			"Times the input has reconnected to Hercules.",
+			func(i inputStatus) float64 { return float64(i.Reconnects) }},
+		{"input_jobs_total", "counter", "Jobs received by the input.",
+			func(i inputStatus) float64 { return float64(i.Jobs) }},
+		{"input_lines_total", "counter", "Lines received by the input.",
+			func(i inputStatus) float64 { return float64(i.Lines) }},
+		{"input_pages_total", "counter", "Pages received by the input.",
+			func(i inputStatus) float64 { return float64(i.Pages) }},
+		{"input_bytes_total", "counter", "Bytes received by the input.",
+			func(i inputStatus) float64 { return float64(i.Bytes) }},
+		{"input_last_job_timestamp_seconds", "gauge",
+			"Time the input's last job ended.",
+			func(i inputStatus) float64 { return timestamp(i.LastJob) }},
+	} {
+		metric(m.name, m.kind, m.help)
+		for _, name := range inputs {
+			value(m.name, "input", name, m.get(st.Inputs[name]))
+		}
+	}
+
+	for _, m := range []struct {
+		name, kind, help string
+		get              func(outputStatus) float64
+	}{
+		{"output_jobs_total", "counter",
+			"Jobs written or delivered by the output.",
+			func(o outputStatus) float64 { return float64(o.Jobs) }},
+		{"output_failures_total", "counter",
+			"Jobs the output failed to write or deliver.",
+			func(o outputStatus) float64 { return float64(o.Failures) }},
+		{"output_retries_total", "counter",
+			"Failed attempts to deliver jobs that will be retried.",
+			func(o outputStatus) float64 { return float64(o.Retries) }},
+		{"output_pages_total", "counter",
+			"Pages of PDFs written by the output.",
+			func(o outputStatus) float64 { return float64(o.Pages) }},
+		{"output_last_job_timestamp_seconds", "gauge",
+			"Time the output's last job ended.",
+			func(o outputStatus) float64 { return timestamp(o.LastJob) }},
+	} {
+		metric(m.name, m.kind, m.help)
+		for _, name := range outputs {
+			value(m.name, "output", name, m.get(st.Outputs[name]))
+		}
+	}
+
+	metric("output_spooled_jobs", "gauge",
+		"Jobs waiting in the output's online spool.")
+	for _, name := range outputs {
+		if n := st.Outputs[name].Spooled; n != nil {
+			value("output_spooled_jobs", "output", name, float64(*n))
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runStatusServer serves the agent's status on the status listen_address
+// until ctx is done.
+func runStatusServer(ctx context.Context, config StatusConfig,
+	sup *supervisor, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	s := &statusServer{sup: sup}
+
+	// The status server uses the same listener as the inputs, so it accepts
+	// unix sockets and allowed_clients too.
+	l, err := listen(ctx, InputConfig{ListenAddress: config.ListenAddress,
+		allowed: config.allowed}, "status", nil)
+	if err != nil {
+		log.Printf("ERROR: [status] couldn't listen: %v", err)
+		return
+	}
+
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: lpdTimeout}
+	stop := context.AfterFunc(ctx, func() {
+		srv.Close()
+	})
+	defer stop()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("ERROR: [status] %v", err)
+	}
+}
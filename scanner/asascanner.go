@@ -20,8 +20,10 @@ package scanner
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"log"
+	"time"
 	"unicode/utf8"
 )
 
@@ -29,15 +31,28 @@ import (
 // prints the entire contents to the handler. No job separation is attempted.
 // The input file is assumed to be UTF-8 (compatible with US-ASCII) encoded,
 // with the first character of each line being an ASA carriage control
-// instructions (' ', '1', '0', '-', and '+' are supported).
+// instructions (' ', '1', '0', '-', '+', and the channel skips '2'-'9' and
+// 'A'-'C' are supported).
 func ScanASAUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 	trace bool) error {
 
+	return ScanASAUTF8SingleContext(context.Background(), r, jobname, handler,
+		trace)
+}
+
+// ScanASAUTF8SingleContext is ScanASAUTF8Single, but stops reading when ctx
+// is done. Whatever was read before then is sent to the handler as a
+// complete job, and ctx.Err() is returned.
+func ScanASAUTF8SingleContext(ctx context.Context, r io.Reader,
+	jobname string, handler PrinterHandler, trace bool) error {
+
+	started := time.Now()
+
 	linenum := 0
 	var prevline string
 	scanner := bufio.NewScanner(r)
 
-	for scanner.Scan() {
+	for ctx.Err() == nil && scanner.Scan() {
 		linenum++
 		line := scanner.Text()
 		if len(line) == 0 {
@@ -81,6 +96,10 @@ func ScanASAUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 				handler.AddLine("", true)
 				handler.AddLine("", true)
 			default:
+				if channel, ok := asaChannel(control); ok {
+					handler.SkipToChannel(channel)
+					break
+				}
 				log.Printf("ERROR: unknown/unimplemented control "+
 					"character '%s' on line %d", string(control), linenum)
 			}
@@ -109,6 +128,10 @@ func ScanASAUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 			handler.AddLine(prevline, false)
 		default:
 			handler.AddLine(prevline, true)
+			if channel, ok := asaChannel(control); ok {
+				handler.SkipToChannel(channel)
+				break
+			}
 			log.Printf("ERROR: unknown/unimplemented control "+
 				"character '%s' on line %d", string(control), linenum)
 		}
@@ -118,14 +141,29 @@ func ScanASAUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 		prevline = rest
 	}
 
-	if err := scanner.Err(); err != nil {
+	// A read that failed because ctx is done (e.g. an interrupted network
+	// connection) still finishes the job.
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
 		return err
 	}
 
 	// We always need to finish by writing the last line in the prevline
 	// buffer
 	handler.AddLine(prevline, true)
-	handler.EndOfJob(jobname)
+	handler.EndOfJob(fileJob(jobname, started))
+
+	return ctx.Err()
+}
 
-	return nil
+// asaChannel returns the carriage control channel that an ASA control
+// character skips to, for the characters '2'-'9' (channels 2-9) and 'A'-'C'
+// (channels 10-12). Channel 1 is a page break and is handled separately.
+func asaChannel(control rune) (int, bool) {
+	switch {
+	case control >= '2' && control <= '9':
+		return int(control - '0'), true
+	case control >= 'A' && control <= 'C':
+		return int(control-'A') + 10, true
+	}
+	return 0, false
 }
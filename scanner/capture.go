@@ -0,0 +1,266 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// A capture file records the raw bytes received from Hercules along with
+// the timing between them, so that a print data stream can be replayed
+// through the scanner with the same job separation as the original, read
+// timeouts included.
+//
+// The file begins with captureMagic, followed by any number of records. Each
+// record is the delay (in microseconds) since the previous record as a
+// uvarint, the length of the data as a uvarint, then the data. Bytes arriving
+// in quick succession are coalesced into a single record. The last record
+// has no data, and records how long the connection was idle before it was
+// closed, so that a job ended by a read timeout at the end of the capture is
+// ended when it is replayed, too.
+
+const captureMagic = "V1403CAP\x01"
+
+// coalesceGap is the largest gap between reads for which we will append the
+// data to the current record rather than starting a new one. It is much
+// smaller than the scanner's read timeout, so coalescing can't change how
+// the stream is split into jobs.
+const coalesceGap = 10 * time.Millisecond
+
+// maxRecordLen is the largest amount of data we will put in one record, at
+// least when reading a byte at a time like the scanner does. We will read
+// records up to maxCaptureRecordLen from a capture file.
+const (
+	maxRecordLen        = 4096
+	maxCaptureRecordLen = 1 << 24
+)
+
+// recordingConn is a net.Conn that records everything read from it to a
+// capture file.
+type recordingConn struct {
+	net.Conn
+	w        *bufio.Writer
+	last     time.Time
+	pending  []byte
+	delay    time.Duration
+	writeErr error
+	closed   bool
+}
+
+// NewRecordingConn wraps conn so that all data read from it is also written
+// to w in the capture file format. Closing the returned connection flushes
+// the capture data to w, but does not close w.
+func NewRecordingConn(conn net.Conn, w io.Writer) (net.Conn, error) {
+	c := &recordingConn{
+		Conn: conn,
+		w:    bufio.NewWriter(w),
+		last: time.Now(),
+	}
+	if _, err := c.w.WriteString(captureMagic); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		now := time.Now()
+		gap := now.Sub(c.last)
+		c.last = now
+		if len(c.pending) > 0 &&
+			(gap > coalesceGap || len(c.pending) >= maxRecordLen) {
+			c.flushRecord()
+		}
+		if len(c.pending) == 0 {
+			c.delay = gap
+		}
+		c.pending = append(c.pending, b[:n]...)
+	}
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		// The connection is going away, so save what we have.
+		c.flushRecord()
+		c.flush()
+	}
+	return n, err
+}
+
+// flushRecord writes the pending data as a capture record. Once we get an
+// error writing the capture, we stop trying, but the connection carries on.
+func (c *recordingConn) flushRecord() {
+	if len(c.pending) == 0 || c.writeErr != nil {
+		c.pending = c.pending[:0]
+		return
+	}
+	var hdr [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(c.delay.Microseconds()))
+	n += binary.PutUvarint(hdr[n:], uint64(len(c.pending)))
+	if _, err := c.w.Write(hdr[:n]); err != nil {
+		c.writeErr = err
+	} else if _, err := c.w.Write(c.pending); err != nil {
+		c.writeErr = err
+	}
+	c.pending = c.pending[:0]
+}
+
+func (c *recordingConn) flush() {
+	if c.writeErr == nil {
+		c.writeErr = c.w.Flush()
+	}
+}
+
+// Close flushes the capture data, ending it with the time since the last
+// data arrived, and closes the underlying connection. If there was an error
+// writing the capture data, Close will return it.
+func (c *recordingConn) Close() error {
+	c.flushRecord()
+	if !c.closed && c.writeErr == nil {
+		var hdr [2 * binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(hdr[:],
+			uint64(time.Since(c.last).Microseconds()))
+		n += binary.PutUvarint(hdr[n:], 0)
+		_, c.writeErr = c.w.Write(hdr[:n])
+	}
+	c.closed = true
+	c.flush()
+	err := c.Conn.Close()
+	if c.writeErr != nil {
+		return fmt.Errorf("error writing capture: %v", c.writeErr)
+	}
+	return err
+}
+
+// replayConn is a net.Conn that plays back a capture file. Rather than
+// actually waiting for the recorded delays, it keeps track of the read
+// deadline the reader sets and returns a timeout immediately when the
+// recorded delay is longer than the deadline allows. Replays therefore run
+// as fast as we can read the capture, but split jobs exactly the same way
+// they were split when the capture was recorded.
+type replayConn struct {
+	r       *bufio.Reader
+	data    []byte
+	delay   time.Duration
+	timeout time.Duration
+	hasData bool
+}
+
+// NewReplayConn returns a net.Conn that reads data from the capture file in
+// r. Writes to the connection fail. The connection returns io.EOF at the
+// end of the capture.
+func NewReplayConn(r io.Reader) (net.Conn, error) {
+	c := &replayConn{r: bufio.NewReader(r)}
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(c.r, magic); err != nil ||
+		string(magic) != captureMagic {
+		return nil, errors.New("not a virtual1403 capture file")
+	}
+	return c, nil
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	for {
+		if !c.hasData {
+			if err := c.nextRecord(); err != nil {
+				return 0, err
+			}
+		}
+
+		// If the reader wouldn't have waited as long as the data took to
+		// arrive, it gets a timeout, and has spent the timeout's worth of
+		// the delay waiting.
+		if c.delay > 0 && c.timeout > 0 && c.delay >= c.timeout {
+			c.delay -= c.timeout
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.delay = 0
+
+		// A record with no data is only a delay, like the idle time at
+		// the end of the capture.
+		if len(c.data) > 0 {
+			break
+		}
+		c.hasData = false
+	}
+
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	if len(c.data) == 0 {
+		c.hasData = false
+	}
+	return n, nil
+}
+
+func (c *replayConn) nextRecord() error {
+	delay, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return err
+	}
+	length, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if length > maxCaptureRecordLen {
+		return fmt.Errorf("capture record length %d is invalid", length)
+	}
+	c.data = make([]byte, length)
+	if _, err := io.ReadFull(c.r, c.data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	c.delay = time.Duration(delay) * time.Microsecond
+	c.hasData = true
+	return nil
+}
+
+func (c *replayConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.timeout = 0
+	} else {
+		c.timeout = time.Until(t)
+	}
+	return nil
+}
+
+func (c *replayConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	return 0, errors.New("can't write to a capture replay")
+}
+
+func (c *replayConn) Close() error { return nil }
+
+func (c *replayConn) LocalAddr() net.Addr { return replayAddr{} }
+
+func (c *replayConn) RemoteAddr() net.Addr { return replayAddr{} }
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+
+func (replayAddr) String() string { return "replay" }
@@ -0,0 +1,127 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	const data = "HELLO\nWORLD\n\n"
+
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte(data))
+		client.Close()
+	}()
+
+	var capture bytes.Buffer
+	rc, err := NewRecordingConn(server, &capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if string(got) != data {
+		t.Errorf("recording connection returned %q, want %q", got, data)
+	}
+
+	replay, err := NewReplayConn(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("replay returned %q, want %q", got, data)
+	}
+}
+
+func TestReplayTimeout(t *testing.T) {
+	// Two jobs, the second arriving a second after the first.
+	var capture bytes.Buffer
+	capture.WriteString(captureMagic)
+	for _, rec := range []struct {
+		delay uint64
+		data  string
+	}{
+		{0, "JOB ONE\n"},
+		{1000000, "JOB TWO\n"},
+	} {
+		capture.Write(binary.AppendUvarint(nil, rec.delay))
+		capture.Write(binary.AppendUvarint(nil, uint64(len(rec.data))))
+		capture.WriteString(rec.data)
+	}
+
+	conn, err := NewReplayConn(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h recordingHandler
+	if err := ScanWithOptions(conn, &h, Options{Tag: "test"}); err != io.EOF {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	checkEvents(t, h.events, []string{"L:JOB ONE", "J:"})
+}
+
+func TestCaptureReplayEndsJobAtTimeout(t *testing.T) {
+	// The job is only ended by the read timeout after the data, so the
+	// capture must record the idle time before the connection was closed.
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("HELLO\r\nWORLD\r\n"))
+		time.Sleep(700 * time.Millisecond)
+		client.Close()
+	}()
+
+	var capture bytes.Buffer
+	rc, err := NewRecordingConn(server, &capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var live recordingHandler
+	err = ScanWithOptions(rc, &live, Options{Tag: "test"})
+	if err != io.EOF {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	rc.Close()
+	want := []string{"L:HELLO", "L:WORLD", "L:", "J:"}
+	checkEvents(t, live.events, want)
+
+	replay, err := NewReplayConn(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h recordingHandler
+	err = ScanWithOptions(replay, &h, Options{Tag: "test"})
+	if err != io.EOF {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	checkEvents(t, h.events, want)
+}
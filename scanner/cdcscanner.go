@@ -21,8 +21,10 @@ package scanner
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"log"
+	"time"
 	"unicode/utf8"
 )
 
@@ -38,6 +40,18 @@ import (
 func ScanCDCUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 	trace bool) error {
 
+	return ScanCDCUTF8SingleContext(context.Background(), r, jobname, handler,
+		trace)
+}
+
+// ScanCDCUTF8SingleContext is ScanCDCUTF8Single, but stops reading when ctx
+// is done. Whatever was read before then is sent to the handler as a
+// complete job, and ctx.Err() is returned.
+func ScanCDCUTF8SingleContext(ctx context.Context, r io.Reader,
+	jobname string, handler PrinterHandler, trace bool) error {
+
+	started := time.Now()
+
 	linenum := 0
     formline := 0 //line number of current page
 	var prevline string
@@ -58,7 +72,7 @@ func ScanCDCUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
         return
     }
 
-	for scanner.Scan() {
+	for ctx.Err() == nil && scanner.Scan() {
 		linenum++
         formline++
         if(formline > 66) {
@@ -135,9 +149,10 @@ func ScanCDCUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 			handler.AddLine(prevline, true)
 			handler.AddLine("", true)
             formline++
-        case '2': //skip to end of form -2 lines
+        case '2': //skip to end of form (channel 12)
 			handler.AddLine(prevline, true)
-            jumptoline(64)
+            handler.SkipToChannel(12)
+            formline = 64
         case '3': //page eject
             if(linenum > 2) { //ignore page eject on first page
             handler.AddLine(prevline,true)
@@ -173,14 +188,14 @@ func ScanCDCUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 		prevline = rest
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
 		return err
 	}
 
 	// We always need to finish by writing the last line in the prevline
 	// buffer
 	handler.AddLine(prevline, true)
-	handler.EndOfJob(jobname)
+	handler.EndOfJob(fileJob(jobname, started))
 
-	return nil
+	return ctx.Err()
 }
@@ -0,0 +1,239 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Hercules translates EBCDIC printer output to an 8-bit host character set
+// before sending it to a sockdev printer, using the tables in its codepage.c
+// that are selected with the CODEPAGE command. Each Hercules codepage is a
+// pair of host and guest code pages, named "host/guest" (e.g. "437/037"),
+// and the names without a host part use ISO-8859-1 (code page 819) on the
+// host side. To turn what Hercules sends us back into Unicode, we only need
+// to know the host half of the pair.
+
+// noMap marks a byte that has no Unicode mapping in a codepage table.
+const noMap rune = -1
+
+// Codepage translates bytes received from Hercules into Unicode.
+type Codepage struct {
+	name  string
+	table *[256]rune
+}
+
+// Name returns the Hercules name of the codepage.
+func (cp *Codepage) Name() string {
+	return cp.name
+}
+
+// Rune returns the Unicode character for b. ok is false if b has no mapping
+// in this codepage.
+func (cp *Codepage) Rune(b byte) (r rune, ok bool) {
+	r = cp.table[b]
+	return r, r != noMap
+}
+
+// Byte returns the byte for r in this codepage, the reverse of Rune. ok is
+// false if no byte maps to r.
+func (cp *Codepage) Byte(r rune) (b byte, ok bool) {
+	if r == noMap {
+		return 0, false
+	}
+	for i, c := range cp.table {
+		if c == r {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
+
+// Decode translates b to a UTF-8 string, handling bytes without a mapping
+// according to policy.
+func (cp *Codepage) Decode(b []byte, policy UnmappablePolicy) string {
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if r, ok := cp.Rune(c); ok {
+			runes = append(runes, r)
+		} else {
+			runes = policy.appendRunes(runes, c)
+		}
+	}
+	return string(runes)
+}
+
+// decodingReader translates the bytes read from another reader to UTF-8.
+type decodingReader struct {
+	r        io.Reader
+	codepage *Codepage
+	policy   UnmappablePolicy
+	in       [4096]byte
+	out      []byte
+}
+
+// NewDecodingReader returns an io.Reader that reads from r and translates
+// what it reads from codepage to UTF-8, handling bytes without a mapping
+// according to policy.
+func NewDecodingReader(r io.Reader, codepage *Codepage,
+	policy UnmappablePolicy) io.Reader {
+
+	return &decodingReader{r: r, codepage: codepage, policy: policy}
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if len(d.out) == 0 {
+		n, err := d.r.Read(d.in[:])
+		if n == 0 {
+			return 0, err
+		}
+		d.out = []byte(d.codepage.Decode(d.in[:n], d.policy))
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// hercDefaultTable is the host side of the Hercules "default" codepage. This
+// is US-ASCII, except that the EBCDIC not sign arrives as 0x5E, and a handful
+// of other characters are moved to the upper half. Hercules doesn't map any
+// other byte above 0x7F to a printable character.
+var hercDefaultTable = func() *[256]rune {
+	var t [256]rune
+	for i := range t {
+		if i < 0x80 {
+			t[i] = rune(i)
+		} else {
+			t[i] = noMap
+		}
+	}
+	t[0x5e] = '¬'
+	t[0x9b] = '^'
+	t[0x9f] = '©'
+	t[0xa6] = '¦'
+	t[0xd6] = '¢'
+	t[0xd7] = '|'
+	return &t
+}()
+
+// latin1Table is ISO-8859-1 (code page 819). The C1 control characters have
+// no business in printer output, so we treat them as unmappable.
+var latin1Table = func() *[256]rune {
+	var t [256]rune
+	for i := range t {
+		if i >= 0x80 && i < 0xa0 {
+			t[i] = noMap
+		} else {
+			t[i] = rune(i)
+		}
+	}
+	return &t
+}()
+
+// hostTables maps the host code page numbers used in Hercules codepage
+// names to our translation tables.
+var hostTables = map[string]*[256]rune{
+	"437":  &cp437Table,
+	"819":  latin1Table,
+	"850":  &cp850Table,
+	"1252": &cp1252Table,
+}
+
+// guestCodepages are the EBCDIC code pages Hercules knows about. The guest
+// side doesn't change our translation, but we check it so that typos in a
+// configuration file are caught.
+var guestCodepages = map[string]bool{
+	"037": true, "037v": true, "273": true, "277": true, "278": true,
+	"280": true, "284": true, "285": true, "297": true, "500": true,
+	"1047": true, "1140": true, "1141": true, "1142": true, "1143": true,
+	"1144": true, "1145": true, "1146": true, "1147": true, "1148": true,
+}
+
+// DefaultCodepage is the Hercules "default" codepage, which is what Hercules
+// uses unless configured otherwise.
+var DefaultCodepage = &Codepage{name: "default", table: hercDefaultTable}
+
+// LookupCodepage returns the Codepage for a Hercules codepage name, such as
+// "default", "037", "819/1047", or "437/500". An empty name returns
+// DefaultCodepage.
+func LookupCodepage(name string) (*Codepage, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "default" {
+		return DefaultCodepage, nil
+	}
+
+	host, guest, found := strings.Cut(name, "/")
+	if !found {
+		// Without a host part, Hercules uses ISO-8859-1, except for the
+		// Euro-enabled code pages which need Windows-1252 for the € sign.
+		host, guest = "819", name
+		if len(guest) == 4 && guest[0:2] == "11" {
+			host = "1252"
+		}
+	}
+
+	table, ok := hostTables[host]
+	if !ok || !guestCodepages[guest] {
+		return nil, fmt.Errorf("unknown codepage `%s`", name)
+	}
+	return &Codepage{name: name, table: table}, nil
+}
+
+// UnmappablePolicy determines what we do with bytes that have no mapping in
+// the codepage.
+type UnmappablePolicy int
+
+const (
+	// UnmappableReplace replaces the byte with a question mark.
+	UnmappableReplace UnmappablePolicy = iota
+	// UnmappableDrop removes the byte from the line.
+	UnmappableDrop
+	// UnmappableHex replaces the byte with its value as a \xNN hex escape.
+	UnmappableHex
+)
+
+// ParseUnmappablePolicy returns the UnmappablePolicy for the configuration
+// values "replace", "drop", and "hex". An empty string is UnmappableReplace.
+func ParseUnmappablePolicy(s string) (UnmappablePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "replace":
+		return UnmappableReplace, nil
+	case "drop":
+		return UnmappableDrop, nil
+	case "hex":
+		return UnmappableHex, nil
+	default:
+		return UnmappableReplace, fmt.Errorf(
+			"unknown unmappable character policy `%s`; must be one of "+
+				"replace, drop, hex", s)
+	}
+}
+
+func (p UnmappablePolicy) appendRunes(dst []rune, b byte) []rune {
+	switch p {
+	case UnmappableDrop:
+		return dst
+	case UnmappableHex:
+		return append(dst, []rune(fmt.Sprintf("\\x%02X", b))...)
+	default:
+		return append(dst, '?')
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCodepageDecode(t *testing.T) {
+	type testcase struct {
+		codepage string
+		policy   UnmappablePolicy
+		input    []byte
+		output   string
+	}
+	var testcases []testcase = []testcase{
+		{"default", UnmappableReplace, []byte("A^B\xd6"), "A¬B¢"},
+		{"default", UnmappableReplace, []byte("X\xe0Y"), "X?Y"},
+		{"default", UnmappableDrop, []byte("X\xe0Y"), "XY"},
+		{"default", UnmappableHex, []byte("X\xe0Y"), `X\xE0Y`},
+		{"1047", UnmappableReplace, []byte("\xe9t\xe9"), "été"},
+		{"819/037", UnmappableReplace, []byte("\x85"), "?"},
+		{"437/037", UnmappableReplace, []byte("\x9b\xb3"), "¢│"},
+		{"1252/1140", UnmappableReplace, []byte("\x80"), "€"},
+	}
+
+	for _, c := range testcases {
+		cp, err := LookupCodepage(c.codepage)
+		if err != nil {
+			t.Errorf("couldn't look up codepage %s: %v", c.codepage, err)
+			continue
+		}
+		if output := cp.Decode(c.input, c.policy); output != c.output {
+			t.Errorf("Got `%s` instead of `%s` for input %x in %s", output,
+				c.output, c.input, c.codepage)
+		}
+	}
+}
+
+func TestUnknownCodepage(t *testing.T) {
+	for _, name := range []string{"999", "819/999", "123/037"} {
+		if _, err := LookupCodepage(name); err == nil {
+			t.Errorf("expected error for codepage %s", name)
+		}
+	}
+}
+
+func TestDecodingReader(t *testing.T) {
+	cp, err := LookupCodepage("819/1047")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewDecodingReader(strings.NewReader("CAF\xc9\n\x0c\x85"), cp,
+		UnmappableHex)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != "CAFÉ\n\f\\x85" {
+		t.Errorf("got %q", got)
+	}
+}
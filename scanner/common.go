@@ -18,14 +18,102 @@
 
 package scanner
 
+import (
+	"regexp"
+	"time"
+)
+
 // PrinterHandler interface receives the output of printer output parsing.
 type PrinterHandler interface {
 	AddLine(line string, linefeed bool)
 	PageBreak()
-	EndOfJob(jobinfo string)
+	// SkipToChannel advances the paper to the next line punched in the
+	// given carriage control channel (1-12).
+	SkipToChannel(channel int)
+	EndOfJob(job JobMetadata)
+}
+
+// JobMetadata describes a print job, as far as we were able to find out
+// from its separator pages (or, when printing files, from the file name).
+// Fields we don't know are empty.
+type JobMetadata struct {
+	Number     string // job number, e.g. "1234"
+	Name       string // job name
+	Type       string // JOB, STC, TSU, ...
+	Programmer string // programmer name field, or the submitting user
+	Room       string // room number
+	Class      string // output class
+
+	// Start and End come from the times printed on the job's separator
+	// pages when available. Otherwise, they are the times we began and
+	// finished receiving the job.
+	Start time.Time
+	End   time.Time
+}
+
+// jobInfoDisallowed are the characters that are not allowed in a job info
+// string.
+var jobInfoDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// JobInfo returns the short job identifier used in filenames and the online
+// print API: the first letter of the job type, followed by the number,
+// followed by an underscore and the name. For example, JES2's "JOB 1234
+// MYJOB" becomes J1234_MYJOB. Characters other than letters, digits, and
+// underscore are changed to underscores, and the result is at most 25
+// characters.
+func (m JobMetadata) JobInfo() string {
+	var jobinfo string
+	if m.Type != "" {
+		// get first letter, e.g. J(ob) or S(tc)
+		jobinfo = m.Type[0:1]
+	}
+	jobinfo = jobinfo + m.Number
+	if m.Name != "" {
+		if jobinfo != "" {
+			jobinfo = jobinfo + "_"
+		}
+		jobinfo = jobinfo + m.Name
+	}
+
+	jobinfo = jobInfoDisallowed.ReplaceAllString(jobinfo, "_")
+	if len(jobinfo) > 25 {
+		jobinfo = jobinfo[:25]
+	}
+	return jobinfo
+}
+
+// fileJob returns the job metadata for printing a file, which we only know
+// the name of.
+func fileJob(jobname string, started time.Time) JobMetadata {
+	return JobMetadata{Name: jobname, Start: started, End: time.Now()}
+}
+
+// merge fills in the fields of m with the non-empty fields of other.
+func (m *JobMetadata) merge(other JobMetadata) {
+	for _, f := range []struct{ dst, src *string }{
+		{&m.Number, &other.Number},
+		{&m.Name, &other.Name},
+		{&m.Type, &other.Type},
+		{&m.Programmer, &other.Programmer},
+		{&m.Room, &other.Room},
+		{&m.Class, &other.Class},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if !other.Start.IsZero() {
+		m.Start = other.Start
+	}
+	if !other.End.IsZero() {
+		m.End = other.End
+	}
 }
 
-const maxLineLen = 132
+// maxLineLen is the longest line the scanners will hold on to; any more
+// bytes on the line are discarded. It is longer than any printer's line
+// width so that long lines can be wrapped by NewLineWidthHandler.
+const maxLineLen = 256
 
 const (
 	charTab byte = 0x9
@@ -19,17 +19,23 @@
 /*
 Package scanner is a tiny combined lexer+parser that emits lines of printer
 output, page breaks, and end-of-job indications. It specifically handles
-reading from Hercules sockdev printer output of JES2 jobs in MVS 3.8J,
-although if there is a reliable way to detect the last line of a job (which
-must immediately be followed by a form feed character) with a regular
-expression, the code can be easily adapted for other operating systems.
+reading from Hercules sockdev printer output of JES2 jobs in MVS 3.8J, and
+other operating systems are supported through the EOJDetector interface. A
+detector may recognize the last line of a job (which must immediately be
+followed by a form feed character) or the first non-blank line of a page that
+starts a new job. Built-in detectors are provided for several systems, and
+NewRegexpEOJDetector supports anything else that can be matched with regular
+expressions.
 
 We tolerate a variety of combinations of CR, LF, and FF. A bare CR will cause
 the next line to overtype the current line. Bare LF, CR+LF, or LF+CR have the
 effect of CR+LF.
 
-Lines will be trimmed to 132 bytes; additional bytes on a line will be
-discarded.
+Lines are fit to the printer's line width (Options.LineWidth, 132 columns
+unless configured otherwise) by truncating or wrapping them, according to
+Options.Overflow; bytes beyond the first 256 on a line are always discarded.
+The bytes of each line are translated to Unicode using the host
+side of the Hercules codepage in use (see LookupCodepage).
 
 The implementation is a state machine that reads one byte at a time, updates
 internal state as necessary, performs actions (emit lines, pages, EOJ) as
@@ -65,8 +71,8 @@ in the dot language:
 		"emit line" -> "get next byte";
 		"get next byte" -> "add to current line";
 		"add to current line" [shape=box];
-		"add to current line" -> "dispose of bytes" [label="n>=132"];
-		"add to current line" -> "get next byte" [label="n<132"];
+		"add to current line" -> "dispose of bytes" [label="n>=256"];
+		"add to current line" -> "get next byte" [label="n<256"];
 		"dispose of bytes" -> "have lf" [label="b=lf"];
 		"dispose of bytes" -> "have cr" [label="b=cr"];
 		"dispose of bytes" -> "emit line and page" [label="b=ff"];
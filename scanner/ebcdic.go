@@ -0,0 +1,302 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Datasets transferred from MVS in binary keep their EBCDIC text and their
+// record structure. Fixed length records are simply concatenated. Variable
+// length records are each preceded by a record descriptor word (RDW): a
+// big-endian halfword with the length of the record including the RDW,
+// followed by a halfword of zeros. For blocked variable length records, each
+// block of records is also preceded by a block descriptor word (BDW) in the
+// same format, giving the length of the block including the BDW.
+
+// ebcdicTables maps the EBCDIC code page numbers we support to their
+// translation tables.
+var ebcdicTables = map[string]*[256]rune{
+	"037":  &cp037Table,
+	"273":  &cp273Table,
+	"500":  &cp500Table,
+	"1047": &cp1047Table,
+	"1140": &cp1140Table,
+}
+
+// LookupEBCDICCodepage returns the Codepage that translates the EBCDIC code
+// page with the given number (e.g. "037" or "1047") to Unicode. An empty
+// name returns code page 037.
+func LookupEBCDICCodepage(name string) (*Codepage, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "037"
+	}
+	table, ok := ebcdicTables[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown EBCDIC codepage `%s`; must be one "+
+			"of: 037, 273, 500, 1047, 1140", name)
+	}
+	return &Codepage{name: name, table: table}, nil
+}
+
+// RecordFormat is the record format (RECFM) of a dataset.
+type RecordFormat int
+
+const (
+	// RecordFormatF is fixed length records of LRECL bytes.
+	RecordFormatF RecordFormat = iota
+	// RecordFormatFB is blocked fixed length records, which read the same
+	// as RecordFormatF once transferred.
+	RecordFormatFB
+	// RecordFormatV is variable length records, each preceded by an RDW.
+	RecordFormatV
+	// RecordFormatVB is blocked variable length records: blocks preceded
+	// by a BDW, holding records preceded by an RDW.
+	RecordFormatVB
+)
+
+// CarriageControl is the kind of carriage control in the first byte of each
+// record, as given by the last letter of a RECFM.
+type CarriageControl int
+
+const (
+	// ControlNone means the records contain only text.
+	ControlNone CarriageControl = iota
+	// ControlASA means each record starts with an ASA control character.
+	ControlASA
+	// ControlMachine means each record starts with a machine control code.
+	ControlMachine
+)
+
+// ParseRecordFormat parses a RECFM such as "FB", "VBA", or "FBM". The
+// carriage control letter, if present, is returned as the CarriageControl.
+func ParseRecordFormat(s string) (RecordFormat, CarriageControl, error) {
+	recfm := strings.ToUpper(strings.TrimSpace(s))
+	control := ControlNone
+	switch {
+	case strings.HasSuffix(recfm, "A"):
+		control = ControlASA
+		recfm = recfm[:len(recfm)-1]
+	case strings.HasSuffix(recfm, "M"):
+		control = ControlMachine
+		recfm = recfm[:len(recfm)-1]
+	}
+
+	switch recfm {
+	case "F":
+		return RecordFormatF, control, nil
+	case "FB":
+		return RecordFormatFB, control, nil
+	case "V":
+		return RecordFormatV, control, nil
+	case "VB":
+		return RecordFormatVB, control, nil
+	}
+	return RecordFormatF, ControlNone, fmt.Errorf("unsupported RECFM `%s`; "+
+		"must be one of F, FB, V, VB, optionally followed by A or M", s)
+}
+
+// RecordReader reads the logical records of a dataset transferred in binary.
+type RecordReader struct {
+	r     *bufio.Reader
+	recfm RecordFormat
+	lrecl int
+
+	// block holds the remainder of the current block of VB records.
+	block []byte
+}
+
+// NewRecordReader returns a RecordReader for a dataset with the given RECFM
+// and LRECL. The LRECL is required for fixed length records. For variable
+// length records, it is the maximum record length including the RDW, and is
+// only checked if it is not 0.
+func NewRecordReader(r io.Reader, recfm RecordFormat,
+	lrecl int) (*RecordReader, error) {
+
+	if (recfm == RecordFormatF || recfm == RecordFormatFB) && lrecl <= 0 {
+		return nil, fmt.Errorf("an LRECL is required for fixed length " +
+			"records")
+	}
+	if lrecl < 0 || lrecl > 32760 {
+		return nil, fmt.Errorf("LRECL %d is invalid", lrecl)
+	}
+	return &RecordReader{r: bufio.NewReader(r), recfm: recfm,
+		lrecl: lrecl}, nil
+}
+
+// Next returns the next record, without its RDW. It returns io.EOF when
+// there are no more records. The returned slice is only valid until the
+// next call to Next.
+func (rr *RecordReader) Next() ([]byte, error) {
+	switch rr.recfm {
+	case RecordFormatV:
+		return rr.nextVariable()
+	case RecordFormatVB:
+		return rr.nextBlocked()
+	default:
+		return rr.nextFixed()
+	}
+}
+
+func (rr *RecordReader) nextFixed() ([]byte, error) {
+	rec := make([]byte, rr.lrecl)
+	n, err := io.ReadFull(rr.r, rec)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("last record is %d bytes, shorter than "+
+			"LRECL %d", n, rr.lrecl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (rr *RecordReader) nextVariable() ([]byte, error) {
+	var rdw [4]byte
+	if _, err := io.ReadFull(rr.r, rdw[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated RDW at end of file")
+		}
+		return nil, err
+	}
+	length, err := rr.descriptorLength(rdw[:], "RDW")
+	if err != nil {
+		return nil, err
+	}
+	rec := make([]byte, length-4)
+	if _, err := io.ReadFull(rr.r, rec); err != nil {
+		return nil, fmt.Errorf("truncated record at end of file")
+	}
+	return rec, nil
+}
+
+func (rr *RecordReader) nextBlocked() ([]byte, error) {
+	for len(rr.block) == 0 {
+		var bdw [4]byte
+		if _, err := io.ReadFull(rr.r, bdw[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("truncated BDW at end of file")
+			}
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(bdw[0:2]))
+		if bdw[0]&0x80 != 0 {
+			// Large block interface: a 31-bit length in the whole BDW.
+			length = int(binary.BigEndian.Uint32(bdw[:]) & 0x7fffffff)
+		} else if bdw[2] != 0 || bdw[3] != 0 {
+			return nil, fmt.Errorf("invalid BDW %x", bdw)
+		}
+		if length < 4 {
+			return nil, fmt.Errorf("invalid BDW %x", bdw)
+		}
+		rr.block = make([]byte, length-4)
+		if _, err := io.ReadFull(rr.r, rr.block); err != nil {
+			return nil, fmt.Errorf("truncated block at end of file")
+		}
+	}
+
+	if len(rr.block) < 4 {
+		return nil, fmt.Errorf("truncated RDW in block")
+	}
+	length, err := rr.descriptorLength(rr.block[:4], "RDW")
+	if err != nil {
+		return nil, err
+	}
+	if length > len(rr.block) {
+		return nil, fmt.Errorf("record length %d extends past the end of "+
+			"its block", length)
+	}
+	rec := rr.block[4:length]
+	rr.block = rr.block[length:]
+	return rec, nil
+}
+
+// descriptorLength returns the length from an RDW, checking it against the
+// LRECL.
+func (rr *RecordReader) descriptorLength(dw []byte,
+	kind string) (int, error) {
+
+	length := int(binary.BigEndian.Uint16(dw[0:2]))
+	if length < 4 || dw[2] != 0 || dw[3] != 0 {
+		// Non-zero flag bytes mean a spanned record segment, which we don't
+		// support.
+		return 0, fmt.Errorf("invalid or spanned %s %x", kind, dw)
+	}
+	if rr.lrecl > 0 && length > rr.lrecl {
+		return 0, fmt.Errorf("record length %d exceeds LRECL %d", length,
+			rr.lrecl)
+	}
+	return length, nil
+}
+
+// ebcdicReader turns the records from a RecordReader into lines of UTF-8
+// text for the file scanners.
+type ebcdicReader struct {
+	rr         *RecordReader
+	codepage   *Codepage
+	policy     UnmappablePolicy
+	rawControl bool
+	buf        bytes.Buffer
+	err        error
+}
+
+// NewEBCDICReader returns an io.Reader that reads the records from rr,
+// translates them from EBCDIC to UTF-8 with codepage, and returns them as
+// lines of text ending in LF, with any trailing blanks removed. This is
+// suitable input for ScanUTF8Single and ScanASAUTF8Single. If rawControl is
+// true, the first byte of each record is passed through untranslated, as
+// ScanMCCSingle requires for machine carriage control codes.
+func NewEBCDICReader(rr *RecordReader, codepage *Codepage,
+	policy UnmappablePolicy, rawControl bool) io.Reader {
+
+	return &ebcdicReader{
+		rr:         rr,
+		codepage:   codepage,
+		policy:     policy,
+		rawControl: rawControl,
+	}
+}
+
+func (e *ebcdicReader) Read(p []byte) (int, error) {
+	for e.buf.Len() == 0 && e.err == nil {
+		var rec []byte
+		rec, e.err = e.rr.Next()
+		if e.err != nil {
+			break
+		}
+		if e.rawControl && len(rec) > 0 {
+			e.buf.WriteByte(rec[0])
+			rec = rec[1:]
+		}
+		// Records are padded with EBCDIC blanks (or sometimes nulls).
+		rec = bytes.TrimRight(rec, "\x40\x00")
+		e.buf.WriteString(e.codepage.Decode(rec, e.policy))
+		e.buf.WriteByte('\n')
+	}
+	if e.buf.Len() > 0 {
+		return e.buf.Read(p)
+	}
+	return 0, e.err
+}
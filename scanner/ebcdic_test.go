@@ -0,0 +1,103 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// rdw returns an RDW followed by rec.
+func rdw(rec []byte) []byte {
+	n := len(rec) + 4
+	return append([]byte{byte(n >> 8), byte(n), 0, 0}, rec...)
+}
+
+func TestEBCDICRecords(t *testing.T) {
+	// "HELLO" and "1PAGE" in EBCDIC.
+	hello := []byte{0xc8, 0xc5, 0xd3, 0xd3, 0xd6}
+	page := []byte{0xf1, 0xd7, 0xc1, 0xc7, 0xc5}
+	blanks := []byte{0x40, 0x40, 0x40}
+
+	block := append(rdw(hello), rdw(page)...)
+	tests := []struct {
+		recfm string
+		lrecl int
+		data  []byte
+	}{
+		{"FB", 8, bytes.Join([][]byte{hello, blanks, page, blanks}, nil)},
+		{"V", 0, append(rdw(hello), rdw(page)...)},
+		{"VB", 84, rdw(block)},
+	}
+
+	cp, err := LookupEBCDICCodepage("037")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		recfm, _, err := ParseRecordFormat(test.recfm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := NewRecordReader(bytes.NewReader(test.data), recfm,
+			test.lrecl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(NewEBCDICReader(rr, cp, UnmappableReplace,
+			false))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.recfm, err)
+		}
+		if string(got) != "HELLO\n1PAGE\n" {
+			t.Errorf("%s: got %q", test.recfm, got)
+		}
+	}
+}
+
+func TestParseRecordFormat(t *testing.T) {
+	recfm, control, err := ParseRecordFormat("vba")
+	if err != nil || recfm != RecordFormatVB || control != ControlASA {
+		t.Errorf("VBA: got %v, %v, %v", recfm, control, err)
+	}
+	recfm, control, err = ParseRecordFormat("FBM")
+	if err != nil || recfm != RecordFormatFB || control != ControlMachine {
+		t.Errorf("FBM: got %v, %v, %v", recfm, control, err)
+	}
+	if _, _, err = ParseRecordFormat("U"); err == nil {
+		t.Errorf("expected an error for RECFM U")
+	}
+}
+
+func TestEBCDICEncode(t *testing.T) {
+	cp, err := LookupEBCDICCodepage("037")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r, want := range map[rune]byte{' ': 0x40, 'A': 0xC1, '/': 0x61,
+		'$': 0x5B, '¢': 0x4A} {
+		if b, ok := cp.Byte(r); !ok || b != want {
+			t.Errorf("%q: got %02x, %v; want %02x", r, b, ok, want)
+		}
+	}
+	if _, ok := cp.Byte('€'); ok {
+		t.Errorf("expected no mapping for € in 037")
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EOJDetector recognizes job boundaries in a Hercules printer data stream.
+// Each operating system (and each spooling subsystem) prints different
+// separator pages, so the scanner asks an EOJDetector to look at the lines on
+// either side of each form feed.
+type EOJDetector interface {
+	// EndOfJob is called with the last line of each page, that is, the line
+	// immediately followed by a form feed. If the line is the end of a job's
+	// trailing separator, EndOfJob returns true along with whatever it could
+	// learn about the job from the line.
+	EndOfJob(line string) (job JobMetadata, ok bool)
+
+	// StartOfJob is called with the first non-blank line of each page. If
+	// the line is the start of a job's leading separator, StartOfJob returns
+	// true along with whatever it could learn about the new job from the
+	// line. If this happens on any page but the first page of the current
+	// job, the current job is ended before the new page.
+	StartOfJob(line string) (job JobMetadata, ok bool)
+}
+
+// regexpDetector is an EOJDetector driven by a pair of regular expressions,
+// either of which may be nil. Job metadata is taken from the optional named
+// capture groups "type", "number", "name", "programmer", "room", "class",
+// and "time". If details is not nil, it is also applied to matching lines to
+// fill in any fields the start or end pattern didn't capture; this lets us
+// keep the patterns that decide where jobs end as lenient as possible.
+type regexpDetector struct {
+	start   *regexp.Regexp
+	end     *regexp.Regexp
+	details *regexp.Regexp
+}
+
+func (d *regexpDetector) EndOfJob(line string) (JobMetadata, bool) {
+	job, ok := d.match(d.end, line)
+	job.End, job.Start = job.Start, time.Time{}
+	return job, ok
+}
+
+func (d *regexpDetector) StartOfJob(line string) (JobMetadata, bool) {
+	return d.match(d.start, line)
+}
+
+// match applies re, and then the details pattern, to line. A time found on
+// the line is returned in the Start field.
+func (d *regexpDetector) match(re *regexp.Regexp,
+	line string) (JobMetadata, bool) {
+
+	var job JobMetadata
+	if re == nil || !re.MatchString(line) {
+		return job, false
+	}
+	if d.details != nil {
+		job = matchMetadata(d.details, line)
+	}
+	job.merge(matchMetadata(re, line))
+	return job, true
+}
+
+// matchMetadata extracts job metadata from the named capture groups of re.
+func matchMetadata(re *regexp.Regexp, line string) JobMetadata {
+	var job JobMetadata
+	matches := re.FindStringSubmatch(line)
+	if matches == nil {
+		return job
+	}
+
+	group := func(name string) string {
+		if i := re.SubexpIndex(name); i > 0 {
+			return strings.TrimSpace(matches[i])
+		}
+		return ""
+	}
+	job.Type = group("type")
+	job.Number = group("number")
+	job.Name = group("name")
+	job.Programmer = group("programmer")
+	job.Room = group("room")
+	job.Class = group("class")
+	job.Start = parseSeparatorTime(group("time"))
+	return job
+}
+
+// separatorTimeLayouts are the formats of the times printed on separator
+// pages that we know about.
+var separatorTimeLayouts = []string{
+	"3.04.05 PM 2 Jan 06",   // JES2 on MVS 3.8J
+	"3.04.05 PM 2 Jan 2006", // JES2 on z/OS
+	"15.04.05 2 Jan 06",
+	"15.04.05 2 Jan 2006",
+	"15:04:05 01/02/06",
+	"15:04:05 2006-01-02",
+}
+
+// parseSeparatorTime tries to interpret s as a separator page time, which is
+// in the mainframe's local time. Returns the zero time if s isn't a time we
+// understand.
+func parseSeparatorTime(s string) time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range separatorTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// jes2Details picks the output class, programmer name, room, and time out of
+// a JES2 separator line, which looks like:
+//
+//	****A  START  JOB   12  MYJOB   PGMR  ROOM 123  10.31.07 AM 17 OCT 26 ...
+var jes2Details = regexp.MustCompile(`^\*+(?P<class>[A-Z0-9])\s+` +
+	`(?:START|END)\s+(?:JOB|STC|TSU|J|S|T)\s*\d+\s+\S+\s+` +
+	`(?P<programmer>.*?)\s*ROOM\s+(?P<room>.*?)\s*` +
+	`(?P<time>\d{1,2}\.\d{2}\.\d{2}\s+[AP]M\s+\d{1,2}\s+[A-Z]{3}\s+` +
+	`\d{2}(?:\d{2})?)\b`)
+
+// mvs38jEnd matches the JES2 trailer line on MVS 3.8J.
+var mvs38jEnd = regexp.MustCompile(`\*+.+END.+(?P<type>JOB|STC|TSU)\D+` +
+	`(?P<number>\d+)\s+(?P<name>\S+)\s+.+ROOM.+END.+\*+`)
+
+// The built-in detectors. The patterns match the separator lines as they are
+// printed by the stock configuration of each system; sites with customized
+// separator pages should use NewRegexpEOJDetector instead.
+var builtinDetectors = map[string]EOJDetector{
+	// The JES2 trailer line, *if immediately followed by a LF+FF*, indicates
+	// end of job from the Moseley MVS 3.8J sysgen and TK4-. This is the
+	// original (and default) virtual1403 behavior.
+	"mvs38j": &regexpDetector{
+		end:     mvs38jEnd,
+		details: jes2Details,
+	},
+
+	// mvs38j-start also starts a new job at each JES2 START separator line,
+	// for systems that don't print trailer pages. A separator that spans
+	// more than one page will split the job, which is why this isn't the
+	// default.
+	"mvs38j-start": &regexpDetector{
+		start: regexp.MustCompile(`\*+.+START.+(?P<type>JOB|STC|TSU)\D+` +
+			`(?P<number>\d+)\s+(?P<name>\S+)\s+.+ROOM.+START.+\*+`),
+		end:     mvs38jEnd,
+		details: jes2Details,
+	},
+
+	// z/OS JES2 uses the same separator layout, but job IDs are printed as
+	// JOB01234 (or J0123456 once job numbers exceed five digits).
+	"zos": &regexpDetector{
+		start: regexp.MustCompile(`\*+.+START.+\b(?P<type>JOB|STC|TSU|J|S|T)` +
+			`\s*(?P<number>\d+)\s+(?P<name>\S+)\s+.+ROOM.+START.+\*+`),
+		end: regexp.MustCompile(`\*+.+END.+\b(?P<type>JOB|STC|TSU|J|S|T)` +
+			`\s*(?P<number>\d+)\s+(?P<name>\S+)\s+.+ROOM.+END.+\*+`),
+		details: jes2Details,
+	},
+
+	// VM/370 CP prints a separator page at the *start* of each spool file,
+	// and never ejects the last page of the file, so we can only find job
+	// boundaries by the information line on the next file's separator.
+	"vm370": &regexpDetector{
+		start: regexp.MustCompile(`USERID\W+(?P<name>\S+).*?` +
+			`(?:SPOOLID|FILE)\W+(?P<number>\d+)`),
+	},
+
+	// DOS/VSE POWER job separator pages are framed by "* * * * START * * *"
+	// and "* * * * END * * *" lines carrying the job name and number.
+	"vse-power": &regexpDetector{
+		start: regexp.MustCompile(`^\s*(?:\*\s*)+START\s*(?:\*\s*)+` +
+			`(?P<name>[A-Z0-9$#@]+)\s+(?P<number>\d+)`),
+		end: regexp.MustCompile(`^\s*(?:\*\s*)+END\s*(?:\*\s*)+` +
+			`(?P<name>[A-Z0-9$#@]+)\s+(?P<number>\d+)`),
+	},
+
+	// MTS batch output ends with the $SIGNOFF accounting summary, the last
+	// line of which reports the remaining account balance.
+	"mts": &regexpDetector{
+		end: regexp.MustCompile(`(?i)REMAINING\s+BALANCE`),
+	},
+
+	// timeout never matches a separator, so jobs are only split by the read
+	// timeout.
+	"timeout": &regexpDetector{},
+}
+
+// DefaultEOJDetector is used when no EOJDetector is provided to the scanner.
+var DefaultEOJDetector = builtinDetectors["mvs38j"]
+
+// NewEOJDetector returns the built-in EOJDetector with the given name. An
+// empty name returns DefaultEOJDetector.
+func NewEOJDetector(name string) (EOJDetector, error) {
+	if name == "" {
+		return DefaultEOJDetector, nil
+	}
+	d, ok := builtinDetectors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown end-of-job detector `%s`", name)
+	}
+	return d, nil
+}
+
+// EOJDetectorNames returns the names of the built-in EOJDetectors, sorted.
+func EOJDetectorNames() []string {
+	var names []string
+	for name := range builtinDetectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRegexpEOJDetector creates an EOJDetector from user-supplied regular
+// expressions. start matches the first non-blank line of a leading separator
+// page and end matches the last line of a trailing separator page. Either
+// may be empty, but not both. The named capture groups "type", "number",
+// "name", "programmer", "room", "class", and "time" are used to fill in the
+// job metadata.
+func NewRegexpEOJDetector(start, end string) (EOJDetector, error) {
+	if start == "" && end == "" {
+		return nil, fmt.Errorf("at least one of the start or end of job " +
+			"patterns is required")
+	}
+
+	var d regexpDetector
+	var err error
+	if start != "" {
+		if d.start, err = regexp.Compile(start); err != nil {
+			return nil, fmt.Errorf("invalid start of job pattern: %v", err)
+		}
+	}
+	if end != "" {
+		if d.end, err = regexp.Compile(end); err != nil {
+			return nil, fmt.Errorf("invalid end of job pattern: %v", err)
+		}
+	}
+	return &d, nil
+}
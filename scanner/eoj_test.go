@@ -0,0 +1,194 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// recordingHandler is a PrinterHandler that records everything it receives
+// as a list of easy-to-compare strings.
+type recordingHandler struct {
+	events []string
+	jobs   []JobMetadata
+}
+
+func (h *recordingHandler) AddLine(line string, linefeed bool) {
+	if linefeed {
+		h.events = append(h.events, "L:"+line)
+	} else {
+		h.events = append(h.events, "O:"+line)
+	}
+}
+
+func (h *recordingHandler) PageBreak() {
+	h.events = append(h.events, "P:")
+}
+
+func (h *recordingHandler) SkipToChannel(channel int) {
+	h.events = append(h.events, "C:"+strconv.Itoa(channel))
+}
+
+func (h *recordingHandler) EndOfJob(job JobMetadata) {
+	h.events = append(h.events, "J:"+job.JobInfo())
+	h.jobs = append(h.jobs, job)
+}
+
+// scanString runs data through the Hercules scanner and returns the events
+// the handler received.
+func scanString(t *testing.T, data string, eoj EOJDetector) []string {
+	return scan(t, data, eoj).events
+}
+
+// scanStringJobs runs data through the Hercules scanner and returns the jobs
+// the handler received.
+func scanStringJobs(t *testing.T, data string,
+	eoj EOJDetector) []JobMetadata {
+
+	return scan(t, data, eoj).jobs
+}
+
+func scan(t *testing.T, data string, eoj EOJDetector) *recordingHandler {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte(data))
+		client.Close()
+	}()
+
+	var h recordingHandler
+	err := ScanWithOptions(server, &h, Options{Tag: "test", EOJ: eoj})
+	if err != io.EOF {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	return &h
+}
+
+func checkEvents(t *testing.T, got, want []string) {
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got events:\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
+func TestMVSTrailer(t *testing.T) {
+	trailer := "****A  END   JOB   12  MYJOB     HERC01    ROOM        " +
+		"10.31.07 AM 17 OCT 26  PRINTER1  SYS TK4-  JOB   12  END   A****"
+	data := "LINE 1\nLINE 2\n\f" + trailer + "\n\f"
+
+	got := scanString(t, data, nil)
+	checkEvents(t, got, []string{
+		"L:LINE 1", "L:LINE 2", "P:", "L:" + trailer, "J:J12_MYJOB",
+	})
+
+	job := scanStringJobs(t, data, nil)[0]
+	if job.Class != "A" || job.Type != "JOB" || job.Number != "12" ||
+		job.Name != "MYJOB" || job.Programmer != "HERC01" || job.Room != "" {
+		t.Errorf("got unexpected job metadata %+v", job)
+	}
+	if job.End.Format("2006-01-02 15:04:05") != "2026-10-17 10:31:07" {
+		t.Errorf("got end time %v", job.End)
+	}
+}
+
+func TestStartOfJobSplits(t *testing.T) {
+	eoj, err := NewRegexpEOJDetector(`^SEP (?P<name>\S+) (?P<number>\d+)`,
+		"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "SEP ONE 1\n\fFIRST\n\f\nSEP TWO 2\n\fSECOND\n\n"
+
+	got := scanString(t, data, eoj)
+	checkEvents(t, got, []string{
+		"L:SEP ONE 1", "P:", "L:FIRST", "J:1_ONE",
+		"L:", "L:SEP TWO 2", "P:", "L:SECOND",
+	})
+}
+
+func TestMVSStartSeparator(t *testing.T) {
+	start := "****A  START JOB   13  NEXTJOB   HERC01    ROOM        " +
+		"10.32.07 AM 17 OCT 26  PRINTER1  SYS TK4-  JOB   13  START A****"
+	data := "LINE 1\n\fLINE 2\n\f" + start + "\n\fLINE 3\n\n"
+
+	// The default detector only ends jobs at the trailer, so a separator
+	// that spans pages doesn't split the job.
+	got := scanString(t, data, nil)
+	checkEvents(t, got, []string{
+		"L:LINE 1", "P:", "L:LINE 2", "P:", "L:" + start, "P:", "L:LINE 3",
+	})
+
+	eoj, err := NewEOJDetector("mvs38j-start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = scanString(t, data, eoj)
+	checkEvents(t, got, []string{
+		"L:LINE 1", "P:", "L:LINE 2", "J:",
+		"L:" + start, "P:", "L:LINE 3",
+	})
+}
+
+func TestBuiltinDetectorNames(t *testing.T) {
+	for _, name := range EOJDetectorNames() {
+		if _, err := NewEOJDetector(name); err != nil {
+			t.Errorf("couldn't create detector %s: %v", name, err)
+		}
+	}
+	if _, err := NewEOJDetector("no-such-os"); err == nil {
+		t.Errorf("expected error for unknown detector")
+	}
+}
+
+func TestScanContextFinishesJob(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var h recordingHandler
+	done := make(chan error)
+	go func() {
+		done <- ScanContext(ctx, server, &h, Options{Tag: "test"})
+	}()
+
+	client.Write([]byte("PARTIAL\n"))
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	checkEvents(t, h.events, []string{"L:PARTIAL", "J:"})
+}
+
+func TestScanReaderFinishesLastJob(t *testing.T) {
+	trailer := "****A  END   JOB   12  MYJOB     HERC01    ROOM        " +
+		"10.31.07 AM 17 OCT 26  PRINTER1  SYS TK4-  JOB   12  END   A****"
+	data := "LINE 1\n\f" + trailer + "\n\fLINE 2\n"
+
+	var h recordingHandler
+	if err := ScanReader(context.Background(), strings.NewReader(data), &h,
+		Options{Tag: "test"}); err != nil {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	checkEvents(t, h.events, []string{
+		"L:LINE 1", "P:", "L:" + trailer, "J:J12_MYJOB", "L:LINE 2", "J:",
+	})
+}
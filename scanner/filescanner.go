@@ -20,8 +20,10 @@ package scanner
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"log"
+	"time"
 )
 
 type fileStateFunc func(*fileScanner, rune) fileStateFunc
@@ -40,6 +42,18 @@ type fileScanner struct {
 // input file is assumed to be UTF-8 (compatible with US-ASCII) encoded.
 func ScanUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 	trace bool) error {
+
+	return ScanUTF8SingleContext(context.Background(), r, jobname, handler,
+		trace)
+}
+
+// ScanUTF8SingleContext is ScanUTF8Single, but stops reading when ctx is
+// done. Whatever was read before then is sent to the handler as a complete
+// job, and ctx.Err() is returned.
+func ScanUTF8SingleContext(ctx context.Context, r io.Reader, jobname string,
+	handler PrinterHandler, trace bool) error {
+
+	started := time.Now()
 	b := bufio.NewReader(r)
 
 	var s fileScanner
@@ -48,20 +62,24 @@ func ScanUTF8Single(r io.Reader, jobname string, handler PrinterHandler,
 	s.trace = trace
 	s.nextfunc = fileGetNextByte
 
-	for {
+	for ctx.Err() == nil {
 		nextRune, _, err := s.buf.ReadRune()
-		if err == io.EOF {
-			if s.pos > 0 {
-				s.emitLine()
-			}
-			handler.EndOfJob(jobname)
-			return nil
+		// If ctx is done, the read was probably interrupted on purpose,
+		// and we finish the job with what we have.
+		if err == io.EOF || (err != nil && ctx.Err() != nil) {
+			break
 		}
 		if err != nil {
 			return err
 		}
 		s.nextfunc = s.nextfunc(&s, nextRune)
 	}
+
+	if s.pos > 0 {
+		s.emitLine()
+	}
+	handler.EndOfJob(fileJob(jobname, started))
+	return ctx.Err()
 }
 
 func (s *fileScanner) emitLine() {
@@ -0,0 +1,107 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultLineWidth is the number of print positions on an IBM 1403, which is
+// the line width we use unless told otherwise.
+const DefaultLineWidth = 132
+
+// OverflowPolicy determines what happens to lines longer than the printer's
+// line width.
+type OverflowPolicy int
+
+const (
+	// OverflowTruncate discards the characters beyond the line width.
+	OverflowTruncate OverflowPolicy = iota
+	// OverflowWrap prints the characters beyond the line width on the
+	// following lines.
+	OverflowWrap
+)
+
+// ParseOverflowPolicy returns the OverflowPolicy for the configuration values
+// "truncate" and "wrap". An empty string is OverflowTruncate.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "truncate":
+		return OverflowTruncate, nil
+	case "wrap":
+		return OverflowWrap, nil
+	default:
+		return OverflowTruncate, fmt.Errorf(
+			"unknown line overflow policy `%s`; must be one of truncate, wrap",
+			s)
+	}
+}
+
+// lineWidthHandler is a PrinterHandler that fits lines to a line width
+// before passing them on.
+type lineWidthHandler struct {
+	PrinterHandler
+	width    int
+	overflow OverflowPolicy
+}
+
+// NewLineWidthHandler wraps handler so that lines longer than width
+// characters are truncated or wrapped according to overflow. A width of 0 or
+// less is DefaultLineWidth.
+func NewLineWidthHandler(handler PrinterHandler, width int,
+	overflow OverflowPolicy) PrinterHandler {
+
+	if width <= 0 {
+		width = DefaultLineWidth
+	}
+	return &lineWidthHandler{
+		PrinterHandler: handler,
+		width:          width,
+		overflow:       overflow,
+	}
+}
+
+func (h *lineWidthHandler) AddLine(line string, linefeed bool) {
+	if utf8.RuneCountInString(line) <= h.width {
+		h.PrinterHandler.AddLine(line, linefeed)
+		return
+	}
+
+	// Programs often pad their lines with blanks to the width of the printer
+	// they expected, which we don't want to turn into blank wrapped lines.
+	line = strings.TrimRight(line, " ")
+	runes := []rune(line)
+	if len(runes) <= h.width {
+		h.PrinterHandler.AddLine(line, linefeed)
+		return
+	}
+
+	if h.overflow == OverflowTruncate {
+		h.PrinterHandler.AddLine(string(runes[:h.width]), linefeed)
+		return
+	}
+
+	for len(runes) > h.width {
+		h.PrinterHandler.AddLine(string(runes[:h.width]), true)
+		runes = runes[h.width:]
+	}
+	h.PrinterHandler.AddLine(string(runes), linefeed)
+}
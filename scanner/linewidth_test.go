@@ -0,0 +1,47 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"testing"
+)
+
+func TestLineWidthHandler(t *testing.T) {
+	tests := []struct {
+		line     string
+		linefeed bool
+		overflow OverflowPolicy
+		want     []string
+	}{
+		{"ABCDE", true, OverflowTruncate, []string{"L:ABCDE"}},
+		{"ABCDEFG", true, OverflowTruncate, []string{"L:ABCDE"}},
+		{"ABCDE   ", true, OverflowWrap, []string{"L:ABCDE"}},
+		{"ABCDEFGHIJKL", true, OverflowWrap,
+			[]string{"L:ABCDE", "L:FGHIJ", "L:KL"}},
+		{"ABCDEFG", false, OverflowWrap, []string{"L:ABCDE", "O:FG"}},
+		{"¬¬¬¬¬¬", true, OverflowTruncate, []string{"L:¬¬¬¬¬"}},
+	}
+
+	for _, test := range tests {
+		var h recordingHandler
+		NewLineWidthHandler(&h, 5, test.overflow).AddLine(test.line,
+			test.linefeed)
+		checkEvents(t, h.events, test.want)
+	}
+}
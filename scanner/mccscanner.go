@@ -0,0 +1,161 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Machine carriage control codes are the printer channel command codes
+// themselves. Write commands (the low three bits are 001) print the record
+// and then move the paper; control commands (the low three bits are 011)
+// move the paper immediately without printing. In both, the upper five bits
+// select the movement: 00000 none, 00001-00011 space 1-3 lines, and
+// 10001-11100 skip to channel 1-12.
+const (
+	mccWrite     byte = 0x01
+	mccImmediate byte = 0x03
+)
+
+// mccPrinter sends machine carriage control records to a PrinterHandler.
+type mccPrinter struct {
+	handler PrinterHandler
+	trace   bool
+
+	// atTop is true until we have printed or moved the paper on the current
+	// page, so that skipping to channel 1 at the start of a page doesn't
+	// produce a blank page.
+	atTop bool
+}
+
+func newMCCPrinter(handler PrinterHandler, trace bool) *mccPrinter {
+	return &mccPrinter{handler: handler, trace: trace, atTop: true}
+}
+
+// record processes one record: the machine control code and the text to
+// print (ignored for control commands). recnum identifies the record in
+// error messages.
+func (p *mccPrinter) record(control byte, text string, recnum int) {
+	if p.trace {
+		log.Printf("TRACE: MCC record %d control %02x: %s", recnum, control,
+			text)
+	}
+
+	var write bool
+	switch control & 0x07 {
+	case mccWrite:
+		write = true
+	case mccImmediate:
+	default:
+		log.Printf("ERROR: unknown machine carriage control code %02x on "+
+			"record %d", control, recnum)
+		write = true
+		control = mccWrite | 0x08
+	}
+
+	action := control >> 3
+	if write {
+		// If we are only going to overstrike, the next write prints on this
+		// line; otherwise, the paper moves at least one line.
+		p.handler.AddLine(text, action != 0)
+		p.atTop = false
+	}
+
+	switch {
+	case action == 0:
+		// No movement.
+	case action >= 1 && action <= 3:
+		// Spacing after a write includes the line feed we already did.
+		spaces := int(action)
+		if write {
+			spaces--
+		}
+		for i := 0; i < spaces; i++ {
+			p.handler.AddLine("", true)
+		}
+		p.atTop = false
+	case action >= 0x11 && action <= 0x1c:
+		p.skipToChannel(int(action-0x11) + 1)
+	default:
+		log.Printf("ERROR: unknown machine carriage control code %02x on "+
+			"record %d", control, recnum)
+	}
+}
+
+func (p *mccPrinter) skipToChannel(channel int) {
+	if channel == 1 {
+		// Like ASA "1", skipping to channel 1 is a page break, which we
+		// skip if we're already at the top of a fresh page.
+		if !p.atTop {
+			p.handler.PageBreak()
+			p.atTop = true
+		}
+		return
+	}
+	p.handler.SkipToChannel(channel)
+	p.atTop = false
+}
+
+// ScanMCCSingle reads input from a reader (typically local file) and prints
+// the entire contents to the handler. No job separation is attempted. Each
+// line of the input begins with an IBM machine carriage control byte, such
+// as 0x09 (write and space 1 line) or 0x8B (skip to channel 1 immediately),
+// followed by UTF-8 (compatible with US-ASCII) text.
+func ScanMCCSingle(r io.Reader, jobname string, handler PrinterHandler,
+	trace bool) error {
+
+	return ScanMCCSingleContext(context.Background(), r, jobname, handler,
+		trace)
+}
+
+// ScanMCCSingleContext is ScanMCCSingle, but stops reading when ctx is
+// done. Whatever was read before then is sent to the handler as a complete
+// job, and ctx.Err() is returned.
+func ScanMCCSingleContext(ctx context.Context, r io.Reader, jobname string,
+	handler PrinterHandler, trace bool) error {
+
+	started := time.Now()
+	p := newMCCPrinter(handler, trace)
+
+	recnum := 0
+	scanner := bufio.NewScanner(r)
+	for ctx.Err() == nil && scanner.Scan() {
+		recnum++
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte{'\r'})
+		if len(line) == 0 {
+			// As with ASA files, we'll be lenient about lines without a
+			// control byte and print them as blank lines.
+			line = []byte{mccWrite | 0x08}
+		}
+		p.record(line[0], strings.ToValidUTF8(string(line[1:]), "?"), recnum)
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	handler.EndOfJob(fileJob(jobname, started))
+	return ctx.Err()
+}
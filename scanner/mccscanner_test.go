@@ -0,0 +1,52 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanMCC(t *testing.T) {
+	data := "\x8b\n" + // skip to channel 1 at the top of the job: ignored
+		"\x09TITLE\n" +
+		"\x11DOUBLE\n" +
+		"\x01OVER\n" +
+		"\x09STRUCK\n" +
+		"\x1b\n" +
+		"\x91CHANNEL 2\n" +
+		"\x89EJECT\n" +
+		"\x09NEXT PAGE\n"
+
+	var h recordingHandler
+	if err := ScanMCCSingle(strings.NewReader(data), "TEST", &h,
+		false); err != nil {
+		t.Fatalf("unexpected scanner error: %v", err)
+	}
+	checkEvents(t, h.events, []string{
+		"L:TITLE",
+		"L:DOUBLE", "L:",
+		"O:OVER", "L:STRUCK",
+		"L:", "L:", "L:",
+		"L:CHANNEL 2", "C:2",
+		"L:EJECT", "P:",
+		"L:NEXT PAGE",
+		"J:TEST",
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ScanReader is ScanContext for Hercules printer output that isn't arriving
+// on a socket, such as the file written by a Hercules printer device that
+// was given a file name instead of a sockdev address. Since the data is all
+// there to read, there are no pauses between jobs: jobs are only separated
+// by the EOJ detector, and the job in progress at the end of r is sent to
+// the handler as a complete job. Returns nil when all of r has been read.
+func ScanReader(ctx context.Context, r io.Reader, handler PrinterHandler,
+	opts Options) error {
+
+	opts.EndJobAtEOF = true
+	err := ScanContext(ctx, &readerConn{r: bufio.NewReader(r)}, handler,
+		opts)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// readerConn is a net.Conn that reads from an io.Reader. Read deadlines are
+// ignored, since there is never any waiting for data to arrive.
+type readerConn struct {
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *readerConn) Write(b []byte) (int, error) {
+	return 0, errors.New("can't write to a reader")
+}
+
+func (c *readerConn) Close() error { return nil }
+
+func (c *readerConn) LocalAddr() net.Addr { return readerAddr{} }
+
+func (c *readerConn) RemoteAddr() net.Addr { return readerAddr{} }
+
+func (c *readerConn) SetDeadline(t time.Time) error { return nil }
+
+func (c *readerConn) SetReadDeadline(t time.Time) error { return nil }
+
+func (c *readerConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type readerAddr struct{}
+
+func (readerAddr) Network() string { return "reader" }
+
+func (readerAddr) String() string { return "reader" }
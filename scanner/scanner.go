@@ -19,12 +19,14 @@
 package scanner
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"os"
-	"regexp"
+	"strings"
 	"time"
 )
 
@@ -40,6 +42,58 @@ type scanner struct {
 	newjob   bool
 	trace    bool
 	tag      string
+	eoj      EOJDetector
+
+	codepage   *Codepage
+	unmappable UnmappablePolicy
+	unmapped   [256]bool
+
+	// job is what we found on the current job's leading separator, if
+	// anything. received is when we started receiving the job, and joblines
+	// is the number of lines sent to the handler so far in the job.
+	job      JobMetadata
+	received time.Time
+	joblines int
+
+	// When we reach a form feed that isn't the end of a job, we hold on to
+	// the page break (and any blank lines at the top of the next page) until
+	// we see the first non-blank line of the next page, because that line
+	// might tell us the next page starts a new job.
+	pageTop   bool
+	heldPage  bool
+	heldLines []bool
+}
+
+// Options control the behavior of ScanWithOptions.
+type Options struct {
+	// Trace enables trace logging of the data stream.
+	Trace bool
+
+	// Tag identifies this scanner in log messages.
+	Tag string
+
+	// EOJ recognizes job boundaries. If nil, DefaultEOJDetector is used.
+	EOJ EOJDetector
+
+	// Codepage is the Hercules codepage used to translate the printer
+	// output to Unicode. If nil, DefaultCodepage is used.
+	Codepage *Codepage
+
+	// Unmappable determines what happens to bytes with no mapping in the
+	// codepage.
+	Unmappable UnmappablePolicy
+
+	// LineWidth is the number of print positions on the printer. If 0,
+	// DefaultLineWidth is used.
+	LineWidth int
+
+	// Overflow determines what happens to lines longer than LineWidth.
+	Overflow OverflowPolicy
+
+	// EndJobAtEOF sends the job in progress, if any, to the handler as a
+	// complete job when the other end closes the connection. Otherwise, the
+	// scanner returns io.EOF without ending the job.
+	EndJobAtEOF bool
 }
 
 // Scan will read from a net.Conn, conn, which should be sent data from
@@ -60,13 +114,50 @@ func Scan(conn net.Conn, handler PrinterHandler, trace bool) error {
 func ScanWithLogTag(conn net.Conn, handler PrinterHandler, trace bool,
 	tag string) error {
 
+	return ScanWithOptions(conn, handler, Options{Trace: trace, Tag: tag})
+}
+
+// ScanWithOptions will read from a net.Conn, conn, which should be sent data
+// from Hercules printer output. It will output lines (fit to
+// opts.LineWidth according to opts.Overflow) and page breaks and identify
+// the end of jobs in the printer data stream using opts.EOJ.
+func ScanWithOptions(conn net.Conn, handler PrinterHandler,
+	opts Options) error {
+
+	return ScanContext(context.Background(), conn, handler, opts)
+}
+
+// ScanContext is ScanWithOptions, but stops reading from conn when ctx is
+// done. Whatever was received of a job in progress is then sent to the
+// handler as a complete job, and ctx.Err() is returned. conn is not closed.
+func ScanContext(ctx context.Context, conn net.Conn, handler PrinterHandler,
+	opts Options) error {
+
 	var s scanner
 	s.conn = conn
-	s.handler = handler
+	s.handler = NewLineWidthHandler(handler, opts.LineWidth, opts.Overflow)
 	s.nextfunc = getNextByte
 	s.newjob = true
-	s.trace = trace
-	s.tag = tag
+	s.pageTop = true
+	s.trace = opts.Trace
+	s.tag = opts.Tag
+	s.eoj = opts.EOJ
+	if s.eoj == nil {
+		s.eoj = DefaultEOJDetector
+	}
+	s.codepage = opts.Codepage
+	if s.codepage == nil {
+		s.codepage = DefaultCodepage
+	}
+	s.unmappable = opts.Unmappable
+	tag := s.tag
+
+	// When ctx is done, we interrupt any read in progress by moving the read
+	// deadline to now.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
 
 	nextByte := make([]byte, 1)
 	for {
@@ -79,10 +170,28 @@ func ScanWithLogTag(conn net.Conn, handler PrinterHandler, trace bool,
 					err)
 			}
 		}
+		// We check ctx after setting the deadline, so that the deadline can't
+		// replace the one set when ctx is done.
+		if ctx.Err() != nil {
+			s.cancelJob()
+			return ctx.Err()
+		}
 		n, err := s.conn.Read(nextByte)
-		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) &&
+			ctx.Err() != nil {
+			s.cancelJob()
+			return ctx.Err()
+		} else if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
 			s.emitLine(true)
-			s.endJob(true)
+			s.endJob(JobMetadata{})
+		} else if err == io.EOF && opts.EndJobAtEOF {
+			if !s.newjob {
+				if s.pos > 0 {
+					s.emitLine(true)
+				}
+				s.endJob(JobMetadata{})
+			}
+			return err
 		} else if err != nil {
 			return err
 		} else if n != 1 {
@@ -110,6 +219,18 @@ func ScanWithLogTag(conn net.Conn, handler PrinterHandler, trace bool,
 	}
 }
 
+// cancelJob ends the job in progress, if any, with whatever we have
+// received of it so far.
+func (s *scanner) cancelJob() {
+	if s.newjob {
+		return
+	}
+	log.Printf("INFO:  [%s] stopping in the middle of a print job; "+
+		"finishing it with the data received so far", s.tag)
+	s.emitLine(true)
+	s.endJob(JobMetadata{})
+}
+
 func (s *scanner) emitLine(linefeed bool) {
 	// Trace output for the raw line
 	if s.trace {
@@ -117,46 +238,78 @@ func (s *scanner) emitLine(linefeed bool) {
 			linefeed, hex.EncodeToString(s.curline[:s.pos]))
 	}
 
-	// We need to build a valid UTF-8 string. For now we'll handle a couple
-	// mainframe-specific characters we might see, but someday probably need
-	// to make a general Hercules-default-to-UTF-8 table. See:
-	// https://github.com/SDL-Hercules-390/hyperion/blob/master/codepage.c#L99
+	// We need to build a valid UTF-8 string from the characters in the
+	// host codepage Hercules translated the printer output to.
 	utf8runes := make([]rune, 0, len(s.curline))
 	for i := 0; i < s.pos; i++ {
-		var r rune
-		switch s.curline[i] {
-		case 0x5e:
-			r = '¬'
-		case 0xa6:
-			r = '¦'
-		case 0xd6:
-			r = '¢'
-		case 0xd7:
-			r = '|'
-		case 0x9b:
-			r = '^'
-		case 0x9f:
-			r = '©'
-		default:
-			if s.curline[i] > 0x7F {
-				log.Printf(
-					"WARN:  [%s] got character %02x, need to add mapping\n",
-					s.tag, s.curline[i])
-			}
-			r = rune(s.curline[i])
+		r, ok := s.codepage.Rune(s.curline[i])
+		if ok {
+			utf8runes = append(utf8runes, r)
+			continue
+		}
+		if !s.unmapped[s.curline[i]] {
+			// Only complain once per connection about each character.
+			log.Printf(
+				"WARN:  [%s] got character %02x, which has no mapping in "+
+					"codepage %s\n", s.tag, s.curline[i], s.codepage.Name())
+			s.unmapped[s.curline[i]] = true
 		}
-		utf8runes = append(utf8runes, r)
+		utf8runes = s.unmappable.appendRunes(utf8runes, s.curline[i])
 	}
 	s.prevline = string(utf8runes)
-	s.handler.AddLine(s.prevline, linefeed)
+	s.addLine(s.prevline, linefeed)
 	s.pos = 0
 
 }
 
-// This regular expression, *if immediately followed by a LF+FF*, indicates
-// end of job from the Moseley MVS 3.8J sysgen and TK4-.
-var eojRegexp = regexp.MustCompile(
-	`(?m)\*+.+END.+(JOB|STC|TSU)\D+(\d+)\s+(\S+)\s+.+ROOM.+END.+\*+`)
+// addLine sends line to the handler, unless we are still looking for the
+// first non-blank line of a page, in which case we will hold on to blank
+// lines and check non-blank lines for the start of a new job.
+func (s *scanner) addLine(line string, linefeed bool) {
+	if s.pageTop {
+		if strings.TrimSpace(line) == "" {
+			s.heldLines = append(s.heldLines, linefeed)
+			return
+		}
+		s.pageTop = false
+		if job, ok := s.eoj.StartOfJob(line); ok {
+			if s.trace {
+				log.Printf("TRACE: [%s] scanner found start of job on "+
+					"line: %s", s.tag, line)
+			}
+			if s.joblines > 0 {
+				// The previous job didn't have a trailing separator that
+				// we recognized, but we now know it's over. The held page
+				// break belongs to the job we are ending, and is dropped.
+				s.heldPage = false
+				s.finishJob(JobMetadata{})
+				log.Printf(
+					"INFO:  [%s] receiving data from Hercules for new "+
+						"print job", s.tag)
+				s.received = time.Now()
+			}
+			s.job = job
+		}
+	}
+	s.flushHeld()
+	s.handler.AddLine(line, linefeed)
+	s.joblines++
+}
+
+// flushHeld sends any page break and blank lines we were holding on to while
+// looking for the start of a new job to the handler.
+func (s *scanner) flushHeld() {
+	if s.heldPage {
+		s.handler.PageBreak()
+		s.heldPage = false
+	}
+	for _, linefeed := range s.heldLines {
+		s.handler.AddLine("", linefeed)
+		s.joblines++
+	}
+	s.heldLines = s.heldLines[:0]
+	s.pageTop = false
+}
 
 // When we emit a line and page together (e.g. we got a LF followed by FF),
 // we might be at the end of the job, so we'll check for the end of the
@@ -167,36 +320,21 @@ func (s *scanner) emitLineAndPage() {
 		log.Printf("TRACE: [%s] scanner checking for end of job on line: %s",
 			s.tag, s.prevline)
 	}
-	if eojRegexp.MatchString(s.prevline) {
-		s.endJob(false)
+	if job, ok := s.eoj.EndOfJob(s.prevline); ok {
+		s.endJob(job)
 	} else {
-		s.handler.PageBreak()
+		s.heldPage = true
+		s.pageTop = true
 	}
 }
 
-func (s *scanner) endJob(wasTimeout bool) {
-	jobinfo := ""
-
-	// If end of job was due to end-of-job line, not a read timeout, we'll try
-	// to populate additional job info.
-	if !wasTimeout {
-		matches := eojRegexp.FindStringSubmatch(s.prevline)
-		if len(matches) > 1 {
-			// get first letter, e.g. J(ob) or S(tc)
-			jobinfo = string(matches[1][0])
-		}
-		if len(matches) > 2 {
-			// Should be the job number
-			jobinfo = jobinfo + matches[2]
-		}
-		if len(matches) > 3 {
-			// Should be the job name
-			jobinfo = jobinfo + "_" + matches[3]
-		}
-	}
-
-	s.handler.EndOfJob(jobinfo)
-	s.prevline = ""
+// endJob ends the current job and resets the scanner to wait for the next
+// job. job is what we found on the trailing separator, if anything.
+func (s *scanner) endJob(job JobMetadata) {
+	// If we were holding on to a page break and blank lines, they were
+	// really part of the job, so we'll send them along first.
+	s.flushHeld()
+	s.finishJob(job)
 	s.pos = 0
 	s.newjob = true
 
@@ -205,3 +343,21 @@ func (s *scanner) endJob(wasTimeout bool) {
 		log.Printf("ERROR: [%s] couldn't clear read deadline: %v", s.tag, err)
 	}
 }
+
+// finishJob sends the end of job to the handler and resets the per-job
+// state. The metadata from the trailing separator, job, is combined with
+// what we found on the leading separator.
+func (s *scanner) finishJob(job JobMetadata) {
+	s.job.merge(job)
+	if s.job.Start.IsZero() {
+		s.job.Start = s.received
+	}
+	if s.job.End.IsZero() {
+		s.job.End = time.Now()
+	}
+	s.handler.EndOfJob(s.job)
+	s.prevline = ""
+	s.job = JobMetadata{}
+	s.joblines = 0
+	s.pageTop = true
+}
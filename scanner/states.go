@@ -18,7 +18,10 @@
 
 package scanner
 
-import "log"
+import (
+	"log"
+	"time"
+)
 
 // getNextByte represents the "normal" state where we are collecting input
 // characters into the current line until we get a control character or
@@ -30,6 +33,7 @@ func getNextByte(s *scanner, b byte) stateFunc {
 			"INFO:  [%s] receiving data from Hercules for new print job",
 			s.tag)
 		s.newjob = false
+		s.received = time.Now()
 	}
 
 	switch b {
@@ -76,7 +80,7 @@ func getNextByte(s *scanner, b byte) stateFunc {
 		// Add byte to the current line
 		s.curline[s.pos] = b
 		s.pos++
-		// Line can be at most 132 characters
+		// Line can be at most maxLineLen characters
 		if s.pos >= maxLineLen {
 			return disposeBytes
 		}
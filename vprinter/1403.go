@@ -22,6 +22,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jung-kurt/gofpdf"
 )
@@ -36,9 +37,12 @@ type virtual1403 struct {
 	pdf              *gofpdf.Fpdf
 	font             []byte
 	fontSize         float64
+	columns          int
 	skipLines        int
+	fcb              *FCB
 	forceUpper       bool
 	curLine          int
+	pageUsed         bool
 	pages            int
 	leftMargin       float64
 	overstrikeOffset float64
@@ -55,10 +59,26 @@ const (
 func New1403(font []byte, fontsize float64, skipLines int, forceUpper,
 	drawBG bool, dark, light ColorRGB) (Job, error) {
 
+	return newPrinter(Model1403, font, fontsize, skipLines, forceUpper,
+		drawBG, dark, light)
+}
+
+// newPrinter creates a virtual printer with the print line width of model.
+// Lines wider than the 1403's are printed in a proportionally smaller font
+// so they fit on the paper.
+func newPrinter(model PrinterModel, font []byte, fontsize float64,
+	skipLines int, forceUpper, drawBG bool, dark, light ColorRGB) (Job, error) {
+
+	if model.Columns > maxLineCharacters {
+		fontsize = fontsize * maxLineCharacters / float64(model.Columns)
+	}
+
 	j := &virtual1403{
 		font:       font,
 		fontSize:   fontsize,
+		columns:    model.Columns,
 		skipLines:  skipLines,
+		fcb:        defaultFCB(skipLines),
 		forceUpper: forceUpper,
 	}
 
@@ -82,12 +102,12 @@ func New1403(font []byte, fontsize float64, skipLines int, forceUpper,
 		drawBackgroundTemplate(tpl, drawBG, dark, light)
 	})
 
-	// We will dynamically determine how wide 132 characters of the chosen
-	// font is so that we can correctly position (center) the output area on
-	// the page. The left margin of our text output area will be the center
-	// of the page minus half of the line width.
+	// We will dynamically determine how wide a full line of the chosen font
+	// is so that we can correctly position (center) the output area on the
+	// page. The left margin of our text output area will be the center of
+	// the page minus half of the line width.
 	j.pdf.SetFont("userfont", "", j.fontSize)
-	j.leftMargin = v1403W/2 - determineLineWidth(j.pdf)/2
+	j.leftMargin = v1403W/2 - determineLineWidth(j.pdf, j.columns)/2
 
 	j.NewPage()
 
@@ -95,11 +115,11 @@ func New1403(font []byte, fontsize float64, skipLines int, forceUpper,
 }
 
 func (job *virtual1403) AddLine(s string, linefeed bool) int {
-	if job.curLine >= maxLinesPerPage {
+	if job.curLine >= job.fcb.lines {
 		job.NewPage()
 	}
-	if len(s) > maxLineCharacters {
-		s = s[0:maxLineCharacters]
+	if utf8.RuneCountInString(s) > job.columns {
+		s = string([]rune(s)[:job.columns])
 	}
 	// 1403 only had capital letters; we'll enforce that if requested
 	if job.forceUpper {
@@ -108,6 +128,7 @@ func (job *virtual1403) AddLine(s string, linefeed bool) int {
 	job.pdf.SetXY(job.leftMargin+job.overstrikeOffset,
 		float64(job.curLine*12)+.25)
 	job.pdf.CellFormat(0, 12, s, "", 0, "LM", false, 0, "")
+	job.pageUsed = true
 	if linefeed {
 		job.curLine++
 		job.overstrikeOffset = 0
@@ -122,12 +143,56 @@ func (job *virtual1403) NewPage() int {
 	job.pdf.UseTemplate(job.background)
 	job.pdf.SetFont("userfont", "", job.fontSize)
 	// simulating a 1403 with form control that can skip the first physically
-	// printable lines.
-	job.curLine = job.skipLines
+	// printable lines: a new page starts at the channel 1 line.
+	job.topOfForm()
 	job.pages++
 	return job.pages
 }
 
+// topOfForm positions us at the channel 1 line of a fresh page.
+func (job *virtual1403) topOfForm() {
+	job.curLine = 0
+	if line := job.fcb.findChannel(1, 1); line > 0 {
+		job.curLine = line - 1
+	}
+	job.pageUsed = false
+}
+
+func (job *virtual1403) SkipToChannel(channel int) int {
+	// curLine is the 0-based index of the next line to print, so the FCB
+	// line number of the current position is curLine+1.
+	job.overstrikeOffset = 0
+	if line := job.fcb.findChannel(job.curLine+1, channel); line > 0 {
+		job.curLine = line - 1
+		job.pageUsed = true
+		return job.pages
+	}
+
+	// The channel isn't punched on the rest of this page, so we move on to
+	// its first line on the next page. If the channel isn't punched at all,
+	// a real printer's carriage would run away until an operator noticed;
+	// we'll just stop at the top of the next page.
+	job.NewPage()
+	if line := job.fcb.findChannel(1, channel); line > 0 {
+		job.curLine = line - 1
+		job.pageUsed = true
+	}
+	return job.pages
+}
+
+func (job *virtual1403) LoadFCB(fcb *FCB) {
+	if fcb == nil {
+		fcb = defaultFCB(job.skipLines)
+	}
+	job.fcb = fcb
+
+	// If nothing has happened on this page yet, the operator would have
+	// aligned the new form with its channel 1 line.
+	if !job.pageUsed {
+		job.topOfForm()
+	}
+}
+
 func (job *virtual1403) EndJob(w io.Writer) (int, error) {
 	return job.pages, job.pdf.Output(w)
 }
@@ -281,11 +346,6 @@ func drawBackgroundTemplate(pdf *gofpdf.Tpl, drawBG bool, dark,
 	pdf.SetTextColor(0, 0, 0)
 }
 
-func determineLineWidth(pdf *gofpdf.Fpdf) float64 {
-	const linechars = 132
-	var dummyline [linechars]byte
-	for i := 0; i < linechars; i++ {
-		dummyline[i] = ' '
-	}
-	return pdf.GetStringWidth(string(dummyline[:]))
+func determineLineWidth(pdf *gofpdf.Fpdf, linechars int) float64 {
+	return pdf.GetStringWidth(strings.Repeat(" ", linechars))
 }
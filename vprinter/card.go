@@ -0,0 +1,308 @@
+package vprinter
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// CardDeck is the interface to a virtual card punch, which draws the cards
+// it punches.
+type CardDeck interface {
+	// AddCard punches one card. columns is the EBCDIC code punched in each
+	// column (up to 80), and text is the interpretation printed along the
+	// top of the card, one character per column. Returns the number of cards
+	// in the deck so far.
+	AddCard(columns []byte, text string) int
+
+	// EndJob writes the PDF of the deck to w, and returns the number of
+	// pages.
+	EndJob(w io.Writer) (int, error)
+}
+
+// CardColumns is the number of columns on a punched card.
+const CardColumns = 80
+
+// Card rows, top to bottom, as indexes into a column's punches.
+const (
+	row12 = iota
+	row11
+	row0
+	row1
+	row2
+	row3
+	row4
+	row5
+	row6
+	row7
+	row8
+	row9
+)
+
+// Dimensions of an 80-column card, in points. The card is 7 3/8 by 3 1/4
+// inches, columns are 0.087 inches apart, and rows are a quarter inch apart.
+const (
+	cardW          = 531
+	cardH          = 234
+	cardColPitch   = 6.264
+	cardRowPitch   = 18
+	cardFirstCol   = 16.092 // left edge of the card to column 1
+	cardFirstRow   = 13.5   // top edge of the card to row 12
+	cardHoleW      = 3.96
+	cardHoleH      = 9
+	cardCornerCut  = 18
+	cardsPerPage   = 3
+	cardPageW      = 612 // US letter
+	cardPageH      = 792
+	cardPageMargin = 27
+	cardGap        = 18
+)
+
+var cardStock = ColorRGB{243, 233, 200}
+var cardInk = ColorRGB{150, 90, 70}
+var cardHole = ColorRGB{35, 35, 35}
+
+// our implementation of the CardDeck interface, which draws each card as an
+// IBM 5081 general purpose card, three to a page.
+type virtualPunch struct {
+	pdf   *gofpdf.Fpdf
+	card  gofpdf.Template
+	cards int
+	pages int
+}
+
+// NewCardDeck creates a virtual card punch. The interpretation is printed in
+// font, or our default font if font is nil.
+func NewCardDeck(font []byte) (CardDeck, error) {
+	if font == nil {
+		font = defaultFont
+	}
+
+	p := &virtualPunch{}
+	p.pdf = gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "pt",
+		Size:    gofpdf.SizeType{Wd: cardPageW, Ht: cardPageH},
+	})
+	p.pdf.SetMargins(0, 0, 0)
+	p.pdf.SetAutoPageBreak(false, 0)
+	p.pdf.AddUTF8FontFromBytes("userfont", "", font)
+
+	p.card = p.pdf.CreateTemplateCustom(gofpdf.PointType{X: 0, Y: 0},
+		gofpdf.SizeType{Wd: cardW, Ht: cardH}, drawCardTemplate)
+
+	return p, p.pdf.Error()
+}
+
+func (p *virtualPunch) AddCard(columns []byte, text string) int {
+	slot := p.cards % cardsPerPage
+	if slot == 0 {
+		p.pdf.AddPage()
+		p.pages++
+	}
+	x := float64(cardPageW-cardW) / 2
+	y := float64(cardPageMargin + slot*(cardH+cardGap))
+	p.pdf.UseTemplateScaled(p.card, gofpdf.PointType{X: x, Y: y},
+		gofpdf.SizeType{Wd: cardW, Ht: cardH})
+
+	// The interpretation is printed above each column, along the top edge
+	// of the card.
+	p.pdf.SetFont("userfont", "", 7)
+	p.pdf.SetTextColor(0, 0, 0)
+	col := 0
+	for _, r := range text {
+		if col == CardColumns {
+			break
+		}
+		if r != ' ' {
+			s := string(r)
+			cx := x + cardFirstCol + float64(col)*cardColPitch +
+				cardHoleW/2
+			p.pdf.Text(cx-p.pdf.GetStringWidth(s)/2, y+8, s)
+		}
+		col++
+	}
+
+	p.pdf.SetFillColor(cardHole.R, cardHole.G, cardHole.B)
+	for col, b := range columns {
+		if col == CardColumns {
+			break
+		}
+		punches := hollerith(b)
+		for row := row12; row <= row9; row++ {
+			if punches&(1<<row) == 0 {
+				continue
+			}
+			p.pdf.Rect(x+cardFirstCol+float64(col)*cardColPitch,
+				y+cardFirstRow+float64(row*cardRowPitch),
+				cardHoleW, cardHoleH, "F")
+		}
+	}
+
+	p.cards++
+	return p.cards
+}
+
+func (p *virtualPunch) EndJob(w io.Writer) (int, error) {
+	return p.pages, p.pdf.Output(w)
+}
+
+// drawCardTemplate draws a blank 5081 card: the card stock with its corner
+// cut, and the digits and column numbers printed on it.
+func drawCardTemplate(pdf *gofpdf.Tpl) {
+	pdf.SetFillColor(cardStock.R, cardStock.G, cardStock.B)
+	pdf.SetDrawColor(cardInk.R, cardInk.G, cardInk.B)
+	pdf.SetLineWidth(.5)
+	pdf.Polygon([]gofpdf.PointType{
+		{X: cardCornerCut, Y: 0},
+		{X: cardW, Y: 0},
+		{X: cardW, Y: cardH},
+		{X: 0, Y: cardH},
+		{X: 0, Y: cardCornerCut},
+	}, "FD")
+
+	pdf.SetTextColor(cardInk.R, cardInk.G, cardInk.B)
+	pdf.SetFont("Helvetica", "", 6)
+	for col := 0; col < CardColumns; col++ {
+		x := cardFirstCol + float64(col)*cardColPitch
+		for digit := 0; digit <= 9; digit++ {
+			y := cardFirstRow + float64((row0+digit)*cardRowPitch)
+			pdf.SetXY(x, y)
+			pdf.CellFormat(cardHoleW, cardHoleH, strconv.Itoa(digit), "", 0,
+				"CM", false, 0, "")
+		}
+
+		// Column numbers are printed under the 0 and 9 rows.
+		pdf.SetFont("Helvetica", "", 3)
+		for _, row := range []int{row0, row9} {
+			y := cardFirstRow + float64(row*cardRowPitch) + cardHoleH
+			pdf.SetXY(x-1, y)
+			pdf.CellFormat(cardHoleW+2, 4, strconv.Itoa(col+1), "", 0,
+				"CM", false, 0, "")
+		}
+		pdf.SetFont("Helvetica", "", 6)
+	}
+
+	pdf.SetFont("Helvetica", "", 5)
+	pdf.SetXY(cardFirstCol, cardH-8)
+	pdf.CellFormat(0, 6, "IBM 5081", "", 0, "LM", false, 0, "")
+}
+
+// hollerith returns the rows punched for an EBCDIC code, using the System/360
+// card code, as a bit mask with bit n set if row n (row12 through row9) is
+// punched. Every code has a different combination of punches.
+func hollerith(b byte) uint16 {
+	// zones are the zone rows punched for each first hex digit, which the
+	// cases below add the rows for the second digit to.
+	var zones = [16][]int{
+		{row12, row9}, {row11, row9}, {row0, row9}, {row9},
+		{row12, row0, row9}, {row12, row11, row9}, {row11, row0, row9},
+		{row12, row11, row0, row9},
+		{row12, row0}, {row12, row11}, {row11, row0},
+		{row12, row11, row0},
+		{row12}, {row11}, {row0}, {},
+	}
+
+	var punches []int
+	hi, lo := int(b>>4), int(b&0xF)
+	digit := func(d int) []int {
+		// Second digits 1-9 punch rows 1-9; A-F punch 8 along with 2-7.
+		if d <= 9 {
+			return []int{row0 + d}
+		}
+		return []int{row8, row0 + d - 8}
+	}
+
+	switch {
+	case b == 0x40:
+		// Blank.
+	case b == 0x61:
+		punches = []int{row0, row1}
+	case b == 0xE1:
+		punches = []int{row11, row0, row9, row1}
+	case b == 0x6A:
+		punches = []int{row12, row11}
+	case hi < 4:
+		// Control characters: 12-9, 11-9, 0-9, or 9, and the second digit
+		// with 8-1 for 9. 0 is the zone with 12 or 11 added and 8-1.
+		punches = append(punches, zones[hi]...)
+		switch {
+		case lo == 0:
+			punches = append(punches, [][]int{{row0}, {row12},
+				{row11}, {row12, row11, row0}}[hi]...)
+			punches = append(punches, row8, row1)
+		case lo == 8:
+			punches = append(punches, row8)
+		case lo == 9:
+			punches = append(punches, row8, row1)
+		default:
+			punches = append(punches, digit(lo)...)
+		}
+	case hi < 8:
+		// Punctuation. 0 is a single zone punch (or none at all for blank),
+		// 1-8 are the second digit with the zone and 0-9 (or 9 for the
+		// 12-11 zone), and 9-F are 8 and the second digit less 8.
+		zone := [][]int{{row12}, {row11}, {row0}, {}}[hi-4]
+		switch {
+		case lo == 0:
+			punches = append(punches, [][]int{{}, {row12}, {row11},
+				{row12, row11, row0}}[hi-4]...)
+		case lo <= 8:
+			punches = append(punches, zones[hi]...)
+			if lo == 8 {
+				punches = append(punches, row8)
+			} else {
+				punches = append(punches, row0+lo)
+			}
+		default:
+			punches = append(punches, zone...)
+			punches = append(punches, row8, row0+lo-8)
+		}
+	case hi < 0xC:
+		// Lower case letters: two zone punches, with 8-1 for 0.
+		punches = append(punches, zones[hi]...)
+		if lo == 0 {
+			punches = append(punches, row8, row1)
+		} else {
+			punches = append(punches, digit(lo)...)
+		}
+	default:
+		// Upper case letters and digits: one zone punch, and A-F add the
+		// zone, 9, and 8 to the second digit less 8.
+		punches = append(punches, zones[hi]...)
+		switch {
+		case lo == 0:
+			punches = append(punches, [][]int{{row0}, {row0},
+				{row8, row2}, {row0}}[hi-0xC]...)
+		case lo <= 9:
+			punches = append(punches, row0+lo)
+		default:
+			punches = append(punches, zones[hi-4]...)
+			punches = append(punches, row9, row8, row0+lo-8)
+		}
+	}
+
+	var mask uint16
+	for _, row := range punches {
+		mask |= 1 << row
+	}
+	return mask
+}
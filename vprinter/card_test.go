@@ -0,0 +1,86 @@
+package vprinter
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"testing"
+)
+
+func punches(rows ...int) uint16 {
+	var mask uint16
+	for _, row := range rows {
+		mask |= 1 << row
+	}
+	return mask
+}
+
+func TestHollerith(t *testing.T) {
+	tests := map[byte]uint16{
+		0x40: 0,                          // blank
+		0xC1: punches(row12, row1),       // A
+		0xD9: punches(row11, row9),       // R
+		0xE2: punches(row0, row2),        // S
+		0xF0: punches(row0),              // 0
+		0xF9: punches(row9),              // 9
+		0x61: punches(row0, row1),        // /
+		0x4B: punches(row12, row8, row3), // .
+		0x5B: punches(row11, row8, row3), // $
+		0x6B: punches(row0, row8, row3),  // ,
+		0x7D: punches(row8, row5),        // '
+		0x50: punches(row12),             // &
+		0x60: punches(row11),             // -
+		0x81: punches(row12, row0, row1), // a
+		0x00: punches(row12, row0, row9, row8, row1),
+		0xFF: punches(row12, row11, row0, row9, row8, row7),
+	}
+	for b, want := range tests {
+		if got := hollerith(b); got != want {
+			t.Errorf("hollerith(%02X) = %012b, want %012b", b, got, want)
+		}
+	}
+
+	seen := make(map[uint16]int)
+	for b := 0; b < 256; b++ {
+		code := hollerith(byte(b))
+		if other, ok := seen[code]; ok {
+			t.Errorf("%02X and %02X have the same punches %012b", other, b,
+				code)
+		}
+		seen[code] = b
+	}
+}
+
+func TestCardDeck(t *testing.T) {
+	deck, err := NewCardDeck(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		deck.AddCard([]byte{0xC8, 0xC5, 0xD3, 0xD3, 0xD6}, "HELLO")
+	}
+	var buf bytes.Buffer
+	pages, err := deck.EndJob(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("got %d pages for 4 cards, want 2", pages)
+	}
+}
@@ -0,0 +1,175 @@
+package vprinter
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxChannel is the highest carriage control channel.
+const MaxChannel = 12
+
+// FCB is a forms control buffer: the electronic successor of the 1403's
+// carriage control tape, which had holes punched in up to 12 channels to
+// mark the lines the carriage could skip to. Channel 1 conventionally marks
+// the first line of the form and channel 12 the overflow line near the
+// bottom.
+type FCB struct {
+	// lines is the length of the form in lines.
+	lines int
+
+	// punches holds, for each line of the form (1-based; punches[0] is
+	// unused), a bit mask of the channels punched on that line.
+	punches []uint16
+}
+
+// NewFCB creates a forms control buffer for a form of the given number of
+// lines. channels maps channel numbers (1-12) to the line numbers (1-based)
+// punched in that channel; a channel may be punched on more than one line.
+func NewFCB(lines int, channels map[int][]int) (*FCB, error) {
+	if lines < 1 || lines > maxLinesPerPage {
+		return nil, fmt.Errorf("FCB form length must be between 1 and %d "+
+			"lines", maxLinesPerPage)
+	}
+
+	fcb := &FCB{lines: lines, punches: make([]uint16, lines+1)}
+	for channel, channelLines := range channels {
+		if channel < 1 || channel > MaxChannel {
+			return nil, fmt.Errorf("FCB channel %d is invalid", channel)
+		}
+		for _, line := range channelLines {
+			if line < 1 || line > lines {
+				return nil, fmt.Errorf("FCB channel %d line %d is beyond "+
+					"the %d line form", channel, line, lines)
+			}
+			fcb.punches[line] |= 1 << channel
+		}
+	}
+	return fcb, nil
+}
+
+// ParseFCB creates a forms control buffer from a specification in the style
+// of the Hercules printer fcb= option: the form length in lines, followed by
+// the line numbers of channels 1 through 12 in order, separated by colons. A
+// line number of 0 (or a missing entry at the end of the list) means the
+// channel is not punched. As an extension, a channel may list more than one
+// line separated by "+". For example, "66:1:7:13:0:0:0:0:0:0:0:0:63+64".
+func ParseFCB(spec string) (*FCB, error) {
+	fields := strings.Split(strings.TrimSpace(spec), ":")
+	if len(fields) > MaxChannel+1 {
+		return nil, fmt.Errorf("FCB specification has more than %d "+
+			"channels", MaxChannel)
+	}
+
+	lines, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("FCB form length `%s` is invalid", fields[0])
+	}
+
+	channels := make(map[int][]int)
+	for i, field := range fields[1:] {
+		for _, l := range strings.Split(field, "+") {
+			line, err := strconv.Atoi(l)
+			if err != nil || line < 0 {
+				return nil, fmt.Errorf("FCB channel %d line `%s` is invalid",
+					i+1, l)
+			}
+			if line > 0 {
+				channels[i+1] = append(channels[i+1], line)
+			}
+		}
+	}
+
+	return NewFCB(lines, channels)
+}
+
+// String returns the FCB in the format accepted by ParseFCB.
+func (fcb *FCB) String() string {
+	fields := []string{strconv.Itoa(fcb.lines)}
+	for channel := 1; channel <= MaxChannel; channel++ {
+		var lines []string
+		for line := 1; line <= fcb.lines; line++ {
+			if fcb.punched(line, channel) {
+				lines = append(lines, strconv.Itoa(line))
+			}
+		}
+		if len(lines) == 0 {
+			fields = append(fields, "0")
+		} else {
+			fields = append(fields, strings.Join(lines, "+"))
+		}
+	}
+
+	// Trailing unpunched channels may be left off.
+	for len(fields) > 1 && fields[len(fields)-1] == "0" {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, ":")
+}
+
+// Lines returns the length of the form in lines.
+func (fcb *FCB) Lines() int {
+	return fcb.lines
+}
+
+// Channels returns the lines punched in each channel, for the channels that
+// are punched on at least one line.
+func (fcb *FCB) Channels() map[int][]int {
+	channels := make(map[int][]int)
+	for line := 1; line <= fcb.lines; line++ {
+		for channel := 1; channel <= MaxChannel; channel++ {
+			if fcb.punched(line, channel) {
+				channels[channel] = append(channels[channel], line)
+			}
+		}
+	}
+	for _, lines := range channels {
+		sort.Ints(lines)
+	}
+	return channels
+}
+
+func (fcb *FCB) punched(line, channel int) bool {
+	return fcb.punches[line]&(1<<channel) != 0
+}
+
+// findChannel returns the first line, starting at line from, that is punched
+// in channel. Returns 0 if there is no such line.
+func (fcb *FCB) findChannel(from, channel int) int {
+	for line := from; line <= fcb.lines; line++ {
+		if fcb.punched(line, channel) {
+			return line
+		}
+	}
+	return 0
+}
+
+// defaultFCB is the FCB we use until another is loaded: a 66-line form with
+// channel 1 on the first line we print on (after skipping skipLines lines at
+// the top of the page) and channel 12 near the bottom of the page.
+func defaultFCB(skipLines int) *FCB {
+	fcb, _ := NewFCB(maxLinesPerPage, map[int][]int{
+		1:  {skipLines + 1},
+		12: {maxLinesPerPage - 2},
+	})
+	return fcb
+}
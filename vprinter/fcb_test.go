@@ -0,0 +1,79 @@
+package vprinter
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"testing"
+)
+
+func TestParseFCB(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		ok   bool
+	}{
+		{"66:1", "66:1", true},
+		{"66:6:0:0:0:0:0:0:0:0:0:0:64", "66:6:0:0:0:0:0:0:0:0:0:0:64", true},
+		{"51:1:12+24:0:0", "51:1:12+24", true},
+		{" 66 ", "66", true},
+		{"", "", false},
+		{"abc:1", "", false},
+		{"67:1", "", false},
+		{"66:70", "", false},
+		{"66:-1", "", false},
+		{"66:1:2:3:4:5:6:7:8:9:10:11:12:13", "", false},
+	}
+
+	for _, test := range tests {
+		fcb, err := ParseFCB(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseFCB(%q) error = %v, want ok = %v", test.spec, err,
+				test.ok)
+			continue
+		}
+		if err == nil && fcb.String() != test.want {
+			t.Errorf("ParseFCB(%q).String() = %q, want %q", test.spec,
+				fcb.String(), test.want)
+		}
+	}
+}
+
+func TestFCBFindChannel(t *testing.T) {
+	fcb, err := NewFCB(66, map[int][]int{1: {6}, 2: {20, 40}, 12: {64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ from, channel, want int }{
+		{1, 1, 6},
+		{6, 1, 6},
+		{7, 1, 0},
+		{1, 2, 20},
+		{21, 2, 40},
+		{41, 2, 0},
+		{1, 12, 64},
+		{1, 3, 0},
+	}
+	for _, test := range tests {
+		if got := fcb.findChannel(test.from, test.channel); got != test.want {
+			t.Errorf("findChannel(%d, %d) = %d, want %d", test.from,
+				test.channel, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package vprinter
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxColumns is the widest print line we will put on a page.
+const MaxColumns = 200
+
+// PrinterModel describes a printer we can emulate. All of them print on the
+// same 14 7/8" greenbar paper; models with more print positions than the
+// 1403 use a smaller font so that the whole line fits.
+type PrinterModel struct {
+	Name    string
+	Columns int
+}
+
+// Model1403 is the IBM 1403, with 132 print positions.
+var Model1403 = PrinterModel{Name: "1403", Columns: maxLineCharacters}
+
+var printerModels = []PrinterModel{
+	Model1403,
+	{Name: "3203", Columns: 132},
+	{Name: "3211", Columns: 150},
+	{Name: "1443", Columns: 120},
+	{Name: "1443-144", Columns: 144},
+}
+
+// LookupPrinterModel returns the printer model with the given name. An empty
+// name returns Model1403.
+func LookupPrinterModel(name string) (PrinterModel, error) {
+	if name == "" {
+		return Model1403, nil
+	}
+	for _, m := range printerModels {
+		if strings.EqualFold(m.Name, name) {
+			return m, nil
+		}
+	}
+	return Model1403, fmt.Errorf("unknown printer model `%s`; must be one "+
+		"of: %s", name, strings.Join(PrinterModelNames(), ", "))
+}
+
+// PrinterModelNames returns the names of the printer models we know about.
+func PrinterModelNames() []string {
+	var names []string
+	for _, m := range printerModels {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// WithColumns returns a copy of the model with a different number of print
+// positions.
+func (m PrinterModel) WithColumns(columns int) (PrinterModel, error) {
+	if columns < 1 || columns > MaxColumns {
+		return m, fmt.Errorf("line width must be between 1 and %d",
+			MaxColumns)
+	}
+	m.Columns = columns
+	return m, nil
+}
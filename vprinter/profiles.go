@@ -38,6 +38,14 @@ var wornFont []byte
 func NewProfile(profile string, fontOverride []byte,
 	sizeOverride float64) (Job, error) {
 
+	return NewProfileForModel(profile, Model1403, fontOverride, sizeOverride)
+}
+
+// NewProfileForModel creates a virtual printer with the given profile's font
+// and paper style, and the print line width of model.
+func NewProfileForModel(profile string, model PrinterModel,
+	fontOverride []byte, sizeOverride float64) (Job, error) {
+
 	// Some profiles use the proprietary 1403 Vintage Mono font that we can't
 	// ship with the code. If the installation doesn't have that font (or
 	// another font which the configuration provides), we use IBM Plex Mono
@@ -53,43 +61,43 @@ func NewProfile(profile string, fontOverride []byte,
 
 	switch strings.ToLower(profile) {
 	case "default-green":
-		return New1403(tempFont, tempSize, 5, true, true, DarkGreen, LightGreen)
+		return newPrinter(model, tempFont, tempSize, 5, true, true, DarkGreen, LightGreen)
 	case "default-green-noskip":
-		return New1403(tempFont, tempSize, 0, true, true, DarkGreen, LightGreen)
+		return newPrinter(model, tempFont, tempSize, 0, true, true, DarkGreen, LightGreen)
 	case "default-blue":
-		return New1403(tempFont, tempSize, 5, true, true, DarkBlue, LightBlue)
+		return newPrinter(model, tempFont, tempSize, 5, true, true, DarkBlue, LightBlue)
 	case "default-blue-noskip":
-		return New1403(tempFont, tempSize, 0, true, true, DarkBlue, LightBlue)
+		return newPrinter(model, tempFont, tempSize, 0, true, true, DarkBlue, LightBlue)
 	case "default-plain":
-		return New1403(tempFont, tempSize, 5, true, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, tempFont, tempSize, 5, true, false, ColorRGB{}, ColorRGB{})
 	case "default-plain-noskip":
-		return New1403(tempFont, tempSize, 0, true, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, tempFont, tempSize, 0, true, false, ColorRGB{}, ColorRGB{})
 	case "retro-green":
-		return New1403(wornFont, 10, 5, true, true, DarkGreen, LightGreen)
+		return newPrinter(model, wornFont, 10, 5, true, true, DarkGreen, LightGreen)
 	case "retro-green-noskip":
-		return New1403(wornFont, 10, 0, true, true, DarkGreen, LightGreen)
+		return newPrinter(model, wornFont, 10, 0, true, true, DarkGreen, LightGreen)
 	case "retro-blue":
-		return New1403(wornFont, 10, 5, true, true, DarkBlue, LightBlue)
+		return newPrinter(model, wornFont, 10, 5, true, true, DarkBlue, LightBlue)
 	case "retro-blue-noskip":
-		return New1403(wornFont, 10, 0, true, true, DarkBlue, LightBlue)
+		return newPrinter(model, wornFont, 10, 0, true, true, DarkBlue, LightBlue)
 	case "retro-plain":
-		return New1403(wornFont, 10, 5, true, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, wornFont, 10, 5, true, false, ColorRGB{}, ColorRGB{})
 	case "retro-plain-noskip":
-		return New1403(wornFont, 10, 0, true, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, wornFont, 10, 0, true, false, ColorRGB{}, ColorRGB{})
 	case "modern-green":
-		return New1403(defaultFont, 11.4, 5, false, true, DarkGreen, LightGreen)
+		return newPrinter(model, defaultFont, 11.4, 5, false, true, DarkGreen, LightGreen)
 	case "modern-green-noskip":
-		return New1403(defaultFont, 11.4, 0, false, true, DarkGreen, LightGreen)
+		return newPrinter(model, defaultFont, 11.4, 0, false, true, DarkGreen, LightGreen)
 	case "modern-blue":
-		return New1403(defaultFont, 11.4, 5, false, true, DarkBlue, LightBlue)
+		return newPrinter(model, defaultFont, 11.4, 5, false, true, DarkBlue, LightBlue)
 	case "modern-blue-noskip":
-		return New1403(defaultFont, 11.4, 0, false, true, DarkBlue, LightBlue)
+		return newPrinter(model, defaultFont, 11.4, 0, false, true, DarkBlue, LightBlue)
 	case "modern-plain":
-		return New1403(defaultFont, 11.4, 5, false, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, defaultFont, 11.4, 5, false, false, ColorRGB{}, ColorRGB{})
 	case "modern-plain-noskip":
-		return New1403(defaultFont, 11.4, 0, false, false, ColorRGB{}, ColorRGB{})
+		return newPrinter(model, defaultFont, 11.4, 0, false, false, ColorRGB{}, ColorRGB{})
 	default:
 		// default is the same as default-green
-		return New1403(tempFont, tempSize, 5, true, true, DarkGreen, LightGreen)
+		return newPrinter(model, tempFont, tempSize, 5, true, true, DarkGreen, LightGreen)
 	}
 }
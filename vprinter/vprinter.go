@@ -41,6 +41,16 @@ type Job interface {
 	// Returns the current number of pages in the job so far.
 	NewPage() int
 
+	// SkipToChannel advances the paper to the next line, starting with the
+	// current line, that is punched in the given channel (1-12) of the forms
+	// control buffer. Returns the current number of pages in the job so far.
+	SkipToChannel(channel int) int
+
+	// LoadFCB replaces the forms control buffer that determines the length
+	// of the form and the lines each channel skips to. A nil FCB restores
+	// the printer's default.
+	LoadFCB(fcb *FCB)
+
 	// EndJob instructs the virtual printer to end the job and write the
 	// output (e.g. the PDF of all lines and pages for this job) to the
 	// io.Writer. Will return the total number of pages.
@@ -339,7 +339,9 @@ func (db *boltimpl) DeleteInactiveUsers(inactive,
 	return len(usersToDelete), nil
 }
 
-func (db *boltimpl) LogJob(email, jobinfo string, pages int, pdf []byte) error {
+func (db *boltimpl) LogJob(email, jobinfo string, metadata model.JobMetadata,
+	pages int, pdf []byte) error {
+
 	err := db.bdb.Update(func(tx *bolt.Tx) error {
 		userBucket := tx.Bucket([]byte(userBucketName))
 		logBucket := tx.Bucket([]byte(jobLogBucketName))
@@ -377,11 +379,12 @@ func (db *boltimpl) LogJob(email, jobinfo string, pages int, pdf []byte) error {
 			return err
 		}
 		logentry := model.JobLogEntry{
-			ID:      nextID,
-			Email:   user.Email,
-			Pages:   pages,
-			Time:    user.LastJob,
-			JobInfo: jobinfo,
+			ID:       nextID,
+			Email:    user.Email,
+			Pages:    pages,
+			Time:     user.LastJob,
+			JobInfo:  jobinfo,
+			Metadata: metadata,
 		}
 
 		if len(pdf) > 0 {
@@ -0,0 +1,52 @@
+package mailer
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestEncodeHeader(t *testing.T) {
+	var testcases = []string{
+		"Virtual 1403 printout J12_MYJOB",
+		"Virtual 1403 printout JOB 12 MYJOB (ÉTÉ)",
+		"Virtual 1403 printout X\r\nBcc: someone@example.com",
+	}
+
+	var dec mime.WordDecoder
+	for _, c := range testcases {
+		encoded := encodeHeader(c)
+		if strings.ContainsAny(encoded, "\r\n") {
+			t.Errorf("encoded header %q contains a line break", encoded)
+		}
+		for _, r := range encoded {
+			if r > '~' {
+				t.Errorf("encoded header %q isn't ASCII", encoded)
+				break
+			}
+		}
+		decoded, err := dec.DecodeHeader(encoded)
+		if err != nil || decoded != c {
+			t.Errorf("got %q (%v) decoding %q, want %q", decoded, err,
+				encoded, c)
+		}
+	}
+}
@@ -26,6 +26,41 @@ type JobLogEntry struct {
 	Time     time.Time
 	Pages    int
 	JobInfo  string
+	Metadata JobMetadata
 	HasPDF   bool
 	ShareKey string `json:"-"` // just used by the web UI
 }
+
+// JobMetadata is what the agent learned about a job from its separator
+// pages, sent to us with M: print directives. Any of the fields may be
+// empty.
+type JobMetadata struct {
+	Number     string
+	Name       string
+	Type       string
+	Programmer string
+	Room       string
+	Class      string
+	Start      time.Time
+	End        time.Time
+}
+
+// Description returns a human-friendly description of the job, such as
+// "JOB 1234 MYJOB (HERC01)", or an empty string if we don't know the name of
+// the job.
+func (m JobMetadata) Description() string {
+	if m.Name == "" {
+		return ""
+	}
+	desc := m.Name
+	if m.Number != "" {
+		desc = m.Number + " " + desc
+	}
+	if m.Type != "" {
+		desc = m.Type + " " + desc
+	}
+	if m.Programmer != "" {
+		desc = desc + " (" + m.Programmer + ")"
+	}
+	return desc
+}
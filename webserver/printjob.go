@@ -27,23 +27,26 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/klauspost/compress/zstd"
 
 	"github.com/racingmars/virtual1403/vprinter"
 	"github.com/racingmars/virtual1403/webserver/mailer"
+	"github.com/racingmars/virtual1403/webserver/model"
 )
 
 // printjob is the handler for the primary use case of the server: receive
 // the text of a print job and generate a PDF. Clients send the data in the
 // request body as a series of print directives. Print directives must be
 // valid UTF-8 strings separated by CRLF, CR, or LF. Each print directive
-// contains a one-letter prefix (L, O, P), followed by a colon (:), followed
-// by the (optional) data for the directive. Each HTTP POST represents one
-// print job.
+// contains a one-letter prefix (L, O, P, C, J, M), followed by a colon (:),
+// followed by the (optional) data for the directive. Each HTTP POST
+// represents one print job.
 //
 // Request requirements:
 //
@@ -58,6 +61,20 @@ import (
 // 6. An optional query parameter named "profile" selects the font and paper
 //    style. No profile parameter, or an unknown value, will result in the
 //    default profile. Profile names are *not* case-sensitive.
+// 7. An optional query parameter named "model" selects the printer model,
+//    which determines the line width. No model parameter, or an unknown
+//    value, will result in the 1403's 132 columns. An optional "columns"
+//    query parameter overrides the model's line width.
+// 8. An optional query parameter named "fcb" loads a forms control buffer
+//    into the virtual printer, in the format accepted by vprinter.ParseFCB.
+//    An invalid FCB results in a 400 response.
+//
+// Every response carries an X-Print-Job-Version header with the version of
+// this protocol the server speaks, so clients can tell when a job was
+// rejected by an older server and send it again. Version 2 added the C: and
+// M: directives and the "model", "columns", and "fcb" query parameters;
+// servers without the header only understand version 1, and reject C: and
+// M: as invalid directives.
 //
 // Print directives:
 //
@@ -65,7 +82,8 @@ import (
 //
 // L:[line data]  - One line of text to print, after which the next line will
 //                  print on the next line on the page. <line data> must be a
-//                  valid UTF-8 string, and will be trimmed to 132 characters.
+//                  valid UTF-8 string, and will be trimmed to the line width
+//                  of the printer model (132 characters by default).
 //                  <line data> may be empty, in which case a blank line will
 //                  be printed.
 // O:[line data]  - One line of text to print, after which the "virtual
@@ -76,11 +94,20 @@ import (
 //                  directives.
 // P:               Page break. This will advance the virtual printer to the
 //                  next page. Any data on a P: directive is ignored.
+// C:[channel]    - Channel skip. This will advance the virtual printer to the
+//                  next line punched in the carriage control channel (1-12)
+//                  of the forms control buffer.
 // J:[job data]   - Job data. This optional component may contain a string up
 //                  to 25 characters long, containing the characters
 //                  [a-zA-Z0-9_] with an identifier for the job that may be
 //                  included in the generated filename. If there are multiple
 //                  J: directives, only the last one is used.
+// M:[key]=[value] - Job metadata. These optional directives describe the job
+//                  as found on its separator pages. The known keys are
+//                  number, name, type, programmer, room, class, start, and
+//                  end; start and end are RFC 3339 timestamps. Values are
+//                  trimmed to 64 characters and may not contain control
+//                  characters. Unknown keys are ignored.
 //
 // Responses:
 //
@@ -105,6 +132,8 @@ import (
 //       The virtual 1403 printer experienced a paper jam and is awaiting
 //       operator intervention.
 func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Print-Job-Version", printJobVersion)
+
 	// We only accept POST requests.
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -192,12 +221,27 @@ func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
 	// Create our virtual printer.
 	profileName := r.URL.Query().Get("profile")
 	log.Printf("INFO:  requested profile: %s", profileName)
-	job, err := vprinter.NewProfile(profileName, a.font, 11.4)
+	printerModel, err := requestPrinterModel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := vprinter.NewProfileForModel(profileName, printerModel,
+		a.font, 11.4)
 	if err != nil {
 		log.Printf("ERROR: couldn't create virtual printer: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if fcbSpec := r.URL.Query().Get("fcb"); fcbSpec != "" {
+		fcb, err := vprinter.ParseFCB(fcbSpec)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid FCB: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+		job.LoadFCB(fcb)
+	}
 
 	// Process the directives in the request body and send them to the
 	// virtual printer.
@@ -208,8 +252,8 @@ func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
 		pageQuota = 0
 		maxLines = 0
 	}
-	jobinfo, err := processPrintDirectives(d, job, pageQuota,
-		maxLines)
+	jobinfo, metadata, err := processPrintDirectives(d, job,
+		printerModel.Columns, pageQuota, maxLines)
 	if err != nil {
 		log.Printf("INFO:  invalid print directives from %s: %v",
 			user.Email, err)
@@ -250,8 +294,12 @@ func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
 	if !user.DisableEmailDelivery {
 		attachmentName := fmt.Sprintf("virtual1403_%s.pdf", jobname)
 
+		subject := metadata.Description()
+		if subject == "" {
+			subject = jobinfo
+		}
 		err = mailer.Send(a.mailconfig, user.Email,
-			"Virtual 1403 printout "+jobinfo,
+			"Virtual 1403 printout "+subject,
 			"The intern in the machine room has carefully collated your job and "+
 				"prepared it for delivery. Please find it attached to this "+
 				"message.\r\n\r\n"+
@@ -270,7 +318,7 @@ func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Try to log the job to the database
-	if err = a.db.LogJob(user.Email, jobinfo, pagecount,
+	if err = a.db.LogJob(user.Email, jobinfo, metadata, pagecount,
 		pdfBuffer.Bytes()); err != nil {
 		log.Printf("ERROR: couldn't log job: %v", err)
 	}
@@ -278,6 +326,12 @@ func (a *application) printjob(w http.ResponseWriter, r *http.Request) {
 	// HTTP 200 will be returned if we make it this far.
 }
 
+// printJobVersion is the version of the print job protocol we speak.
+const printJobVersion = "2"
+
+// maxMetadataLen is the number of runes job metadata values are trimmed to.
+const maxMetadataLen = 64
+
 // jobInfoRegex matches valid/allowed job info data
 var jobInfoRegex = regexp.MustCompile(`^[a-zA-z0-9_]{0,25}$`)
 
@@ -285,9 +339,10 @@ var jobInfoRegex = regexp.MustCompile(`^[a-zA-z0-9_]{0,25}$`)
 // job, returning an error if the input data is invalid. Processing will stop
 // after maxpages if maxpages > 0 or after maxlines if maxlines > 0.
 func processPrintDirectives(r io.Reader, job vprinter.Job,
-	maxpages, maxlines int) (string, error) {
+	columns, maxpages, maxlines int) (string, model.JobMetadata, error) {
 
 	var jobinfo string
+	var metadata model.JobMetadata
 	var lines int
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
@@ -296,35 +351,43 @@ func processPrintDirectives(r io.Reader, job vprinter.Job,
 			continue
 		}
 		if len(line) < 2 {
-			return "", errors.New("line received without directive")
+			return "", metadata, errors.New("line received without directive")
 		}
 		directive := line[0:2]
 		param := line[2:]
 
-		// In all cases, param must be a valid UTF-8 string <= 132 runes, so
-		// we'll take care of that now.
+		// In all cases, param must be a valid UTF-8 string, so we'll take
+		// care of that now. Lines are trimmed to the printer's line width;
+		// job info and metadata have their own limits.
 		if !utf8.ValidString(param) {
-			return "", errors.New("invalid UTF-8 string")
+			return "", metadata, errors.New("invalid UTF-8 string")
 		}
 
-		// Trim to 132 runes
-		param = trimToRuneLen(param, 132)
-
 		var pages int
 		switch directive {
 		case "L:":
-			pages = job.AddLine(param, true)
+			pages = job.AddLine(trimToRuneLen(param, columns), true)
 		case "O:":
-			pages = job.AddLine(param, false)
+			pages = job.AddLine(trimToRuneLen(param, columns), false)
 		case "P:":
 			pages = job.NewPage()
+		case "C:":
+			channel, err := strconv.Atoi(param)
+			if err != nil || channel < 1 || channel > vprinter.MaxChannel {
+				return "", metadata, errors.New("invalid channel directive")
+			}
+			pages = job.SkipToChannel(channel)
 		case "J:":
 			if !jobInfoRegex.MatchString(param) {
-				return "", errors.New("invalid job data directive")
+				return "", metadata, errors.New("invalid job data directive")
 			}
 			jobinfo = param
+		case "M:":
+			if err := setMetadata(&metadata, param); err != nil {
+				return "", metadata, err
+			}
 		default:
-			return "", errors.New("invalid directive received")
+			return "", metadata, errors.New("invalid directive received")
 		}
 		lines++
 
@@ -336,9 +399,68 @@ func processPrintDirectives(r io.Reader, job vprinter.Job,
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return "", err
+		return "", metadata, err
+	}
+	return jobinfo, metadata, nil
+}
+
+// requestPrinterModel returns the printer model requested by the "model"
+// and "columns" query parameters. Unknown models are treated as a 1403, like
+// unknown profiles, but an invalid number of columns is an error.
+func requestPrinterModel(r *http.Request) (vprinter.PrinterModel, error) {
+	modelName := r.URL.Query().Get("model")
+	printerModel, err := vprinter.LookupPrinterModel(modelName)
+	if err != nil {
+		log.Printf("INFO:  requested unknown printer model: %s", modelName)
+	}
+
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		n, err := strconv.Atoi(columns)
+		if err != nil {
+			return printerModel, fmt.Errorf("invalid columns `%s`", columns)
+		}
+		return printerModel.WithColumns(n)
+	}
+	return printerModel, nil
+}
+
+// setMetadata applies the key=value parameter of an M: directive to
+// metadata.
+func setMetadata(metadata *model.JobMetadata, param string) error {
+	key, value, found := strings.Cut(param, "=")
+	if !found {
+		return errors.New("invalid job metadata directive")
+	}
+	// Metadata ends up in email headers and the web UI, so we don't allow
+	// anything that isn't printable.
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return errors.New("invalid job metadata value")
+	}
+	value = trimToRuneLen(value, maxMetadataLen)
+
+	var err error
+	switch key {
+	case "number":
+		metadata.Number = value
+	case "name":
+		metadata.Name = value
+	case "type":
+		metadata.Type = value
+	case "programmer":
+		metadata.Programmer = value
+	case "room":
+		metadata.Room = value
+	case "class":
+		metadata.Class = value
+	case "start":
+		metadata.Start, err = time.Parse(time.RFC3339, value)
+	case "end":
+		metadata.End, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return errors.New("invalid job metadata time")
 	}
-	return jobinfo, nil
+	return nil
 }
 
 // trimToRuneLen trims the input string, str, to no more than n runes. The
@@ -0,0 +1,128 @@
+package main
+
+// Copyright 2026 Matthew R. Wilson <mwilson@mattwilson.org>
+//
+// This file is part of virtual1403
+// <https://github.com/racingmars/virtual1403>.
+//
+// virtual1403 is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// virtual1403 is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with virtual1403. If not, see <https://www.gnu.org/licenses/>.
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/racingmars/virtual1403/vprinter"
+	"github.com/racingmars/virtual1403/webserver/model"
+)
+
+// recordingJob is a vprinter.Job that records the printer operations it
+// receives. Only the operations that print directives use are implemented.
+type recordingJob struct {
+	vprinter.Job
+	ops []string
+}
+
+func (j *recordingJob) AddLine(text string, linefeed bool) int {
+	if linefeed {
+		j.ops = append(j.ops, "L:"+text)
+	} else {
+		j.ops = append(j.ops, "O:"+text)
+	}
+	return 1
+}
+
+func (j *recordingJob) NewPage() int {
+	j.ops = append(j.ops, "P:")
+	return 1
+}
+
+func (j *recordingJob) SkipToChannel(channel int) int {
+	j.ops = append(j.ops, "C:"+strconv.Itoa(channel))
+	return 1
+}
+
+func TestPrintDirectives(t *testing.T) {
+	start := time.Date(2026, 10, 17, 10, 31, 7, 0, time.UTC)
+	var testcases = []struct {
+		input    string
+		columns  int
+		ops      []string
+		jobinfo  string
+		metadata model.JobMetadata
+		err      bool
+	}{
+		{input: "L:ONE\nO:TWO\nP:\nC:1\nC:12\n",
+			ops: []string{"L:ONE", "O:TWO", "P:", "C:1", "C:12"}},
+		{input: "C:0\n", err: true},
+		{input: "C:13\n", err: true},
+		{input: "C:x\n", err: true},
+		{input: "J:J12_MYJOB\nM:number=12\nM:name=MYJOB\nM:type=JOB\n" +
+			"M:programmer=HERC01\nM:room=123\nM:class=A\n" +
+			"M:start=2026-10-17T10:31:07Z\nM:unknown=ignored\n",
+			jobinfo: "J12_MYJOB",
+			metadata: model.JobMetadata{Number: "12", Name: "MYJOB",
+				Type: "JOB", Programmer: "HERC01", Room: "123", Class: "A",
+				Start: start}},
+		{input: "M:name=" + strings.Repeat("X", 70) + "\n",
+			metadata: model.JobMetadata{Name: strings.Repeat("X", 64)}},
+		{input: "M:name=MY\rJOB\n", err: true},
+		{input: "M:name=MY\x1bJOB\n", err: true},
+		{input: "M:programmer=A\u0085B\n", err: true},
+		{input: "M:name\n", err: true},
+		{input: "M:start=yesterday\n", err: true},
+		{input: "X:\n", err: true},
+		{input: "L:ONE TWO THREE FOUR FIVE\nO:OVERSTRIKE LINE TEXT OVER\n" +
+			"J:J12_A_LONGER_JOB_NAME\nM:programmer=HERC01 SYSTEM PROGRAMMER\n" +
+			"M:start=2026-10-17T10:31:07Z\n",
+			columns: 20,
+			ops: []string{"L:ONE TWO THREE FOUR F",
+				"O:OVERSTRIKE LINE TEXT"},
+			jobinfo: "J12_A_LONGER_JOB_NAME",
+			metadata: model.JobMetadata{
+				Programmer: "HERC01 SYSTEM PROGRAMMER", Start: start}},
+	}
+
+	for _, c := range testcases {
+		var job recordingJob
+		columns := c.columns
+		if columns == 0 {
+			columns = 132
+		}
+		jobinfo, metadata, err := processPrintDirectives(
+			strings.NewReader(c.input), &job, columns, 0, 0)
+		if c.err {
+			if err == nil {
+				t.Errorf("expected error for %q", c.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.input, err)
+			continue
+		}
+		if strings.Join(job.ops, "|") != strings.Join(c.ops, "|") {
+			t.Errorf("got %q instead of %q for %q", job.ops, c.ops, c.input)
+		}
+		if jobinfo != c.jobinfo {
+			t.Errorf("got job info %q instead of %q for %q", jobinfo,
+				c.jobinfo, c.input)
+		}
+		if metadata != c.metadata {
+			t.Errorf("got metadata %+v instead of %+v for %q", metadata,
+				c.metadata, c.input)
+		}
+	}
+}